@@ -0,0 +1,211 @@
+// Package bench measures the throughput to a server for combinations of
+// transfer settings, using synthetic data that the server discards
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"justdatacopier/internal/compression"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/protocol"
+)
+
+// Synthetic data kinds
+const (
+	DataRandom = "random" // Incompressible, like media and archives
+	DataText   = "text"   // Compressible, like logs and database dumps
+)
+
+// Options select the server and the settings to measure. Every combination of
+// chunk size, buffer size, stream count and compression is measured.
+type Options struct {
+	ServerAddress string
+	Dialer        *network.Dialer
+	ChunkSizes    []int64
+	BufferSizes   []int
+	Streams       []int
+	Compression   []bool
+	Data          string
+	Duration      time.Duration // Measuring time for each combination
+	Timeout       time.Duration
+}
+
+// Result is the throughput measured for one combination of settings
+type Result struct {
+	ChunkSize       int64   `json:"chunk_size"`
+	BufferSize      int     `json:"buffer_size"`
+	Streams         int     `json:"streams"`
+	Compression     bool    `json:"compression"`
+	Bytes           int64   `json:"bytes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	BytesPerSecond  float64 `json:"bytes_per_second"`
+}
+
+// Run measures each combination of settings in turn, calling report after each
+func Run(ctx context.Context, opts Options, report func(Result)) ([]Result, error) {
+	var results []Result
+
+	for _, chunkSize := range opts.ChunkSizes {
+		data := syntheticData(opts.Data, chunkSize)
+
+		for _, compress := range opts.Compression {
+			for _, bufferSize := range opts.BufferSizes {
+				for _, streams := range opts.Streams {
+					result, err := measure(ctx, opts, data, compress, bufferSize, streams)
+					if err != nil {
+						return results, err
+					}
+					result.Compression = compress
+					results = append(results, result)
+					if report != nil {
+						report(result)
+					}
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// measure sends chunks of data over streams parallel connections for the
+// configured duration
+func measure(ctx context.Context, opts Options, data []byte, compress bool, bufferSize, streams int) (Result, error) {
+	conns := make([]net.Conn, 0, streams)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for i := 0; i < streams; i++ {
+		conn, err := opts.Dialer.Dial(opts.ServerAddress)
+		if err != nil {
+			return Result{}, errors.NewNetworkError("dial", opts.ServerAddress, err)
+		}
+		network.OptimizeTCPConnection(conn)
+		conns = append(conns, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var total atomic.Int64
+	var wg sync.WaitGroup
+	errs := make(chan error, streams)
+	start := time.Now()
+
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			if err := sendChunks(ctx, conn, data, compress, opts.Data, bufferSize, opts.Timeout, &total); err != nil {
+				errs <- err
+			}
+		}(conn)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		ChunkSize:       int64(len(data)),
+		BufferSize:      bufferSize,
+		Streams:         streams,
+		Bytes:           total.Load(),
+		DurationSeconds: elapsed.Seconds(),
+		BytesPerSecond:  float64(total.Load()) / elapsed.Seconds(),
+	}, nil
+}
+
+// sendChunks sends chunks one at a time until ctx is done, waiting for the
+// server to acknowledge each like a transfer without adaptive pacing, and adds
+// the uncompressed size of acknowledged chunks to total. With compression,
+// each chunk is compressed as it is sent, as in a transfer, at the level a file
+// of the kind of data would get.
+func sendChunks(ctx context.Context, conn net.Conn, data []byte, compress bool, kind string, bufferSize int,
+	timeout time.Duration, total *atomic.Int64) error {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriterSize(conn, bufferSize)
+	filename := "bench." + dataExtension(kind)
+
+	for ctx.Err() == nil {
+		payload := data
+		if compress {
+			var err error
+			if payload, err = compression.CompressData(data, filename); err != nil {
+				return err
+			}
+		}
+
+		if err := protocol.SendCommand(writer, protocol.CmdProbe); err != nil {
+			return err
+		}
+		if err := protocol.SendInt64(writer, int64(len(payload))); err != nil {
+			return err
+		}
+		if _, err := writer.Write(payload); err != nil {
+			return errors.NewNetworkError("send_chunk", conn.RemoteAddr().String(), err)
+		}
+		if err := protocol.FlushWriter(writer); err != nil {
+			return err
+		}
+
+		readCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		response, err := protocol.ReadCommand(readCtx, reader)
+		cancel()
+		if err != nil {
+			return errors.NewNetworkError("read_command", conn.RemoteAddr().String(), err)
+		}
+		if response != protocol.CmdPong {
+			return errors.NewProtocolError("bench", "server does not support benchmarks, upgrade it", nil)
+		}
+
+		total.Add(int64(len(data)))
+	}
+
+	return nil
+}
+
+// syntheticData generates size bytes of the given kind
+func syntheticData(kind string, size int64) []byte {
+	random := rand.New(rand.NewSource(1))
+
+	if kind != DataText {
+		data := make([]byte, size)
+		random.Read(data)
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(int(size) + 128)
+	levels := []string{"INFO", "WARN", "DEBUG", "ERROR"}
+	for int64(buf.Len()) < size {
+		fmt.Fprintf(&buf, "2025-06-%02d 12:%02d:%02d %s request_id=%08x user=%d path=/api/v1/items/%d status=%d duration_ms=%d\n",
+			random.Intn(28)+1, random.Intn(60), random.Intn(60), levels[random.Intn(len(levels))],
+			random.Uint32(), random.Intn(10000), random.Intn(100000), 200+random.Intn(4)*100, random.Intn(500))
+	}
+	return buf.Bytes()[:size]
+}
+
+// dataExtension returns a file extension for the kind of data, which selects
+// the compression level a file of that kind would get
+func dataExtension(kind string) string {
+	if kind == DataText {
+		return "log"
+	}
+	return "bin"
+}
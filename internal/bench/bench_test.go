@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/protocol"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probeServer acknowledges bandwidth probes like a jdc server
+func probeServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				writer := bufio.NewWriter(conn)
+				for {
+					cmd, err := protocol.ReadCommand(context.Background(), reader)
+					if err != nil || cmd != protocol.CmdProbe {
+						return
+					}
+					size, err := protocol.ReadInt64(context.Background(), reader)
+					if err != nil {
+						return
+					}
+					if _, err := io.CopyN(io.Discard, reader, size); err != nil {
+						return
+					}
+					protocol.SendCommand(writer, protocol.CmdPong)
+					protocol.FlushWriter(writer)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	opts := Options{
+		ServerAddress: probeServer(t),
+		Dialer:        &network.Dialer{Timeout: time.Second},
+		ChunkSizes:    []int64{64 * 1024},
+		BufferSizes:   []int{32 * 1024},
+		Streams:       []int{1, 2},
+		Compression:   []bool{false, true},
+		Data:          DataText,
+		Duration:      50 * time.Millisecond,
+		Timeout:       time.Second,
+	}
+
+	var reported int
+	results, err := Run(context.Background(), opts, func(Result) { reported++ })
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, 4, reported)
+
+	for _, r := range results {
+		assert.Equal(t, int64(64*1024), r.ChunkSize)
+		assert.Positive(t, r.Bytes)
+		assert.Zero(t, r.Bytes%r.ChunkSize)
+		assert.Positive(t, r.BytesPerSecond)
+	}
+	assert.True(t, results[2].Compression)
+	assert.Equal(t, 2, results[3].Streams)
+}
+
+func TestRecommend(t *testing.T) {
+	results := []Result{
+		{ChunkSize: 1 << 20, BufferSize: 1 << 18, Streams: 1, BytesPerSecond: 90},
+		{ChunkSize: 4 << 20, BufferSize: 1 << 18, Streams: 1, BytesPerSecond: 97},
+		{ChunkSize: 8 << 20, BufferSize: 1 << 20, Streams: 1, BytesPerSecond: 99},
+		{ChunkSize: 8 << 20, BufferSize: 1 << 20, Streams: 1, Compression: true, BytesPerSecond: 100},
+		{ChunkSize: 8 << 20, BufferSize: 1 << 20, Streams: 4, BytesPerSecond: 98},
+	}
+
+	// The smallest uncompressed single-stream settings within 5% of the best
+	assert.Equal(t, results[1], Recommend(results))
+
+	// A clearly faster configuration wins
+	results = append(results, Result{ChunkSize: 8 << 20, BufferSize: 1 << 20, Streams: 4, BytesPerSecond: 200})
+	assert.Equal(t, results[5], Recommend(results))
+}
+
+func TestParseSizes(t *testing.T) {
+	sizes, err := parseSizes("chunks", "512KB, 1mb,2097152", 8<<20)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{512 << 10, 1 << 20, 2 << 20}, sizes)
+
+	for _, list := range []string{"", "0", "16MB", "1TB", "1.5MB", "-1MB"} {
+		_, err := parseSizes("chunks", list, 8<<20)
+		assert.Error(t, err, list)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512KB", formatSize(512*1024))
+	assert.Equal(t, "4MB", formatSize(4*1024*1024))
+	assert.Equal(t, "1536KB", formatSize(1536*1024))
+	assert.Equal(t, "1000B", formatSize(1000))
+}
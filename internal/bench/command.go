@@ -0,0 +1,230 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/protocol"
+)
+
+// closeEnough is the share of the best throughput within which simpler
+// settings are recommended instead: fewer streams, no compression, then
+// smaller chunks and buffers
+const closeEnough = 0.95
+
+// RunCommand implements `jdc bench`, measuring the throughput to a running
+// server for each combination of settings and printing a recommended
+// configuration to out
+func RunCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	server := fs.String("connect", config.DefaultServerAddr, "Server address to benchmark")
+	proxy := fs.String("proxy", "", "Proxy to connect through, as for jdc send (default: ALL_PROXY, HTTPS_PROXY or HTTP_PROXY)")
+	chunks := fs.String("chunks", "1MB,2MB,4MB,8MB", "Comma-separated chunk sizes to measure")
+	buffers := fs.String("buffers", "256KB,512KB,1MB", "Comma-separated buffer sizes to measure")
+	streams := fs.String("streams", "1,2,4", "Comma-separated numbers of parallel connections to measure")
+	compress := fs.String("compress", "false,true", "Compression settings to measure: false, true or both")
+	data := fs.String("data", DataRandom, "Synthetic data: random (incompressible) or text (compressible)")
+	duration := fs.Duration("duration", 2*time.Second, "Measuring time for each combination")
+	timeout := fs.Duration("timeout", config.DefaultTimeout, "Connect and acknowledgement timeout")
+	asJSON := fs.Bool("json", false, "Print the results and recommendation as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errors.NewValidationError("arguments", args, err.Error())
+	}
+
+	envProxy, noProxy := config.ProxyFromEnvironment(os.Environ())
+	if *proxy == "" {
+		*proxy = envProxy
+	}
+	if *proxy == config.ProxyDirect {
+		*proxy = ""
+	}
+	if *proxy != "" {
+		if _, err := config.ParseProxy(*proxy); err != nil {
+			return errors.NewValidationError("proxy", "", err.Error())
+		}
+	}
+
+	opts := Options{
+		ServerAddress: *server,
+		Dialer:        &network.Dialer{Proxy: *proxy, NoProxy: noProxy, Timeout: *timeout},
+		Data:          *data,
+		Duration:      *duration,
+		Timeout:       *timeout,
+	}
+
+	var err error
+	if opts.ChunkSizes, err = parseSizes("chunks", *chunks, protocol.MaxProbeSize); err != nil {
+		return err
+	}
+	bufferSizes, err := parseSizes("buffers", *buffers, 64*1024*1024)
+	if err != nil {
+		return err
+	}
+	for _, size := range bufferSizes {
+		opts.BufferSizes = append(opts.BufferSizes, int(size))
+	}
+	if opts.Streams, err = parseCounts("streams", *streams); err != nil {
+		return err
+	}
+	if opts.Compression, err = parseBools("compress", *compress); err != nil {
+		return err
+	}
+	if opts.Data != DataRandom && opts.Data != DataText {
+		return errors.NewValidationError("data", opts.Data, "must be random or text")
+	}
+	if opts.Duration <= 0 {
+		return errors.NewValidationError("duration", opts.Duration, "must be positive")
+	}
+
+	combinations := len(opts.ChunkSizes) * len(opts.BufferSizes) * len(opts.Streams) * len(opts.Compression)
+
+	// Rows are printed as each combination finishes, so columns have fixed widths
+	report := func(Result) {}
+	if !*asJSON {
+		fmt.Fprintf(out, "Benchmarking %s: %d combinations of %s each, %s data\n\n",
+			opts.ServerAddress, combinations, opts.Duration, opts.Data)
+		fmt.Fprintf(out, "%-8s  %-8s  %-7s  %-8s  %s\n", "CHUNK", "BUFFER", "STREAMS", "COMPRESS", "MB/S")
+		report = func(r Result) {
+			fmt.Fprintf(out, "%-8s  %-8s  %-7d  %-8v  %.2f\n",
+				formatSize(r.ChunkSize), formatSize(int64(r.BufferSize)), r.Streams, r.Compression,
+				r.BytesPerSecond/(1024*1024))
+		}
+	}
+
+	results, err := Run(context.Background(), opts, report)
+	if err != nil {
+		return err
+	}
+	best := Recommend(results)
+
+	if *asJSON {
+		return json.NewEncoder(out).Encode(map[string]interface{}{
+			"results":     results,
+			"recommended": best,
+		})
+	}
+
+	fmt.Fprintf(out, "\nRecommended: -chunk %d -buffer %d -compress=%v (%.2f MB/s)\n",
+		best.ChunkSize, best.BufferSize, best.Compression, best.BytesPerSecond/(1024*1024))
+	if best.Streams > 1 {
+		fmt.Fprintf(out, "Throughput is highest with %d transfers running in parallel\n", best.Streams)
+	}
+	return nil
+}
+
+// Recommend returns the result with the highest throughput, preferring simpler
+// settings whose throughput is close to it
+func Recommend(results []Result) Result {
+	var best Result
+	for _, r := range results {
+		if r.BytesPerSecond > best.BytesPerSecond {
+			best = r
+		}
+	}
+
+	recommended := best
+	for _, r := range results {
+		if r.BytesPerSecond >= closeEnough*best.BytesPerSecond && simpler(r, recommended) {
+			recommended = r
+		}
+	}
+	return recommended
+}
+
+// simpler reports whether the settings of a use fewer resources than those of b
+func simpler(a, b Result) bool {
+	switch {
+	case a.Streams != b.Streams:
+		return a.Streams < b.Streams
+	case a.Compression != b.Compression:
+		return !a.Compression
+	case a.ChunkSize != b.ChunkSize:
+		return a.ChunkSize < b.ChunkSize
+	default:
+		return a.BufferSize < b.BufferSize
+	}
+}
+
+// sizeUnits are the binary units accepted by parseSizes
+var sizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"kb": 1024,
+	"mb": 1024 * 1024,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// parseSizes parses a comma-separated list of sizes such as "512KB,1MB"
+func parseSizes(name, list string, maxSize int64) ([]int64, error) {
+	var sizes []int64
+	for _, item := range strings.Split(list, ",") {
+		value := strings.ToLower(strings.TrimSpace(item))
+		split := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+		number, unit := value, ""
+		if split >= 0 {
+			number, unit = value[:split], value[split:]
+		}
+
+		multiplier, ok := sizeUnits[unit]
+		amount, err := strconv.ParseInt(number, 10, 64)
+		if !ok || err != nil || amount <= 0 || amount > maxSize/multiplier {
+			return nil, errors.NewValidationError(name, item, fmt.Sprintf("must be a size from 1 to %s, e.g. 512KB", formatSize(maxSize)))
+		}
+		sizes = append(sizes, amount*multiplier)
+	}
+	return sizes, nil
+}
+
+// parseCounts parses a comma-separated list of positive counts
+func parseCounts(name, list string) ([]int, error) {
+	var counts []int
+	for _, item := range strings.Split(list, ",") {
+		count, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || count <= 0 || count > 64 {
+			return nil, errors.NewValidationError(name, item, "must be a number from 1 to 64")
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+// parseBools parses a comma-separated list of booleans
+func parseBools(name, list string) ([]bool, error) {
+	var values []bool
+	for _, item := range strings.Split(list, ",") {
+		value, err := strconv.ParseBool(strings.TrimSpace(item))
+		if err != nil {
+			return nil, errors.NewValidationError(name, item, "must be true or false")
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// formatSize formats a size in bytes with the largest binary unit that divides it
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024*1024 && size%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dGB", size/(1024*1024*1024))
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return fmt.Sprintf("%dMB", size/(1024*1024))
+	case size >= 1024 && size%1024 == 0:
+		return fmt.Sprintf("%dKB", size/1024)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
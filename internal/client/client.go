@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
@@ -14,40 +15,196 @@ import (
 	"justdatacopier/internal/compression"
 	"justdatacopier/internal/config"
 	"justdatacopier/internal/errors"
+	"justdatacopier/internal/events"
 	"justdatacopier/internal/filesystem"
 	"justdatacopier/internal/logging"
+	"justdatacopier/internal/metrics"
 	"justdatacopier/internal/network"
+	"justdatacopier/internal/notify"
 	"justdatacopier/internal/progress"
 	"justdatacopier/internal/protocol"
+	"justdatacopier/internal/ratelimit"
+	"justdatacopier/internal/report"
 )
 
-// Run starts the client with the given configuration
-func Run(cfg *config.Config) error {
-	slog.Info("Starting client", "server", cfg.ServerAddress)
+// Run starts the client with the given configuration. Cancelling ctx stops the
+// transfer, which still reports its outcome.
+func Run(ctx context.Context, cfg *config.Config) error {
+	notifier := notify.New(cfg)
+	defer notifier.Close()
+
+	// Expose metrics if requested
+	if cfg.MetricsAddress != "" {
+		if err := metrics.Serve(cfg.MetricsAddress); err != nil {
+			return err
+		}
+	}
+
+	// Open the JSON progress stream if requested
+	var progressOutput io.Writer
+	if cfg.ProgressJSON != "" {
+		output, err := progress.OpenJSONOutput(cfg.ProgressJSON)
+		if err != nil {
+			return err
+		}
+		progressOutput = output
+	}
+
+	if destinations := cfg.Destinations(); len(destinations) > 1 {
+		return runFanout(ctx, cfg, destinations, notifier, progressOutput)
+	}
+	return runTransfer(ctx, cfg, notifier, progressOutput, nil)
+}
+
+// runTransfer sends the file to cfg.ServerAddress as a transfer of its own,
+// with its own events, report and metrics. dest is the server's destination
+// when the file is sent to several servers at once, nil otherwise.
+func runTransfer(ctx context.Context, cfg *config.Config, notifier *notify.Notifier, progressOutput io.Writer,
+	dest *destination) error {
+	// Every log record, event and report of this transfer carries its ID
+	transferID := protocol.NewTransferID()
+	ctx = logging.WithTransferID(ctx, transferID)
+
+	startTime := time.Now()
+	rep := report.New(events.RoleClient, startTime)
+	rep.TransferID = transferID
+	metrics.ActiveTransfers.Add(1)
+	err := errors.WithTransferID(sendFile(ctx, transferID, cfg, notifier, progressOutput, rep, dest), transferID)
+	metrics.ActiveTransfers.Add(-1)
+	notifier.Send(outcomeEvent(cfg, transferID, startTime, err))
+
+	if cfg.ReportDir != "" {
+		rep.Finish(err, time.Now())
+		if _, reportErr := report.Write(cfg.ReportDir, rep); reportErr != nil {
+			slog.ErrorContext(ctx, "Failed to write transfer report", "error", reportErr)
+		}
+	}
 
-	// Get file information
-	fileInfo, err := filesystem.GetFileInfo(cfg.FilePath)
+	if err != nil {
+		metrics.TransfersFailed.Inc()
+	} else {
+		metrics.TransfersCompleted.Inc()
+	}
+
+	return err
+}
+
+// sendFile connects to the server and transfers the configured file, filling in
+// the transfer report as it goes. If the connection drops mid-transfer, the
+// client reconnects and resumes until cfg.ReconnectTimeout has passed.
+func sendFile(ctx context.Context, transferID string, cfg *config.Config, notifier *notify.Notifier,
+	progressOutput io.Writer, rep *report.Report, dest *destination) error {
+	slog.InfoContext(ctx, "Starting client", "server", cfg.ServerAddress)
+	if dest != nil {
+		defer dest.stop()
+	}
+
+	file, fileInfo, err := openSourceFile(cfg.FilePath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	if fileInfo.IsDir {
-		return errors.NewValidationError("file_path", cfg.FilePath, "cannot transfer directories")
+	rep.SourceName = fileInfo.Name
+	rep.RemoteAddr = cfg.ServerAddress
+	rep.Size = fileInfo.Size
+
+	// The network profile adjusts the configuration; reconnects keep the result
+	var sendCfg config.Config
+	limiter := ratelimit.New(cfg.RateLimit, nil)
+	limiter.SetSchedule(&cfg.RateSchedule)
+	send := func(legacy bool) error {
+		conn, err := dialServer(cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		sendCfg = *cfg
+		sendCfg.LegacyProtocol = legacy
+		return sendOverConn(ctx, conn, file, fileInfo, transferID, &sendCfg, limiter, notifier, progressOutput, rep, false, nil, dest)
 	}
 
-	// Open file for reading
-	file, err := os.Open(cfg.FilePath)
+	err = send(false)
+	if rejectedVersion(err) {
+		slog.WarnContext(ctx, "Server does not support protocol versions, retrying with the legacy handshake",
+			"chunk_size", cfg.ChunkSize)
+		err = send(true)
+	}
+
+	if err != nil && cfg.ReconnectTimeout > 0 && reconnectable(err) {
+		err = reconnect(ctx, file, fileInfo, transferID, &sendCfg, limiter, notifier, progressOutput, rep, err, dest)
+	}
+	return err
+}
+
+// rejectedVersion reports whether err is a server refusing the protocol version
+// announcement, as servers predating protocol versions answer it with an
+// unknown command error
+func rejectedVersion(err error) bool {
+	var protocolErr *errors.ProtocolError
+	return errors.As(err, &protocolErr) && protocolErr.Op == "server_error" && protocolErr.Message == "Unknown command"
+}
+
+// SendOverConn sends the file at cfg.FilePath over an established connection to
+// a receiver, as a server does to answer jdc get. The transfer is limited to
+// cfg.RateLimit and cfg.RateSchedule within the limit of totalLimiter, which
+// may be nil. No webhook events or reports are produced for the transfer.
+func SendOverConn(conn net.Conn, cfg *config.Config, totalLimiter *ratelimit.Limiter) error {
+	transferID := protocol.NewTransferID()
+	ctx := logging.WithTransferID(context.Background(), transferID)
+
+	file, fileInfo, err := openSourceFile(cfg.FilePath)
 	if err != nil {
-		return errors.NewFileSystemError("open", cfg.FilePath, err)
+		return err
 	}
 	defer file.Close()
 
-	// Connect to server
-	conn, err := net.Dial("tcp", cfg.ServerAddress)
+	// The network profile adjusts the configuration, so work on a copy
+	sendCfg := *cfg
+	sendCfg.ShowProgress = false
+	rep := report.New(events.RoleClient, time.Now())
+
+	limiter := ratelimit.New(cfg.RateLimit, totalLimiter)
+	limiter.SetSchedule(&cfg.RateSchedule)
+	err = sendOverConn(ctx, conn, file, fileInfo, transferID, &sendCfg, limiter, nil, nil, rep, false, nil, nil)
+	return errors.WithTransferID(err, transferID)
+}
+
+// openSourceFile opens the file to send, rejecting directories
+func openSourceFile(path string) (*os.File, *filesystem.FileInfo, error) {
+	fileInfo, err := filesystem.GetFileInfo(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fileInfo.IsDir {
+		return nil, nil, errors.NewValidationError("file_path", path, "cannot transfer directories")
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
-		return errors.NewNetworkError("dial", cfg.ServerAddress, err)
+		return nil, nil, errors.NewFileSystemError("open", path, err)
+	}
+
+	return file, fileInfo, nil
+}
+
+// sendOverConn runs the sending side of the protocol on conn: network profiling,
+// initialization, resume negotiation and serving the receiver's chunk requests
+// with file data paced by limiter. When reconnecting, the network profile
+// leaves cfg unchanged so that the chunk size still matches the receiver's
+// saved transfer state. If pending is set, file is nil and the file is read
+// through pending as it is received. If dest is set, chunks are read through
+// the fan-out of several servers, and the chunk size is kept the same for all
+// of them.
+func sendOverConn(ctx context.Context, conn net.Conn, file *os.File, fileInfo *filesystem.FileInfo, transferID string,
+	cfg *config.Config, limiter *ratelimit.Limiter, notifier *notify.Notifier, progressOutput io.Writer, rep *report.Report,
+	reconnecting bool, pending PendingSource, dest *destination) error {
+	if dest != nil {
+		dest.start()
+		defer dest.stop()
 	}
-	defer conn.Close()
 
 	// Disable connection deadline for persistent connections
 	if err := conn.SetDeadline(time.Time{}); err != nil {
@@ -56,7 +213,7 @@ func Run(cfg *config.Config) error {
 
 	// Apply TCP optimizations
 	if err := network.OptimizeTCPConnection(conn); err != nil {
-		slog.Warn("Failed to optimize TCP connection", "error", err)
+		slog.WarnContext(ctx, "Failed to optimize TCP connection", "error", err)
 	}
 
 	// Create buffered reader and writer
@@ -64,12 +221,33 @@ func Run(cfg *config.Config) error {
 	writer := bufio.NewWriterSize(conn, cfg.BufferSize)
 
 	// Perform network profiling
-	slog.Info("Performing network profiling...")
-	profile := network.ProfileNetwork(conn)
-	logging.LogNetworkMetrics(profile.RTT, profile.Bandwidth, profile.PacketLoss)
+	profile := network.DefaultProfile()
+	if cfg.SkipProfiling {
+		slog.InfoContext(ctx, "Skipping network profiling, using default settings")
+	} else {
+		slog.InfoContext(ctx, "Performing network profiling...")
+		profile = network.ProfileNetwork(conn, cfg)
+		logging.LogNetworkMetrics(profile.RTT, profile.Jitter, profile.Bandwidth, profile.PacketLoss)
+		metrics.NetworkRTT.Set(profile.RTT.Seconds())
+		metrics.NetworkJitter.Set(profile.Jitter.Seconds())
+		metrics.NetworkBandwidth.Set(float64(profile.Bandwidth))
+		metrics.NetworkPacketLoss.Set(profile.PacketLoss)
+
+		rep.Network = &report.Network{
+			RTTSeconds:           profile.RTT.Seconds(),
+			JitterSeconds:        profile.Jitter.Seconds(),
+			BandwidthBytesPerSec: profile.Bandwidth,
+			BandwidthMeasured:    profile.BandwidthMeasured,
+			PacketLoss:           profile.PacketLoss,
+			PacketLossMeasured:   profile.LossMeasured,
+		}
 
-	// Adjust configuration based on profile
-	adjustConfigForNetwork(cfg, profile)
+		// Adjust configuration based on profile
+		if !reconnecting && dest == nil && !cfg.LegacyProtocol {
+			adjustConfigForNetwork(ctx, cfg, profile)
+		}
+	}
+	rep.ChunkSize = cfg.ChunkSize
 
 	// Re-create reader and writer with optimal buffer sizes
 	optimalBufferSize := max(cfg.BufferSize, int(profile.OptimalChunkSize/4))
@@ -77,40 +255,59 @@ func Run(cfg *config.Config) error {
 	writer = bufio.NewWriterSize(conn, optimalBufferSize)
 
 	// Initialize transfer
-	if err := initializeTransfer(writer, fileInfo, cfg); err != nil {
+	if err := initializeTransfer(ctx, writer, transferID, fileInfo, cfg); err != nil {
 		return err
 	}
 
 	// Negotiate resume with server
-	ctx := context.Background()
 	resumeState, err := negotiateResume(ctx, reader, writer, fileInfo, cfg)
 	if err != nil {
 		return err
 	}
+	if dest != nil {
+		dest.resume(resumeState.CompletedChunks)
+	}
 
 	// Setup transfer statistics
 	stats := &progress.Stats{
-		TotalBytes: fileInfo.Size,
-		StartTime:  time.Now(),
-		FileSize:   fileInfo.Size,
-		Filename:   fileInfo.Name,
+		TotalBytes:  fileInfo.Size,
+		StartTime:   time.Now(),
+		FileSize:    fileInfo.Size,
+		Filename:    fileInfo.Name,
+		TotalChunks: (fileInfo.Size + cfg.ChunkSize - 1) / cfg.ChunkSize,
+		TransferID:  transferID,
 	}
 
 	// Apply resume state to statistics
 	if resumeState.CanResume {
 		stats.SetTransferred(resumeState.ResumeOffset)
-		slog.Info("Client resuming transfer",
+		stats.ResumedChunks = countCompletedChunks(resumeState.CompletedChunks)
+		stats.ResumedBytes = resumeState.ResumeOffset
+		stats.CompletedChunks.Store(stats.ResumedChunks)
+		slog.InfoContext(ctx, "Client resuming transfer",
 			"resume_offset_mb", float64(resumeState.ResumeOffset)/(1024*1024),
 			"completed_chunks", countCompletedChunks(resumeState.CompletedChunks),
 			"total_chunks", resumeState.TotalChunks)
 
-		logging.LogSessionStart("CLIENT_RESUME", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
+		logging.LogSessionStart(ctx, "CLIENT_RESUME", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
 	} else {
-		logging.LogSessionStart("CLIENT", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
+		logging.LogSessionStart(ctx, "CLIENT", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
 	}
 
-	// Setup network statistics
-	netStats := network.NewNetworkStats(cfg)
+	// Report the session and its progress milestones to the webhook
+	template := events.Event{
+		Role:       events.RoleClient,
+		TransferID: transferID,
+		Filename:   fileInfo.Name,
+		Size:       fileInfo.Size,
+		RemoteAddr: cfg.ServerAddress,
+	}
+	sessionEvent := template
+	sessionEvent.Type = events.SessionStart
+	notifier.Send(sessionEvent)
+
+	stopProgressEvents := notifier.WatchProgress(stats, template)
+	defer stopProgressEvents()
 
 	// Create buffer pool for chunks
 	bufferPool := sync.Pool{
@@ -121,18 +318,47 @@ func Run(cfg *config.Config) error {
 
 	// Start progress reporting
 	var reporter *progress.Reporter
-	if cfg.ShowProgress {
-		reporter = progress.NewReporter(stats, cfg.ShowProgress)
+	if cfg.ShowProgress || progressOutput != nil {
+		reporter = progress.NewReporter(stats, cfg.ShowProgress && cfg.ProgressJSON != config.ProgressStdout)
+		if progressOutput != nil {
+			reporter.SetJSONOutput(progressOutput)
+		}
 		reporter.Start()
 		defer reporter.Stop()
 	}
 
 	// Handle server requests
-	return handleServerRequests(reader, writer, file, stats, netStats, &bufferPool, cfg, resumeState)
+	defer rep.AddStats(stats)
+	return handleServerRequests(ctx, reader, writer, file, stats, limiter, &bufferPool, cfg, resumeState, rep, pending, dest)
+}
+
+// outcomeEvent builds the completion or failure event for a finished transfer
+func outcomeEvent(cfg *config.Config, transferID string, startTime time.Time, transferErr error) events.Event {
+	event := events.Event{
+		Type:            events.TransferComplete,
+		Role:            events.RoleClient,
+		Timestamp:       time.Now(),
+		TransferID:      transferID,
+		Filename:        filepath.Base(cfg.FilePath),
+		RemoteAddr:      cfg.ServerAddress,
+		DurationSeconds: time.Since(startTime).Seconds(),
+	}
+
+	if fileInfo, err := os.Stat(cfg.FilePath); err == nil {
+		event.Size = fileInfo.Size()
+	}
+
+	if transferErr != nil {
+		event.Type = events.TransferFailed
+		event.Error = transferErr.Error()
+		event.ErrorCategory = errors.Category(transferErr)
+	}
+
+	return event
 }
 
 // adjustConfigForNetwork adjusts configuration based on network profile
-func adjustConfigForNetwork(cfg *config.Config, profile network.NetworkProfile) {
+func adjustConfigForNetwork(ctx context.Context, cfg *config.Config, profile network.NetworkProfile) {
 	originalWorkers := cfg.Workers
 	originalChunkSize := cfg.ChunkSize
 
@@ -141,26 +367,43 @@ func adjustConfigForNetwork(cfg *config.Config, profile network.NetworkProfile)
 		// High latency network, reduce workers and increase chunk size
 		cfg.Workers = max(1, cfg.Workers/2)
 		cfg.ChunkSize = profile.OptimalChunkSize
-		slog.Info("High latency network detected",
+		slog.InfoContext(ctx, "High latency network detected",
 			"old_workers", originalWorkers,
 			"new_workers", cfg.Workers)
 	case profile.RTT < 10*time.Millisecond:
 		// Low latency network, can use more workers
 		cfg.Workers = min(runtime.NumCPU(), cfg.Workers*2)
-		slog.Info("Low latency network detected",
+		slog.InfoContext(ctx, "Low latency network detected",
 			"old_workers", originalWorkers,
 			"new_workers", cfg.Workers)
 	}
 
+	// A chunk that hits a loss costs more to resend the larger it is
+	if profile.LossMeasured && profile.PacketLoss > network.LossyThreshold && cfg.ChunkSize > profile.OptimalChunkSize {
+		cfg.ChunkSize = profile.OptimalChunkSize
+		slog.InfoContext(ctx, "Lossy network detected",
+			"packet_loss_percent", profile.PacketLoss*100)
+	}
+
 	if cfg.ChunkSize != originalChunkSize {
-		slog.Info("Adjusted chunk size based on network",
+		slog.InfoContext(ctx, "Adjusted chunk size based on network",
 			"old_size_mb", float64(originalChunkSize)/(1024*1024),
 			"new_size_mb", float64(cfg.ChunkSize)/(1024*1024))
 	}
 }
 
 // initializeTransfer sends initial transfer information to server
-func initializeTransfer(writer *bufio.Writer, fileInfo *filesystem.FileInfo, cfg *config.Config) error {
+func initializeTransfer(ctx context.Context, writer *bufio.Writer, transferID string, fileInfo *filesystem.FileInfo,
+	cfg *config.Config) error {
+	// Announce the protocol version, which tells the server to expect the
+	// transfer ID and chunk size below. Servers predating protocol versions get
+	// the original handshake without them.
+	if !cfg.LegacyProtocol {
+		if err := protocol.SendVersion(writer); err != nil {
+			return err
+		}
+	}
+
 	// Send initialization command
 	if err := protocol.SendCommand(writer, protocol.CmdInit); err != nil {
 		return err
@@ -181,20 +424,32 @@ func initializeTransfer(writer *bufio.Writer, fileInfo *filesystem.FileInfo, cfg
 		return err
 	}
 
+	if !cfg.LegacyProtocol {
+		// Send the transfer ID so both sides log the transfer under the same ID
+		if err := protocol.SendString(writer, transferID); err != nil {
+			return err
+		}
+
+		// Send the chunk size so the server requests chunks at matching offsets
+		if err := protocol.SendInt64(writer, cfg.ChunkSize); err != nil {
+			return err
+		}
+	}
+
 	if err := protocol.FlushWriter(writer); err != nil {
 		return err
 	}
 
-	logging.LogSessionStart("CLIENT", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
+	logging.LogSessionStart(ctx, "CLIENT", fileInfo.Size, int64(cfg.ChunkSize), cfg.Workers)
 
 	return nil
 }
 
 // handleServerRequests handles requests from the server
-func handleServerRequests(reader *bufio.Reader, writer *bufio.Writer, file *os.File,
-	stats *progress.Stats, netStats *network.NetworkStats, bufferPool *sync.Pool, cfg *config.Config, resumeState *ResumeState) error {
+func handleServerRequests(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File,
+	stats *progress.Stats, limiter *ratelimit.Limiter, bufferPool *sync.Pool, cfg *config.Config,
+	resumeState *ResumeState, rep *report.Report, pending PendingSource, dest *destination) error {
 
-	ctx := context.Background()
 	var cmdByte byte
 	var err error
 
@@ -213,26 +468,30 @@ func handleServerRequests(reader *bufio.Reader, writer *bufio.Writer, file *os.F
 	for {
 		switch cmdByte {
 		case protocol.CmdRequest:
-			if err := handleChunkRequest(ctx, reader, writer, file, stats, netStats, bufferPool, cfg, resumeState); err != nil {
+			if err := handleChunkRequest(ctx, reader, writer, file, stats, limiter, bufferPool, cfg, pending, dest); err != nil {
 				return err
 			}
 
 		case protocol.CmdHashAlgo:
 			// Hash algorithm command followed by hash request - handle together
-			if err := handleHashRequest(ctx, reader, writer, file); err != nil {
+			if err := withCompleteFile(ctx, file, pending, func(file *os.File) error {
+				return handleHashRequest(ctx, reader, writer, file, rep, dest)
+			}); err != nil {
 				return err
 			}
 
 		case protocol.CmdHash:
 			// Legacy hash request (MD5 only) - for backward compatibility
-			if err := handleLegacyHashRequest(ctx, reader, writer, file); err != nil {
+			if err := withCompleteFile(ctx, file, pending, func(file *os.File) error {
+				return handleLegacyHashRequest(ctx, reader, writer, file, rep, dest)
+			}); err != nil {
 				return err
 			}
 
 		case protocol.CmdComplete:
 			// Transfer completed successfully
 			elapsed := time.Since(stats.StartTime)
-			logging.LogTransferComplete(stats.Filename, stats.FileSize, elapsed)
+			logging.LogTransferComplete(ctx, stats.Filename, stats.FileSize, elapsed)
 			return nil
 
 		case protocol.CmdError:
@@ -254,8 +513,8 @@ func handleServerRequests(reader *bufio.Reader, writer *bufio.Writer, file *os.F
 
 // handleChunkRequest handles a chunk request from the server
 func handleChunkRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer,
-	file *os.File, stats *progress.Stats, netStats *network.NetworkStats,
-	bufferPool *sync.Pool, cfg *config.Config, resumeState *ResumeState) error {
+	file *os.File, stats *progress.Stats, limiter *ratelimit.Limiter,
+	bufferPool *sync.Pool, cfg *config.Config, pending PendingSource, dest *destination) error {
 
 	// Read chunk offset
 	offset, err := protocol.ReadInt64(ctx, reader)
@@ -263,10 +522,9 @@ func handleChunkRequest(ctx context.Context, reader *bufio.Reader, writer *bufio
 		return err
 	}
 
-	// Apply adaptive delay
-	chunkDelay := netStats.GetDelay(cfg.ChunkDelay)
-	if chunkDelay > 0 {
-		time.Sleep(chunkDelay)
+	// Apply the fixed delay; with adaptive networking the server paces requests
+	if !cfg.AdaptiveDelay && cfg.ChunkDelay > 0 {
+		time.Sleep(cfg.ChunkDelay)
 	}
 
 	// Get buffer from pool
@@ -280,30 +538,35 @@ func handleChunkRequest(ctx context.Context, reader *bufio.Reader, writer *bufio
 	}
 
 	// Send chunk data
-	if err := sendChunk(writer, file, offset, actualChunkSize, buffer, stats, cfg); err != nil {
-		return err
-	}
-
-	// Update network stats
-	netStats.UpdateStats(actualChunkSize)
-	return nil
+	return sendChunk(ctx, writer, file, offset, actualChunkSize, buffer, stats, limiter, cfg, pending, dest)
 }
 
 // handleHashRequest handles a hash request from the server with algorithm negotiation
-func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File) error {
+func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File, rep *report.Report,
+	dest *destination) error {
 	// First, read hash algorithm from server
 	algorithm, err := protocol.ReadHashAlgorithm(ctx, reader)
 	if err != nil {
 		return err
 	}
 
-	slog.Info("Received hash algorithm", "algorithm", algorithm)
+	slog.InfoContext(ctx, "Received hash algorithm", "algorithm", algorithm)
+
+	// The algorithm is followed by the hash request itself
+	requestCmd, err := protocol.ReadCommand(ctx, reader)
+	if err != nil {
+		return err
+	}
+	if requestCmd != protocol.CmdHash {
+		return errors.NewProtocolError("hash_request", "expected hash request after algorithm", nil)
+	}
 
 	// Calculate file hash using the specified algorithm
-	hash, err := filesystem.CalculateFileHashWithAlgorithm(file, algorithm)
+	hash, err := fileHash(file, algorithm, dest)
 	if err != nil {
 		return err
 	}
+	rep.Hash = &report.Hash{Algorithm: string(algorithm), SourceHash: hash}
 
 	// Send hash command and hash value
 	if err := protocol.SendCommand(writer, protocol.CmdHash); err != nil {
@@ -318,7 +581,7 @@ func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.
 		return err
 	}
 
-	slog.Info("File hash sent", "algorithm", algorithm, "hash", hash)
+	slog.InfoContext(ctx, "File hash sent", "algorithm", algorithm, "hash", hash)
 
 	// Wait for server's hash verification response
 	cmdByte, err := protocol.ReadCommand(ctx, reader)
@@ -329,8 +592,9 @@ func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.
 	if cmdByte == protocol.CmdError {
 		// Hash verification failed on server
 		errorMsg, _ := protocol.ReadString(ctx, reader)
-		slog.Error("Hash verification failed on server", "error", errorMsg)
-		return errors.NewValidationError("hash_verification", hash, "server reported hash mismatch")
+		slog.ErrorContext(ctx, "Hash verification failed on server", "error", errorMsg)
+		metrics.HashVerificationFailures.Inc()
+		return errors.NewHashMismatchError(string(algorithm), hash, "")
 	} else if cmdByte == protocol.CmdHash {
 		// Hash verification successful
 		verificationMsg, err := protocol.ReadString(ctx, reader)
@@ -338,9 +602,10 @@ func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.
 			return err
 		}
 		if verificationMsg == "HASH_VERIFIED" {
-			slog.Info("Hash verification successful", "algorithm", algorithm, "source_hash", hash, "verified_by_server", true)
+			rep.Hash.Verified = true
+			slog.InfoContext(ctx, "Hash verification successful", "algorithm", algorithm, "source_hash", hash, "verified_by_server", true)
 		} else {
-			slog.Warn("Unexpected hash verification response", "message", verificationMsg)
+			slog.WarnContext(ctx, "Unexpected hash verification response", "message", verificationMsg)
 		}
 	} else {
 		return errors.NewProtocolError("hash_verification", "unexpected response from server after hash", nil)
@@ -350,12 +615,14 @@ func handleHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.
 }
 
 // handleLegacyHashRequest handles legacy hash requests (MD5 only, for backward compatibility)
-func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File) error {
+func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File, rep *report.Report,
+	dest *destination) error {
 	// Use MD5 for legacy requests
-	hash, err := filesystem.CalculateFileHash(file)
+	hash, err := fileHash(file, protocol.HashMD5, dest)
 	if err != nil {
 		return err
 	}
+	rep.Hash = &report.Hash{Algorithm: "md5", SourceHash: hash}
 
 	// Send hash command and hash value
 	if err := protocol.SendCommand(writer, protocol.CmdHash); err != nil {
@@ -370,7 +637,7 @@ func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *
 		return err
 	}
 
-	slog.Info("Legacy file hash sent", "algorithm", "md5", "hash", hash)
+	slog.InfoContext(ctx, "Legacy file hash sent", "algorithm", "md5", "hash", hash)
 
 	// Wait for server's hash verification response
 	cmdByte, err := protocol.ReadCommand(ctx, reader)
@@ -381,8 +648,9 @@ func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *
 	if cmdByte == protocol.CmdError {
 		// Hash verification failed on server
 		errorMsg, _ := protocol.ReadString(ctx, reader)
-		slog.Error("Hash verification failed on server", "error", errorMsg)
-		return errors.NewValidationError("hash_verification", hash, "server reported hash mismatch")
+		slog.ErrorContext(ctx, "Hash verification failed on server", "error", errorMsg)
+		metrics.HashVerificationFailures.Inc()
+		return errors.NewHashMismatchError("md5", hash, "")
 	} else if cmdByte == protocol.CmdHash {
 		// Hash verification successful
 		verificationMsg, err := protocol.ReadString(ctx, reader)
@@ -390,9 +658,10 @@ func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *
 			return err
 		}
 		if verificationMsg == "HASH_VERIFIED" {
-			slog.Info("Hash verification successful", "algorithm", "md5", "source_hash", hash, "verified_by_server", true)
+			rep.Hash.Verified = true
+			slog.InfoContext(ctx, "Hash verification successful", "algorithm", "md5", "source_hash", hash, "verified_by_server", true)
 		} else {
-			slog.Warn("Unexpected hash verification response", "message", verificationMsg)
+			slog.WarnContext(ctx, "Unexpected hash verification response", "message", verificationMsg)
 		}
 	} else {
 		return errors.NewProtocolError("hash_verification", "unexpected response from server after hash", nil)
@@ -402,11 +671,24 @@ func handleLegacyHashRequest(ctx context.Context, reader *bufio.Reader, writer *
 }
 
 // sendChunk sends a chunk of data to the server
-func sendChunk(writer *bufio.Writer, file *os.File, offset, chunkSize int64,
-	buffer []byte, stats *progress.Stats, cfg *config.Config) error {
+func sendChunk(ctx context.Context, writer *bufio.Writer, file *os.File, offset, chunkSize int64,
+	buffer []byte, stats *progress.Stats, limiter *ratelimit.Limiter, cfg *config.Config,
+	pending PendingSource, dest *destination) error {
 
-	// Read chunk from file
-	n, err := file.ReadAt(buffer, offset)
+	// Read chunk from file, once for all servers of a fan-out. A file still
+	// being received is read once the chunk has arrived.
+	var n int
+	var err error
+	switch {
+	case pending != nil:
+		if n, err = pending.ReadAt(ctx, buffer[:chunkSize], offset); err != nil {
+			return err
+		}
+	case dest != nil:
+		n, err = dest.readChunk(ctx, buffer[:chunkSize], offset)
+	default:
+		n, err = file.ReadAt(buffer, offset)
+	}
 	if err != nil && err != io.EOF {
 		return errors.NewFileSystemError("read_chunk", file.Name(), err)
 	}
@@ -416,7 +698,7 @@ func sendChunk(writer *bufio.Writer, file *os.File, offset, chunkSize int64,
 	chunkSizeMB := float64(n) / (1024 * 1024)
 	chunkTimeout := time.Duration(max(30, int(chunkSizeMB*timeoutPerMB.Seconds()))) * time.Second
 
-	ctx, cancel := context.WithTimeout(context.Background(), chunkTimeout)
+	ctx, cancel := context.WithTimeout(ctx, chunkTimeout)
 	defer cancel()
 
 	// Send with retries
@@ -432,12 +714,16 @@ func sendChunk(writer *bufio.Writer, file *os.File, offset, chunkSize int64,
 		if retry > 0 {
 			backoffTime := time.Duration(retry*500) * time.Millisecond
 			time.Sleep(backoffTime)
-			slog.Debug("Retrying chunk send", "offset", offset, "attempt", retry+1)
+			slog.DebugContext(ctx, "Retrying chunk send", "offset", offset, "attempt", retry+1)
+			metrics.ChunkRetries.Inc()
+			stats.AddRetry(offset / cfg.ChunkSize)
 		}
 
-		err := sendChunkData(ctx, writer, file, buffer[:n], cfg)
+		err := sendChunkData(ctx, writer, file, buffer[:n], stats, limiter, cfg)
 		if err == nil {
 			stats.UpdateTransferred(int64(n))
+			stats.ChunkCompleted()
+			metrics.BytesSent.Add(int64(n))
 			return nil
 		}
 
@@ -449,7 +735,7 @@ func sendChunk(writer *bufio.Writer, file *os.File, offset, chunkSize int64,
 
 // sendChunkData sends the actual chunk data with compression if enabled
 func sendChunkData(ctx context.Context, writer *bufio.Writer, file *os.File,
-	data []byte, cfg *config.Config) error {
+	data []byte, stats *progress.Stats, limiter *ratelimit.Limiter, cfg *config.Config) error {
 
 	// Send data command
 	if err := protocol.SendCommand(writer, protocol.CmdData); err != nil {
@@ -465,16 +751,18 @@ func sendChunkData(ctx context.Context, writer *bufio.Writer, file *os.File,
 		return err
 	}
 
-	// Handle compression
-	if cfg.Compression && compression.ShouldCompressFile(file.Name()) {
-		return sendCompressedChunk(ctx, writer, file.Name(), data)
+	// Handle compression, by the name of the file being transferred, which a
+	// relay reads from a differently named spool file
+	if cfg.Compression && compression.ShouldCompressFile(stats.Filename) {
+		return sendCompressedChunk(ctx, writer, stats.Filename, data, stats, limiter)
 	}
 
-	return sendUncompressedChunk(ctx, writer, data)
+	return sendUncompressedChunk(ctx, writer, data, limiter)
 }
 
 // sendCompressedChunk sends data with compression
-func sendCompressedChunk(ctx context.Context, writer *bufio.Writer, filename string, data []byte) error {
+func sendCompressedChunk(ctx context.Context, writer *bufio.Writer, filename string, data []byte, stats *progress.Stats,
+	limiter *ratelimit.Limiter) error {
 	// Compress data
 	compressedData, err := compression.CompressData(data, filename)
 	if err != nil {
@@ -496,18 +784,21 @@ func sendCompressedChunk(ctx context.Context, writer *bufio.Writer, filename str
 	}
 
 	// Log compression ratio
+	metrics.CompressionInputBytes.Add(int64(len(data)))
+	metrics.CompressionOutputBytes.Add(int64(len(compressedData)))
+	stats.AddCompression(int64(len(data)), int64(len(compressedData)))
 	ratio := compression.GetCompressionRatio(len(data), len(compressedData))
-	slog.Debug("Chunk compressed",
+	slog.DebugContext(ctx, "Chunk compressed",
 		"original_size", len(data),
 		"compressed_size", len(compressedData),
 		"ratio", ratio)
 
 	// Send compressed data in pieces
-	return sendDataInPieces(ctx, writer, compressedData)
+	return sendDataInPieces(ctx, writer, compressedData, limiter)
 }
 
 // sendUncompressedChunk sends data without compression
-func sendUncompressedChunk(ctx context.Context, writer *bufio.Writer, data []byte) error {
+func sendUncompressedChunk(ctx context.Context, writer *bufio.Writer, data []byte, limiter *ratelimit.Limiter) error {
 	// Send compression flag (0 = uncompressed)
 	if err := protocol.SendCommand(writer, 0); err != nil {
 		return err
@@ -518,11 +809,12 @@ func sendUncompressedChunk(ctx context.Context, writer *bufio.Writer, data []byt
 	}
 
 	// Send uncompressed data in pieces
-	return sendDataInPieces(ctx, writer, data)
+	return sendDataInPieces(ctx, writer, data, limiter)
 }
 
-// sendDataInPieces sends data in smaller pieces with adaptive sizing
-func sendDataInPieces(ctx context.Context, writer *bufio.Writer, data []byte) error {
+// sendDataInPieces sends data in smaller pieces with adaptive sizing, waiting for
+// the rate limiter before each piece
+func sendDataInPieces(ctx context.Context, writer *bufio.Writer, data []byte, limiter *ratelimit.Limiter) error {
 	maxWriteSize := config.LargeWriteSize // Start with 64KB chunks
 	minWriteSize := config.SmallWriteSize // Don't go below 8KB
 	consecutiveSlowWrites := 0
@@ -541,6 +833,10 @@ func sendDataInPieces(ctx context.Context, writer *bufio.Writer, data []byte) er
 			endPos = len(data)
 		}
 
+		if err := limiter.WaitN(ctx, endPos-i); err != nil {
+			return err
+		}
+
 		// Track write time for adaptive sizing
 		writeStart := time.Now()
 
@@ -561,7 +857,7 @@ func sendDataInPieces(ctx context.Context, writer *bufio.Writer, data []byte) er
 			maxWriteSize = max(minWriteSize, maxWriteSize/2)
 			consecutiveSlowWrites++
 
-			slog.Debug("Slow network detected, reducing write size",
+			slog.DebugContext(ctx, "Slow network detected, reducing write size",
 				"piece_time", pieceTime,
 				"new_size", maxWriteSize)
 
@@ -618,7 +914,7 @@ func negotiateResume(ctx context.Context, reader *bufio.Reader, writer *bufio.Wr
 		// Server is offering resume - read the resume info
 		serverResumeInfo, err := protocol.ReadResumeInfo(ctx, reader)
 		if err != nil {
-			slog.Warn("Failed to read server resume info", "error", err)
+			slog.WarnContext(ctx, "Failed to read server resume info", "error", err)
 			// Send negative ack and continue without resume
 			protocol.SendResumeAck(writer, false)
 			// Return state indicating no resume and continue with normal flow
@@ -632,7 +928,7 @@ func negotiateResume(ctx context.Context, reader *bufio.Reader, writer *bufio.Wr
 			resumeState.CompletedChunks = make([]bool, totalChunks)
 			copy(resumeState.CompletedChunks, serverResumeInfo.CompletedChunks)
 
-			slog.Info("Resume negotiation successful",
+			slog.InfoContext(ctx, "Resume negotiation successful",
 				"resume_offset_mb", float64(resumeState.ResumeOffset)/(1024*1024),
 				"completed_chunks", countCompletedChunks(resumeState.CompletedChunks))
 
@@ -641,7 +937,7 @@ func negotiateResume(ctx context.Context, reader *bufio.Reader, writer *bufio.Wr
 				return resumeState, err
 			}
 		} else {
-			slog.Info("Resume not compatible, starting fresh transfer")
+			slog.InfoContext(ctx, "Resume not compatible, starting fresh transfer")
 			// Send negative ack
 			if err := protocol.SendResumeAck(writer, false); err != nil {
 				return resumeState, err
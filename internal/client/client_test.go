@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/protocol"
+)
+
+func TestRejectedVersion(t *testing.T) {
+	assert.True(t, rejectedVersion(errors.NewProtocolError("server_error", "Unknown command", nil)))
+	assert.True(t, rejectedVersion(errors.WithTransferID(errors.NewProtocolError("server_error", "Unknown command", nil), "id")))
+	assert.False(t, rejectedVersion(errors.NewProtocolError("server_error", "Transfer failed", nil)))
+	assert.False(t, rejectedVersion(errors.NewNetworkError("read_command", "server:8000", io.EOF)))
+	assert.False(t, rejectedVersion(nil))
+}
+
+func TestInitializeTransfer(t *testing.T) {
+	ctx := context.Background()
+	fileInfo := &filesystem.FileInfo{Name: "data.bin", Size: 4096}
+
+	readInit := func(t *testing.T, reader *bufio.Reader) {
+		cmd, err := protocol.ReadCommand(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, byte(protocol.CmdInit), cmd)
+		name, err := protocol.ReadString(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, "data.bin", name)
+		size, err := protocol.ReadInt64(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4096), size)
+		verify, err := protocol.ReadBool(ctx, reader)
+		require.NoError(t, err)
+		assert.True(t, verify)
+	}
+
+	t.Run("versioned", func(t *testing.T) {
+		var buf bytes.Buffer
+		cfg := &config.Config{ChunkSize: 1024, VerifyHash: true}
+		require.NoError(t, initializeTransfer(ctx, bufio.NewWriter(&buf), "id", fileInfo, cfg))
+
+		reader := bufio.NewReader(&buf)
+		cmd, err := protocol.ReadCommand(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, byte(protocol.CmdVersion), cmd)
+		version, err := protocol.ReadVersion(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, int64(protocol.ProtocolVersion), version)
+
+		readInit(t, reader)
+		transferID, err := protocol.ReadString(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, "id", transferID)
+		chunkSize, err := protocol.ReadInt64(ctx, reader)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1024), chunkSize)
+		assert.Zero(t, reader.Buffered())
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		// Servers predating protocol versions get the original handshake only
+		var buf bytes.Buffer
+		cfg := &config.Config{ChunkSize: 1024, VerifyHash: true, LegacyProtocol: true}
+		require.NoError(t, initializeTransfer(ctx, bufio.NewWriter(&buf), "id", fileInfo, cfg))
+
+		reader := bufio.NewReader(&buf)
+		readInit(t, reader)
+		assert.Zero(t, reader.Buffered())
+	})
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/notify"
+	"justdatacopier/internal/protocol"
+)
+
+// fanout reads a file once for several destinations. A chunk is read when the
+// first destination needs it and kept until every other destination still
+// needing it has sent it. Once config.FanoutBufferSize of chunks are kept for
+// slower destinations, faster ones wait, so the slowest sets the pace.
+type fanout struct {
+	file      *os.File
+	reader    io.ReaderAt // Reads the chunks of file
+	chunkSize int64
+	numChunks int64
+	capacity  int
+
+	mu           sync.Mutex
+	changed      chan struct{} // Closed and replaced whenever a chunk is released or a destination stops
+	chunks       map[int64]*sharedChunk
+	destinations []*destination
+
+	hashMu sync.Mutex
+	hashes map[protocol.HashAlgorithm]string
+}
+
+// sharedChunk is a chunk read once for several destinations
+type sharedChunk struct {
+	ready   chan struct{} // Closed once read
+	data    []byte
+	err     error
+	waiting map[*destination]bool // Destinations that still need to send it
+}
+
+// destination is one of the servers a fan-out sends the file to
+type destination struct {
+	fanout  *fanout
+	address string
+	needed  []bool // Chunks still to send, nil while not connected
+	held    int    // Kept chunks waiting for this destination
+}
+
+// newFanout creates a fan-out of file, size bytes long, sent in chunks of chunkSize
+func newFanout(file *os.File, size, chunkSize int64) *fanout {
+	return &fanout{
+		file:      file,
+		reader:    file,
+		chunkSize: chunkSize,
+		numChunks: (size + chunkSize - 1) / chunkSize,
+		capacity:  max(2, int(config.FanoutBufferSize/chunkSize)),
+		changed:   make(chan struct{}),
+		chunks:    make(map[int64]*sharedChunk),
+		hashes:    make(map[protocol.HashAlgorithm]string),
+	}
+}
+
+// add adds the server at address as a destination, needing every chunk until
+// its transfer negotiates what it can resume
+func (f *fanout) add(address string) *destination {
+	dest := &destination{fanout: f, address: address}
+	dest.start()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.destinations = append(f.destinations, dest)
+	return dest
+}
+
+// start marks every chunk as needed once the destination is connected, unless
+// it was connected already
+func (d *destination) start() {
+	d.fanout.mu.Lock()
+	defer d.fanout.mu.Unlock()
+	if d.needed == nil {
+		d.needed = make([]bool, d.fanout.numChunks)
+		for i := range d.needed {
+			d.needed[i] = true
+		}
+	}
+}
+
+// resume records the chunks the server already has, as negotiated on resume,
+// releasing the kept chunks it no longer needs
+func (d *destination) resume(completed []bool) {
+	f := d.fanout
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range d.needed {
+		d.needed[i] = i >= len(completed) || !completed[i]
+	}
+	for index, chunk := range f.chunks {
+		if !d.needed[index] {
+			f.release(index, chunk, d)
+		}
+	}
+}
+
+// stop releases every kept chunk once the destination's connection ends, so
+// that a destination that is reconnecting or has failed holds no one back
+func (d *destination) stop() {
+	f := d.fanout
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for index, chunk := range f.chunks {
+		f.release(index, chunk, d)
+	}
+	d.needed = nil
+
+	// Wake the destination if it is waiting for capacity, so it sees that it stopped
+	f.wake()
+}
+
+// readChunk reads the chunk at offset into buffer, using the copy kept for the
+// destination if another one read it first. Chunks the destination sends again
+// after reconnecting are read from the file again.
+func (d *destination) readChunk(ctx context.Context, buffer []byte, offset int64) (int, error) {
+	f := d.fanout
+	index := offset / f.chunkSize
+
+	f.mu.Lock()
+	chunk := f.chunks[index]
+	for chunk == nil {
+		// A destination that stopped, possibly while waiting below, no longer
+		// shares chunks with the others
+		if d.needed == nil {
+			f.mu.Unlock()
+			return f.reader.ReadAt(buffer, offset)
+		}
+		if offset%f.chunkSize != 0 || index >= int64(len(d.needed)) || !d.needed[index] {
+			f.mu.Unlock()
+			return f.reader.ReadAt(buffer, offset)
+		}
+
+		// A destination holding kept chunks never waits, as the others may be waiting for it
+		if len(f.chunks) < f.capacity || d.held > 0 {
+			chunk = f.read(index)
+			break
+		}
+
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		f.mu.Lock()
+		chunk = f.chunks[index]
+	}
+	if index < int64(len(d.needed)) {
+		d.needed[index] = false
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-chunk.ready:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	n := copy(buffer, chunk.data)
+
+	f.mu.Lock()
+	f.release(index, chunk, d)
+	f.mu.Unlock()
+	return n, chunk.err
+}
+
+// read starts reading a chunk for every destination that needs it, called with
+// the lock held
+func (f *fanout) read(index int64) *sharedChunk {
+	chunk := &sharedChunk{ready: make(chan struct{}), waiting: make(map[*destination]bool)}
+	for _, dest := range f.destinations {
+		if index < int64(len(dest.needed)) && dest.needed[index] {
+			chunk.waiting[dest] = true
+			dest.held++
+		}
+	}
+	f.chunks[index] = chunk
+
+	go func() {
+		data := make([]byte, f.chunkSize)
+		n, err := f.reader.ReadAt(data, index*f.chunkSize)
+		if err == io.EOF {
+			err = nil
+		}
+		chunk.data, chunk.err = data[:n], err
+		close(chunk.ready)
+	}()
+	return chunk
+}
+
+// release records that dest no longer needs a kept chunk, dropping the chunk
+// once no destination does; called with the lock held
+func (f *fanout) release(index int64, chunk *sharedChunk, dest *destination) {
+	if chunk.waiting[dest] {
+		delete(chunk.waiting, dest)
+		dest.held--
+	}
+	if len(chunk.waiting) == 0 && f.chunks[index] == chunk {
+		delete(f.chunks, index)
+		f.wake()
+	}
+}
+
+// wake wakes the destinations waiting for a change; called with the lock held
+func (f *fanout) wake() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// hash returns the file's hash, calculating it once for all destinations
+func (f *fanout) hash(algorithm protocol.HashAlgorithm) (string, error) {
+	f.hashMu.Lock()
+	defer f.hashMu.Unlock()
+
+	if hash, ok := f.hashes[algorithm]; ok {
+		return hash, nil
+	}
+	hash, err := filesystem.CalculateFileHashWithAlgorithm(f.file, algorithm)
+	if err != nil {
+		return "", err
+	}
+	f.hashes[algorithm] = hash
+	return hash, nil
+}
+
+// fileHash returns the hash of the file being sent, shared by all destinations
+// of a fan-out
+func fileHash(file *os.File, algorithm protocol.HashAlgorithm, dest *destination) (string, error) {
+	if dest == nil {
+		return filesystem.CalculateFileHashWithAlgorithm(file, algorithm)
+	}
+	return dest.fanout.hash(algorithm)
+}
+
+// runFanout sends the file to several servers at once, reading it once for all
+// of them. Each server gets a transfer of its own, with its own resume state,
+// reconnects, events and report, and the result of each is logged. An error is
+// returned if any of them failed.
+func runFanout(ctx context.Context, cfg *config.Config, addresses []string, notifier *notify.Notifier, progressOutput io.Writer) error {
+	file, fileInfo, err := openSourceFile(cfg.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	slog.Info("Sending file to several servers", "servers", len(addresses), "file_size_mb", float64(fileInfo.Size)/(1024*1024))
+
+	// Register every destination first, so that the first chunks read are kept for all
+	shared := newFanout(file, fileInfo.Size, cfg.ChunkSize)
+	destinations := make([]*destination, len(addresses))
+	for i, address := range addresses {
+		destinations[i] = shared.add(address)
+	}
+
+	errs := make([]error, len(destinations))
+	var wg sync.WaitGroup
+	for i, dest := range destinations {
+		destCfg := *cfg
+		destCfg.ServerAddress = dest.address
+		destCfg.ShowProgress = false // Progress bars of several transfers would overwrite each other
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runTransfer(ctx, &destCfg, notifier, progressOutput, dest)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	var firstErr error
+	for i, dest := range destinations {
+		if errs[i] != nil {
+			slog.Error("Sending to server failed", "server", dest.address, "error", errs[i])
+			failed = append(failed, dest.address)
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		slog.Info("Sent to server", "server", dest.address)
+	}
+
+	if firstErr != nil {
+		return errors.NewFanoutError(failed, len(destinations), firstErr)
+	}
+	slog.Info("File sent to all servers", "servers", len(destinations))
+	return nil
+}
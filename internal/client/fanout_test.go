@@ -0,0 +1,305 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/protocol"
+)
+
+// countingReader counts the reads at each offset
+type countingReader struct {
+	io.ReaderAt
+	mu    sync.Mutex
+	reads map[int64]int
+}
+
+func (r *countingReader) ReadAt(p []byte, offset int64) (int, error) {
+	r.mu.Lock()
+	r.reads[offset]++
+	r.mu.Unlock()
+	return r.ReaderAt.ReadAt(p, offset)
+}
+
+// count returns the number of reads at offset
+func (r *countingReader) count(offset int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reads[offset]
+}
+
+// newTestFanout creates a fan-out of a file of size random bytes, counting its reads
+func newTestFanout(t *testing.T, size, chunkSize int64) (*fanout, []byte, *countingReader) {
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+
+	f := newFanout(file, size, chunkSize)
+	reader := &countingReader{ReaderAt: file, reads: make(map[int64]int)}
+	f.reader = reader
+	return f, data, reader
+}
+
+// sendChunks reads the chunks from first on for dest, as its transfer sends them
+func sendChunks(ctx context.Context, dest *destination, first int64) ([]byte, error) {
+	f := dest.fanout
+	size := f.numChunks * f.chunkSize
+	var sent []byte
+	buffer := make([]byte, f.chunkSize)
+	for offset := first * f.chunkSize; offset < size; offset += f.chunkSize {
+		n, err := dest.readChunk(ctx, buffer, offset)
+		if err != nil {
+			return nil, err
+		}
+		sent = append(sent, buffer[:n]...)
+	}
+	return sent, nil
+}
+
+// sendChunksAsync runs sendChunks in the background
+func sendChunksAsync(ctx context.Context, dest *destination, first int64) <-chan []byte {
+	done := make(chan []byte, 1)
+	go func() {
+		sent, _ := sendChunks(ctx, dest, first)
+		done <- sent
+	}()
+	return done
+}
+
+// assertBlocked asserts that nothing arrives on done for a while
+func assertBlocked(t *testing.T, done <-chan []byte) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("destination did not wait for the others")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestFanout_ReadsOnce(t *testing.T) {
+	f, data, reader := newTestFanout(t, 10*1024, 1024)
+	destinations := []*destination{f.add("a"), f.add("b"), f.add("c")}
+	ctx := testContext(t)
+
+	var wg sync.WaitGroup
+	sent := make([][]byte, len(destinations))
+	for i, dest := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			sent[i], err = sendChunks(ctx, dest, 0)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	for i := range destinations {
+		assert.Equal(t, data, sent[i])
+		assert.Zero(t, destinations[i].held)
+	}
+	for offset := int64(0); offset < 10*1024; offset += 1024 {
+		assert.Equal(t, 1, reader.count(offset), "reads at offset %d", offset)
+	}
+	assert.Empty(t, f.chunks)
+
+	// The hash is calculated once for all destinations as well
+	hash, err := f.hash(protocol.HashMD5)
+	require.NoError(t, err)
+	again, err := fileHash(nil, protocol.HashMD5, destinations[1])
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+}
+
+func TestFanout_SlowDestination(t *testing.T) {
+	f, data, reader := newTestFanout(t, 6*1024, 1024)
+	f.capacity = 2
+	fast, slow := f.add("fast"), f.add("slow")
+	ctx := testContext(t)
+
+	// The fast destination runs ahead until the chunks kept for the slow one
+	// fill the capacity
+	fastDone := sendChunksAsync(ctx, fast, 0)
+	assertBlocked(t, fastDone)
+	f.mu.Lock()
+	assert.Len(t, f.chunks, 2)
+	assert.Equal(t, 2, slow.held)
+	f.mu.Unlock()
+
+	// Each chunk the slow destination sends lets the fast one read another
+	sent, err := sendChunks(ctx, slow, 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, sent)
+	assert.Equal(t, data, <-fastDone)
+
+	for offset := int64(0); offset < 6*1024; offset += 1024 {
+		assert.Equal(t, 1, reader.count(offset), "reads at offset %d", offset)
+	}
+	assert.Empty(t, f.chunks)
+}
+
+func TestFanout_FailedDestination(t *testing.T) {
+	f, data, reader := newTestFanout(t, 6*1024, 1024)
+	f.capacity = 2
+	healthy, failed := f.add("healthy"), f.add("failed")
+	ctx := testContext(t)
+
+	done := sendChunksAsync(ctx, healthy, 0)
+	assertBlocked(t, done)
+
+	// A destination whose connection ends releases its kept chunks and no
+	// longer holds the others back
+	failed.stop()
+	assert.Equal(t, data, <-done)
+	assert.Zero(t, failed.held)
+	assert.Empty(t, f.chunks)
+
+	// Reconnecting, it resumes what the server lacks, read from the file again
+	failed.start()
+	failed.resume([]bool{true, true, true})
+	sent, err := sendChunks(ctx, failed, 3)
+	require.NoError(t, err)
+	assert.Equal(t, data[3*1024:], sent)
+	assert.Equal(t, 1, reader.count(0))
+	assert.Equal(t, 2, reader.count(3*1024))
+}
+
+func TestFanout_Resume(t *testing.T) {
+	f, _, _ := newTestFanout(t, 4*1024, 1024)
+	first, second := f.add("first"), f.add("second")
+	ctx := testContext(t)
+
+	buffer := make([]byte, 1024)
+	_, err := first.readChunk(ctx, buffer, 0)
+	require.NoError(t, err)
+	_, err = first.readChunk(ctx, buffer, 1024)
+	require.NoError(t, err)
+	assert.Len(t, f.chunks, 2)
+	assert.Equal(t, 2, second.held)
+
+	// Chunks the server already has are no longer kept for it
+	second.resume([]bool{true, false})
+	assert.Len(t, f.chunks, 1)
+	assert.Equal(t, 1, second.held)
+
+	_, err = second.readChunk(ctx, buffer, 1024)
+	require.NoError(t, err)
+	assert.Empty(t, f.chunks)
+	assert.Zero(t, second.held)
+}
+
+func TestFanout_Cancel(t *testing.T) {
+	f, _, _ := newTestFanout(t, 4*1024, 1024)
+	f.capacity = 2
+	waiting := f.add("waiting")
+	f.add("idle")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buffer := make([]byte, 1024)
+	for offset := int64(0); offset < 2*1024; offset += 1024 {
+		_, err := waiting.readChunk(ctx, buffer, offset)
+		require.NoError(t, err)
+	}
+
+	// A destination waiting for capacity stops when its transfer is cancelled
+	cancel()
+	_, err := waiting.readChunk(ctx, buffer, 2*1024)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFanout_StopWhileWaiting(t *testing.T) {
+	f, data, reader := newTestFanout(t, 4*1024, 1024)
+	f.capacity = 2
+	waiting := f.add("waiting")
+	f.add("idle")
+	ctx := testContext(t)
+
+	buffer := make([]byte, 1024)
+	for offset := int64(0); offset < 2*1024; offset += 1024 {
+		_, err := waiting.readChunk(ctx, buffer, offset)
+		require.NoError(t, err)
+	}
+
+	done := make(chan []byte, 1)
+	go func() {
+		buffer := make([]byte, 1024)
+		n, err := waiting.readChunk(ctx, buffer, 2*1024)
+		assert.NoError(t, err)
+		done <- buffer[:n]
+	}()
+	assertBlocked(t, done)
+
+	// A destination whose connection ends while it waits for capacity reads
+	// the chunk on its own rather than keeping it for the others
+	waiting.stop()
+	assert.Equal(t, data[2*1024:3*1024], <-done)
+	assert.Equal(t, 1, reader.count(2*1024))
+	f.mu.Lock()
+	assert.Len(t, f.chunks, 2)
+	assert.Zero(t, waiting.held)
+	f.mu.Unlock()
+}
+
+func TestRunFanout_FailedServer(t *testing.T) {
+	// A server that ends every transfer as soon as it starts
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte{protocol.CmdComplete})
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, make([]byte, 4096), 0644))
+	cfg := &config.Config{
+		FilePath:      path,
+		ChunkSize:     1024,
+		BufferSize:    4096,
+		Timeout:       5 * time.Second,
+		SkipProfiling: true,
+	}
+
+	reachable := listener.Addr().String()
+	unreachable := "127.0.0.1:1"
+	err = runFanout(context.Background(), cfg, []string{reachable, unreachable}, nil, nil)
+	require.Error(t, err)
+
+	var fanoutErr *errors.FanoutError
+	require.True(t, errors.As(err, &fanoutErr))
+	assert.Equal(t, []string{unreachable}, fanoutErr.Failed)
+	assert.Equal(t, 2, fanoutErr.Servers)
+	assert.Equal(t, "network", errors.Category(err))
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/events"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/ratelimit"
+	"justdatacopier/internal/report"
+)
+
+// PendingSource is a file that is sent while it is still being received, as
+// when a relay forwards a file upstream. It is read through the source rather
+// than an open file, as the file is replaced when received anew and renamed
+// once received completely.
+type PendingSource interface {
+	// ReadAt reads len(p) bytes at offset once they have been received
+	ReadAt(ctx context.Context, p []byte, offset int64) (int, error)
+
+	// OpenComplete waits until the whole file has been received and verified,
+	// and opens it
+	OpenComplete(ctx context.Context) (*os.File, error)
+}
+
+// withCompleteFile calls fn with the file being sent once it is complete:
+// file itself, or the file of pending, opened for the call
+func withCompleteFile(ctx context.Context, file *os.File, pending PendingSource, fn func(file *os.File) error) error {
+	if pending == nil {
+		return fn(file)
+	}
+
+	complete, err := pending.OpenComplete(ctx)
+	if err != nil {
+		return err
+	}
+	defer complete.Close()
+	return fn(complete)
+}
+
+// Retryable reports whether a failed Forward may succeed on a new attempt, as
+// when the connection failed or dropped, rather than the server rejecting the
+// file
+func Retryable(err error) bool {
+	return reconnectable(err)
+}
+
+// Forward sends the file pending, of the given name and size, to
+// cfg.ServerAddress over a new connection as transfer transferID, reading each
+// chunk once pending has received it. The network profile leaves cfg
+// unchanged, so that a later attempt resumes with the same chunk size. No
+// webhook events or reports are produced for the transfer.
+func Forward(ctx context.Context, transferID string, name string, size int64,
+	cfg *config.Config, pending PendingSource) error {
+	conn, err := dialServer(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sendCfg := *cfg
+	sendCfg.ShowProgress = false
+	fileInfo := &filesystem.FileInfo{Name: name, Size: size}
+	rep := report.New(events.RoleClient, time.Now())
+
+	limiter := ratelimit.New(cfg.RateLimit, nil)
+	limiter.SetSchedule(&cfg.RateSchedule)
+	return sendOverConn(ctx, conn, nil, fileInfo, transferID, &sendCfg, limiter, nil, nil, rep, true, pending, nil)
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/metrics"
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/notify"
+	"justdatacopier/internal/ratelimit"
+	"justdatacopier/internal/report"
+)
+
+// dialServer connects to the configured server
+func dialServer(cfg *config.Config) (net.Conn, error) {
+	conn, err := network.NewDialer(cfg).Dial(cfg.ServerAddress)
+	if err != nil {
+		return nil, errors.NewNetworkError("dial", cfg.ServerAddress, err)
+	}
+	return conn, nil
+}
+
+// reconnect resumes a transfer whose connection dropped with lastErr. It
+// reconnects with exponential backoff and resumes the transfer on each new
+// connection until it completes, fails for a reason reconnecting cannot fix,
+// cfg.ReconnectTimeout has passed or ctx is cancelled. cfg holds the settings
+// the first connection settled on.
+func reconnect(ctx context.Context, file *os.File, fileInfo *filesystem.FileInfo, transferID string,
+	cfg *config.Config, limiter *ratelimit.Limiter, notifier *notify.Notifier, progressOutput io.Writer, rep *report.Report,
+	lastErr error, dest *destination) error {
+	deadline := time.Now().Add(cfg.ReconnectTimeout)
+	backoff := config.ReconnectMinBackoff
+	err := lastErr
+
+	for reconnectable(err) {
+		if time.Now().Add(backoff).After(deadline) {
+			slog.ErrorContext(ctx, "Giving up reconnecting",
+				"reconnects", rep.Reconnects,
+				"reconnect_timeout", cfg.ReconnectTimeout)
+			return err
+		}
+
+		slog.WarnContext(ctx, "Connection lost, reconnecting",
+			"error", err,
+			"attempt", rep.Reconnects+1,
+			"backoff", backoff)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return err
+		}
+
+		rep.Reconnects++
+		metrics.Reconnects.Inc()
+
+		start := time.Now()
+		err = resumeOnNewConn(ctx, file, fileInfo, transferID, cfg, limiter, notifier, progressOutput, rep, dest)
+
+		// A connection that lasted a while made progress, so start over with a
+		// short backoff the next time it drops
+		if time.Since(start) > config.ReconnectMaxBackoff {
+			backoff = config.ReconnectMinBackoff
+		} else if backoff *= 2; backoff > config.ReconnectMaxBackoff {
+			backoff = config.ReconnectMaxBackoff
+		}
+	}
+
+	if err == nil {
+		slog.InfoContext(ctx, "Transfer completed after reconnecting", "reconnects", rep.Reconnects)
+	}
+	return err
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resumeOnNewConn connects to the server again and resumes the transfer
+func resumeOnNewConn(ctx context.Context, file *os.File, fileInfo *filesystem.FileInfo, transferID string,
+	cfg *config.Config, limiter *ratelimit.Limiter, notifier *notify.Notifier, progressOutput io.Writer, rep *report.Report,
+	dest *destination) error {
+	conn, err := dialServer(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	attemptCfg := *cfg
+	return sendOverConn(ctx, conn, file, fileInfo, transferID, &attemptCfg, limiter, notifier, progressOutput, rep, true, nil, dest)
+}
+
+// reconnectable reports whether err means the connection to the server was lost
+// or timed out, as opposed to a failure that reconnecting cannot fix
+func reconnectable(err error) bool {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errors.ErrFileSystem), errors.Is(err, errors.ErrCompression),
+		errors.Is(err, errors.ErrValidation), errors.Is(err, errors.ErrHashMismatch),
+		errors.Is(err, errors.ErrCancelled), errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, errors.ErrNetwork), errors.Is(err, errors.ErrTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.As(err, &netErr):
+		return true
+	default:
+		return false
+	}
+}
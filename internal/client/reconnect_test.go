@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/events"
+	"justdatacopier/internal/protocol"
+	"justdatacopier/internal/report"
+)
+
+func TestReconnectable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"network error", errors.NewNetworkError("read_command", "server:8000", io.EOF), true},
+		{"network error with transfer ID", errors.WithTransferID(errors.NewNetworkError("dial", "server:8000", nil), "id"), true},
+		{"connection closed", io.EOF, true},
+		{"connection cut mid-read", io.ErrUnexpectedEOF, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"broken pipe", fmt.Errorf("write: %w", syscall.EPIPE), true},
+		{"read timeout", context.DeadlineExceeded, true},
+		{"protocol error on closed connection", errors.NewProtocolError("read_string", "failed to read string", io.EOF), true},
+		{"server rejected transfer", errors.NewProtocolError("server_error", "Transfer failed", nil), false},
+		{"file system error", errors.NewFileSystemError("read_chunk", "data.bin", io.EOF), false},
+		{"compression error", errors.NewCompressionError("create_writer", nil), false},
+		{"validation error", errors.NewValidationError("file_path", "data", "cannot transfer directories"), false},
+		{"hash mismatch", errors.NewHashMismatchError("md5", "a", "b"), false},
+		{"cancelled", context.Canceled, false},
+		{"other error", fmt.Errorf("unexpected"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reconnectable(tt.err))
+		})
+	}
+}
+
+func TestReconnect_StopsOnCancel(t *testing.T) {
+	cfg := &config.Config{ServerAddress: "127.0.0.1:1", ReconnectTimeout: time.Minute}
+	rep := report.New(events.RoleClient, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	// Cancelling ends the backoff before the first reconnect
+	start := time.Now()
+	err := reconnect(ctx, nil, nil, protocol.NewTransferID(), cfg, nil, nil, nil, rep,
+		errors.NewNetworkError("read_command", cfg.ServerAddress, io.EOF), nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), config.ReconnectMinBackoff)
+	assert.Zero(t, rep.Reconnects)
+}
+
+func TestReconnect_GivesUpAfterTimeout(t *testing.T) {
+	cfg := &config.Config{ServerAddress: "127.0.0.1:1", ReconnectTimeout: config.ReconnectMinBackoff / 2}
+	rep := report.New(events.RoleClient, time.Now())
+	lastErr := errors.NewNetworkError("read_command", cfg.ServerAddress, io.EOF)
+
+	// A backoff that would end past the timeout is not waited for
+	err := reconnect(context.Background(), nil, nil, protocol.NewTransferID(), cfg, nil, nil, nil, rep, lastErr, nil)
+	assert.Same(t, lastErr, err)
+	assert.Zero(t, rep.Reconnects)
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/protocol"
+)
+
+// Verify compares the local file with the copy in the server's output directory
+// by hash, without transferring it, and prints the result to out
+func Verify(cfg *config.Config, out io.Writer) error {
+	name := cfg.RemoteName
+	if name == "" {
+		name = filepath.Base(cfg.FilePath)
+	}
+
+	file, fileInfo, err := openSourceFile(cfg.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	algorithm := filesystem.SelectHashAlgorithm(fileInfo.Size)
+
+	conn, err := dialServer(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	if err := protocol.SendCommand(writer, protocol.CmdVerify); err != nil {
+		return err
+	}
+	if err := protocol.SendString(writer, name); err != nil {
+		return err
+	}
+	if err := protocol.SendHashAlgorithm(writer, algorithm); err != nil {
+		return err
+	}
+	if err := protocol.FlushWriter(writer); err != nil {
+		return err
+	}
+
+	// Hash the local file while the server hashes its copy
+	type localResult struct {
+		hash string
+		err  error
+	}
+	local := make(chan localResult, 1)
+	go func() {
+		hash, err := filesystem.CalculateFileHashWithAlgorithm(file, algorithm)
+		local <- localResult{hash, err}
+	}()
+
+	// Hashing a large file can take far longer than the operation timeout
+	ctx := context.Background()
+	cmdByte, err := protocol.ReadCommand(ctx, reader)
+	if err != nil {
+		return errors.NewNetworkError("read_command", cfg.ServerAddress, err)
+	}
+
+	switch cmdByte {
+	case protocol.CmdHash:
+	case protocol.CmdError:
+		message, _ := protocol.ReadString(ctx, reader)
+		return errors.NewProtocolError("server_error", message, nil)
+	default:
+		return errors.NewProtocolError("verify", "unexpected command from server", nil)
+	}
+
+	remoteSize, err := protocol.ReadInt64(ctx, reader)
+	if err != nil {
+		return err
+	}
+	remoteHash, err := protocol.ReadString(ctx, reader)
+	if err != nil {
+		return err
+	}
+
+	result := <-local
+	if result.err != nil {
+		return result.err
+	}
+
+	if remoteSize != fileInfo.Size || remoteHash != result.hash {
+		slog.Error("Server copy differs from local file",
+			"algorithm", algorithm,
+			"local_size", fileInfo.Size,
+			"server_size", remoteSize,
+			"local_hash", result.hash,
+			"server_hash", remoteHash)
+		fmt.Fprintf(out, "MISMATCH %s %s local=%s server=%s\n", name, algorithm, result.hash, remoteHash)
+		return errors.NewHashMismatchError(string(algorithm), result.hash, remoteHash)
+	}
+
+	slog.Info("Server copy matches local file", "algorithm", algorithm, "size", fileInfo.Size)
+	fmt.Fprintf(out, "OK %s %s %s\n", name, algorithm, result.hash)
+	return nil
+}
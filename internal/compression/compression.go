@@ -69,6 +69,13 @@ func DecompressData(compressedData []byte, expectedSize int) ([]byte, error) {
 	return buffer[:n], nil
 }
 
+// MaxCompressedSize returns the largest output CompressData produces for size
+// bytes: incompressible data is stored in blocks of up to 64KB with a few
+// bytes of header each, plus the gzip header and trailer
+func MaxCompressedSize(size int64) int64 {
+	return size + 5*(size/65535+1) + 64
+}
+
 // ShouldCompressFile determines if a file should be compressed based on its name
 func ShouldCompressFile(filename string) bool {
 	return filesystem.ShouldCompress(filename)
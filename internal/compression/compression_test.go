@@ -1,6 +1,7 @@
 package compression
 
 import (
+	"crypto/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -85,6 +86,20 @@ func TestShouldCompressFile(t *testing.T) {
 	}
 }
 
+func TestMaxCompressedSize(t *testing.T) {
+	for _, size := range []int{0, 1, 1000, 65535, 65536, 1 << 20} {
+		// Random data does not compress, so it is stored
+		data := make([]byte, size)
+		rand.Read(data)
+
+		for _, filename := range []string{"data.bin", "data.txt"} {
+			compressed, err := CompressData(data, filename)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, int64(len(compressed)), MaxCompressedSize(int64(size)), "size %d", size)
+		}
+	}
+}
+
 func TestGetCompressionRatio(t *testing.T) {
 	tests := []struct {
 		original   int
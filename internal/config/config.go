@@ -3,22 +3,41 @@ package config
 import (
 	"flag"
 	"fmt"
-	"runtime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	"justdatacopier/internal/ratelimit"
 )
 
 // Constants for default values
 const (
-	DefaultChunkSize  = 2 * 1024 * 1024 // 2MB
-	DefaultBufferSize = 512 * 1024      // 512KB
-	DefaultTimeout    = 2 * time.Minute
-	DefaultRetries    = 5
-	DefaultChunkDelay = 10 * time.Millisecond
-	DefaultMinDelay   = 1 * time.Millisecond
-	DefaultMaxDelay   = 100 * time.Millisecond
-	DefaultListenAddr = "0.0.0.0:8000"
-	DefaultServerAddr = "localhost:8000"
-	DefaultOutputDir  = "./output"
+	DefaultChunkSize   = 2 * 1024 * 1024   // 2MB
+	MaxChunkSize       = 256 * 1024 * 1024 // 256MB
+	DefaultMaxChunk    = 16 * 1024 * 1024  // 16MB, largest chunk size accepted from clients
+	DefaultBufferSize  = 512 * 1024        // 512KB
+	DefaultTimeout     = 2 * time.Minute
+	DefaultRetries     = 5
+	DefaultChunkDelay  = 10 * time.Millisecond
+	DefaultMinDelay    = 1 * time.Millisecond
+	DefaultMaxDelay    = 100 * time.Millisecond
+	DefaultListenAddr  = "0.0.0.0:8000"
+	DefaultServerAddr  = "localhost:8000"
+	DefaultOutputDir   = "./output"
+	DefaultSpoolDir    = "./spool"
+	DefaultHookTimeout = 5 * time.Minute
+
+	// Reconnect constants
+	DefaultReconnectTimeout = 10 * time.Minute
+	ReconnectMinBackoff     = 1 * time.Second
+	ReconnectMaxBackoff     = 30 * time.Second
+
+	// Webhook constants
+	DefaultWebhookRetries = 3
+	WebhookTimeout        = 10 * time.Second
+	WebhookFlushTimeout   = 30 * time.Second
 
 	// Buffer size constants
 	SmallWriteSize  = 8 * 1024   // 8KB
@@ -26,6 +45,9 @@ const (
 	LargeWriteSize  = 64 * 1024  // 64KB
 	MaxWriteSize    = 256 * 1024 // 256KB
 
+	// Memory for chunks read once and held until every destination sent them
+	FanoutBufferSize = 64 * 1024 * 1024 // 64MB
+
 	// Network constants
 	TCPBufferSize  = 1024 * 1024     // 1MB
 	HashBufferSize = 4 * 1024 * 1024 // 4MB
@@ -34,20 +56,98 @@ const (
 
 	// File system constants
 	StateFileExt   = ".justdatacopier.state"
+	PartFileExt    = ".jdcpart"
+	RelayJobExt    = ".jdcrelay"
 	LogDirPerms    = 0755
 	StateFilePerms = 0644
+	VersionsDir    = ".versions"
+	HistoryFile    = ".justdatacopier.history.jsonl"
+)
+
+// Collision policies applied when a received file's destination already exists
+const (
+	CollisionFail      = "fail"               // Reject the transfer
+	CollisionOverwrite = "overwrite"          // Replace the existing file
+	CollisionRename    = "rename-with-suffix" // Store as name_1.ext, name_2.ext, ...
+	CollisionVersion   = "version"            // Store as name.<timestamp>.ext
+
+	DefaultCollisionPolicy = CollisionOverwrite
+)
+
+// Logging defaults and formats
+const (
+	DefaultLogLevel      = "info"
+	DefaultLogDir        = "logs"
+	DefaultLogRotateSize = 100 // MB
+	DefaultLogRotateAge  = 24 * time.Hour
+	DefaultLogKeep       = 10
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Targets for the JSON progress stream; any other value is a file descriptor number
+const (
+	ProgressStdout = "stdout"
+	ProgressStderr = "stderr"
 )
 
 // Config holds all configuration parameters for the application
 type Config struct {
+	// Command being run: CommandServe, CommandSend, CommandGet, CommandVerify or
+	// CommandRelay
+	Command string
+
 	// Server mode settings
-	IsServer      bool
-	ListenAddress string
-	OutputDir     string
+	IsServer        bool
+	AllowGet        bool
+	ListenAddress   string
+	ReverseConnect  string // Client address to connect out to instead of listening
+	OutputDir       string
+	CollisionPolicy string
+	KeepVersions    int
+	VersionsDir     string
+	VersionsMaxAge  time.Duration
+	History         bool
+	HistoryFile     string
+	OnCompleteHook  string
+	OnFailureHook   string
+	HookTimeout     time.Duration
+	MaxClientChunk  int64 // Largest chunk size accepted from clients, 0 for DefaultMaxChunk
+
+	// Bandwidth shared by all transfers of a server
+	TotalRateLimit    int64              // Bytes per second, 0 for unlimited
+	TotalRateSchedule ratelimit.Schedule // Weekly schedule overriding TotalRateLimit
 
 	// Client mode settings
-	ServerAddress string
-	FilePath      string
+	ServerAddress    string
+	FilePath         string
+	RemoteName       string        // File on the server for get and verify
+	ReconnectTimeout time.Duration // How long to keep reconnecting after the connection drops
+	Proxy            string        // SOCKS5 or HTTP CONNECT proxy URL, empty to connect directly
+	NoProxy          string        // Comma-separated hosts to connect to directly, from NO_PROXY
+	ReverseListen    string        // Address to wait for the server's connections on instead of dialing it
+	SkipProfiling    bool          // Send with default network settings, as no profiling connection can be opened
+	LegacyProtocol   bool          // Send with the original handshake, as the server predates protocol versions
+
+	// Notification settings
+	WebhookURL     string
+	WebhookSecret  string
+	WebhookRetries int
+
+	// Monitoring settings
+	MetricsAddress string
+	AdminAddress   string
+	ReportDir      string
+
+	// Logging settings
+	LogLevel      string
+	LogFormat     string
+	LogDir        string
+	LogRotateSize int64
+	LogRotateAge  time.Duration
+	LogKeep       int
+	LogKeepAge    time.Duration
 
 	// Common parameters
 	ChunkSize     int64
@@ -56,12 +156,15 @@ type Config struct {
 	Compression   bool
 	VerifyHash    bool
 	ShowProgress  bool
+	ProgressJSON  string
 	Timeout       time.Duration
 	Retries       int
 	ChunkDelay    time.Duration
 	AdaptiveDelay bool
-	MinDelay      time.Duration
-	MaxDelay      time.Duration
+	MinDelay      time.Duration      // Deprecated: adaptive networking no longer delays chunks
+	MaxDelay      time.Duration      // Deprecated: adaptive networking no longer delays chunks
+	RateLimit     int64              // Bytes per second for each transfer, 0 for unlimited
+	RateSchedule  ratelimit.Schedule // Weekly schedule overriding RateLimit
 }
 
 // Validate checks if the configuration is valid
@@ -69,6 +172,12 @@ func (c *Config) Validate() error {
 	if c.ChunkSize <= 0 {
 		return fmt.Errorf("chunk size must be positive")
 	}
+	if c.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("chunk size cannot exceed %dMB", MaxChunkSize/(1024*1024))
+	}
+	if c.MaxClientChunk < 0 || c.MaxClientChunk > MaxChunkSize {
+		return fmt.Errorf("max chunk size must be between 0 and %dMB", MaxChunkSize/(1024*1024))
+	}
 	if c.BufferSize <= 0 {
 		return fmt.Errorf("buffer size must be positive")
 	}
@@ -81,12 +190,81 @@ func (c *Config) Validate() error {
 	if c.Timeout <= 0 {
 		return fmt.Errorf("timeout must be positive")
 	}
-	if c.AdaptiveDelay && (c.MinDelay <= 0 || c.MaxDelay <= 0 || c.MinDelay > c.MaxDelay) {
-		return fmt.Errorf("invalid adaptive delay configuration")
+	if c.ReconnectTimeout < 0 {
+		return fmt.Errorf("reconnect timeout cannot be negative")
+	}
+	if c.Proxy != "" {
+		if _, err := ParseProxy(c.Proxy); err != nil {
+			return err
+		}
 	}
 
-	if !c.IsServer && c.FilePath == "" {
-		return fmt.Errorf("file path is required in client mode")
+	switch c.command() {
+	case CommandSend, CommandVerify:
+		if c.FilePath == "" {
+			return fmt.Errorf("file path is required in client mode")
+		}
+	case CommandGet:
+		if c.RemoteName == "" {
+			return fmt.Errorf("remote file name is required")
+		}
+	case CommandRelay:
+		if c.ServerAddress == "" && c.ReverseListen == "" {
+			return fmt.Errorf("upstream server address is required in relay mode")
+		}
+	}
+	if destinations := c.Destinations(); len(destinations) > 1 {
+		if err := c.validateDestinations(destinations); err != nil {
+			return err
+		}
+	}
+	if c.RemoteName != "" && filepath.Base(c.RemoteName) != c.RemoteName {
+		return fmt.Errorf("remote file name must not contain a path")
+	}
+
+	if c.KeepVersions < 0 {
+		return fmt.Errorf("keep versions cannot be negative")
+	}
+	if c.VersionsMaxAge < 0 {
+		return fmt.Errorf("versions max age cannot be negative")
+	}
+
+	if (c.OnCompleteHook != "" || c.OnFailureHook != "") && c.HookTimeout <= 0 {
+		return fmt.Errorf("hook timeout must be positive")
+	}
+
+	if c.WebhookRetries < 0 {
+		return fmt.Errorf("webhook retries cannot be negative")
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid log level %q, must be debug, info, warn or error", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format %q, must be text or json", c.LogFormat)
+	}
+	if c.LogRotateSize < 0 || c.LogRotateAge < 0 || c.LogKeep < 0 || c.LogKeepAge < 0 {
+		return fmt.Errorf("log rotation and retention settings cannot be negative")
+	}
+
+	switch c.ProgressJSON {
+	case "", ProgressStdout, ProgressStderr:
+	default:
+		if fd, err := strconv.Atoi(c.ProgressJSON); err != nil || fd <= 0 {
+			return fmt.Errorf("progress JSON target must be stdout, stderr or a file descriptor number")
+		}
+	}
+
+	if c.IsServer || c.Command == CommandGet {
+		switch c.CollisionPolicy {
+		case "", CollisionFail, CollisionOverwrite, CollisionRename, CollisionVersion:
+		default:
+			return fmt.Errorf("invalid collision policy %q", c.CollisionPolicy)
+		}
 	}
 
 	return nil
@@ -94,51 +272,31 @@ func (c *Config) Validate() error {
 
 // ParseFlags parses command line arguments and returns a Config
 func ParseFlags() (*Config, error) {
-	// Server flags
-	isServer := flag.Bool("server", false, "Run in server mode")
-	listenAddr := flag.String("listen", DefaultListenAddr, "Address to listen on (server mode)")
-	outputDir := flag.String("output", DefaultOutputDir, "Directory to store received files (server mode)")
-
-	// Client flags
-	serverAddr := flag.String("connect", DefaultServerAddr, "Server address to connect to (client mode)")
-	filePath := flag.String("file", "", "File to transfer (client mode)")
-
-	// Common flags
-	chunkSize := flag.Int64("chunk", DefaultChunkSize, "Chunk size in bytes (2MB default)")
-	bufferSize := flag.Int("buffer", DefaultBufferSize, "Buffer size in bytes (512KB default)")
-	workers := flag.Int("workers", runtime.NumCPU()/2, "Number of worker threads")
-	compression := flag.Bool("compress", false, "Enable gzip compression")
-	verifyHash := flag.Bool("verify", false, "Verify file integrity using hash comparison between client and server")
-	showProgress := flag.Bool("progress", true, "Show progress during transfer")
-	timeout := flag.Duration("timeout", DefaultTimeout, "Operation timeout")
-	retries := flag.Int("retries", DefaultRetries, "Number of retries for failed operations")
-	chunkDelay := flag.Duration("delay", DefaultChunkDelay, "Delay between chunk transfers")
-	adaptiveDelay := flag.Bool("adaptive", false, "Use adaptive delay based on network conditions")
-	minDelay := flag.Duration("min-delay", DefaultMinDelay, "Minimum delay for adaptive networking")
-	maxDelay := flag.Duration("max-delay", DefaultMaxDelay, "Maximum delay for adaptive networking")
-
-	flag.Parse()
-
-	config := &Config{
-		IsServer:      *isServer,
-		ListenAddress: *listenAddr,
-		OutputDir:     *outputDir,
-		ServerAddress: *serverAddr,
-		FilePath:      *filePath,
-		ChunkSize:     *chunkSize,
-		BufferSize:    *bufferSize,
-		Workers:       *workers,
-		Compression:   *compression,
-		VerifyHash:    *verifyHash,
-		ShowProgress:  *showProgress,
-		Timeout:       *timeout,
-		Retries:       *retries,
-		ChunkDelay:    *chunkDelay,
-		AdaptiveDelay: *adaptiveDelay,
-		MinDelay:      *minDelay,
-		MaxDelay:      *maxDelay,
+	return ParseArgs(os.Args[1:], os.Environ())
+}
+
+// ParseArgs parses args of the flag-only form, where -server selects the mode
+// and all flags are accepted, into a Config. Settings are taken from the built-in
+// defaults, then the config file and its selected profile, then the flags in
+// args, and finally JDC_* variables in environ.
+func ParseArgs(args, environ []string) (*Config, error) {
+	config := newConfig()
+	fs := flag.NewFlagSet(programName(), flag.ExitOnError)
+	fs.BoolVar(&config.IsServer, "server", false, "Run in server mode")
+	for _, group := range allFlagGroups {
+		group(fs, config)
 	}
 
+	if err := parse(fs, config, args, environ); err != nil {
+		return nil, err
+	}
+
+	config.Command = CommandSend
+	if config.IsServer {
+		config.Command = CommandServe
+	}
+	config.applyProxyEnvironment(environ)
+
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -146,6 +304,132 @@ func ParseFlags() (*Config, error) {
 	return config, nil
 }
 
+// ParseCommand parses the arguments of a subcommand such as serve or send into
+// a Config. Only the flags that apply to the command are accepted; settings are
+// layered as in ParseArgs.
+func ParseCommand(command string, args, environ []string) (*Config, error) {
+	groups, ok := commandFlags[command]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+
+	config := newConfig()
+	config.Command = command
+	config.IsServer = command == CommandServe || command == CommandRelay
+
+	fs := flag.NewFlagSet(programName()+" "+command, flag.ExitOnError)
+	for _, group := range groups {
+		group(fs, config)
+	}
+
+	if err := parse(fs, config, args, environ); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	config.applyProxyEnvironment(environ)
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// parse parses args with fs and applies the config file and environment
+func parse(fs *flag.FlagSet, config *Config, args, environ []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return applySettings(fs, environ)
+}
+
+// newConfig returns a Config holding the default of every setting, including
+// those without a flag in the command being parsed
+func newConfig() *Config {
+	config := &Config{}
+	fs := flag.NewFlagSet("defaults", flag.ContinueOnError)
+	for _, group := range allFlagGroups {
+		group(fs, config)
+	}
+	return config
+}
+
+// programName returns the name the program was invoked as
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// command returns the command being run, deriving it from the mode for configs
+// built without one
+func (c *Config) command() string {
+	if c.Command != "" {
+		return c.Command
+	}
+	if c.IsServer {
+		return CommandServe
+	}
+	return CommandSend
+}
+
+// Destinations returns the servers to connect to. Send accepts a
+// comma-separated list in ServerAddress to send the file to each of them.
+func (c *Config) Destinations() []string {
+	var destinations []string
+	for _, address := range strings.Split(c.ServerAddress, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			destinations = append(destinations, address)
+		}
+	}
+	return destinations
+}
+
+// validateDestinations checks a list of several servers to send to
+func (c *Config) validateDestinations(destinations []string) error {
+	if c.command() != CommandSend {
+		return fmt.Errorf("only send accepts several server addresses")
+	}
+	if c.ReverseListen != "" {
+		return fmt.Errorf("reverse listen cannot be used with several server addresses")
+	}
+
+	seen := make(map[string]bool)
+	for _, address := range destinations {
+		if seen[address] {
+			return fmt.Errorf("server address %q is given twice", address)
+		}
+		seen[address] = true
+	}
+	return nil
+}
+
+// AcceptedChunkSize returns the largest chunk size accepted from a client,
+// which is never less than the server's own chunk size
+func (c *Config) AcceptedChunkSize() int64 {
+	limit := c.MaxClientChunk
+	if limit == 0 {
+		limit = DefaultMaxChunk
+	}
+	return max(limit, c.ChunkSize)
+}
+
+// VersionsPath returns the directory where previous file versions are kept
+func (c *Config) VersionsPath() string {
+	if c.VersionsDir != "" {
+		return c.VersionsDir
+	}
+	return filepath.Join(c.OutputDir, VersionsDir)
+}
+
+// HistoryPath returns the file where finished transfers are recorded
+func (c *Config) HistoryPath() string {
+	if c.HistoryFile != "" {
+		return c.HistoryFile
+	}
+	return filepath.Join(c.OutputDir, HistoryFile)
+}
+
 // String returns a string representation of the config for logging
 func (c *Config) String() string {
 	mode := "Client"
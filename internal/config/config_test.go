@@ -103,6 +103,19 @@ func TestConfig_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "timeout must be positive",
 		},
+		{
+			name: "negative reconnect timeout",
+			config: Config{
+				ChunkSize:        1024 * 1024,
+				BufferSize:       512 * 1024,
+				Workers:          4,
+				Timeout:          time.Minute,
+				Retries:          3,
+				ReconnectTimeout: -time.Second,
+			},
+			wantErr: true,
+			errMsg:  "reconnect timeout cannot be negative",
+		},
 		{
 			name: "client without file path",
 			config: Config{
@@ -117,8 +130,9 @@ func TestConfig_Validate(t *testing.T) {
 			errMsg:  "file path is required in client mode",
 		},
 		{
-			name: "invalid adaptive delay config",
+			name: "deprecated adaptive delays are ignored",
 			config: Config{
+				IsServer:      true,
 				ChunkSize:     1024 * 1024,
 				BufferSize:    512 * 1024,
 				Workers:       4,
@@ -128,8 +142,63 @@ func TestConfig_Validate(t *testing.T) {
 				MinDelay:      100 * time.Millisecond,
 				MaxDelay:      50 * time.Millisecond, // Max < Min
 			},
+			wantErr: false,
+		},
+		{
+			name: "invalid progress JSON target",
+			config: Config{
+				IsServer:     true,
+				ChunkSize:    1024 * 1024,
+				BufferSize:   512 * 1024,
+				Workers:      4,
+				Timeout:      time.Minute,
+				Retries:      3,
+				ProgressJSON: "file.json",
+			},
+			wantErr: true,
+			errMsg:  "progress JSON target",
+		},
+		{
+			name: "invalid log level",
+			config: Config{
+				IsServer:   true,
+				ChunkSize:  1024 * 1024,
+				BufferSize: 512 * 1024,
+				Workers:    4,
+				Timeout:    time.Minute,
+				Retries:    3,
+				LogLevel:   "verbose",
+			},
+			wantErr: true,
+			errMsg:  "invalid log level",
+		},
+		{
+			name: "unsupported proxy scheme",
+			config: Config{
+				FilePath:   "data.bin",
+				ChunkSize:  1024 * 1024,
+				BufferSize: 512 * 1024,
+				Workers:    4,
+				Timeout:    time.Minute,
+				Retries:    3,
+				Proxy:      "https://proxy.example.com:443",
+			},
+			wantErr: true,
+			errMsg:  "unsupported proxy scheme",
+		},
+		{
+			name: "proxy without port",
+			config: Config{
+				FilePath:   "data.bin",
+				ChunkSize:  1024 * 1024,
+				BufferSize: 512 * 1024,
+				Workers:    4,
+				Timeout:    time.Minute,
+				Retries:    3,
+				Proxy:      "socks5://proxy.example.com",
+			},
 			wantErr: true,
-			errMsg:  "invalid adaptive delay configuration",
+			errMsg:  "host and port",
 		},
 	}
 
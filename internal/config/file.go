@@ -0,0 +1,243 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables that override flags, e.g.
+// JDC_CHUNK for -chunk and JDC_WEBHOOK_SECRET for -webhook-secret
+const EnvPrefix = "JDC_"
+
+// Built-in profiles for common network conditions. A profile of the same name in
+// the config file replaces the built-in one.
+var builtinProfiles = map[string]map[string]string{
+	// Fast, reliable local network: large chunks, many workers, no throttling
+	"lan": {
+		"chunk":    "8388608",
+		"buffer":   "1048576",
+		"workers":  "8",
+		"compress": "false",
+		"delay":    "0s",
+		"adaptive": "false",
+		"verify":   "false",
+	},
+	// High latency or lossy links: smaller chunks, compression and adaptive pacing
+	"wan": {
+		"chunk":             "1048576",
+		"buffer":            "262144",
+		"workers":           "4",
+		"compress":          "true",
+		"adaptive":          "true",
+		"verify":            "true",
+		"retries":           "10",
+		"reconnect-timeout": "30m",
+	},
+	// Unattended backups: verified, compressed and patient with slow links
+	"backup": {
+		"chunk":             "4194304",
+		"buffer":            "524288",
+		"workers":           "4",
+		"compress":          "true",
+		"adaptive":          "true",
+		"verify":            "true",
+		"retries":           "10",
+		"timeout":           "10m",
+		"reconnect-timeout": "2h",
+	},
+}
+
+// envExcluded lists flags that cannot be set from the environment. JDC_FILE is
+// set by the server for hook commands and must not redirect a client they start.
+var envExcluded = map[string]bool{
+	"file": true,
+}
+
+// File is a parsed config file. Settings and profiles map flag names without
+// the leading dash to values in flag syntax.
+type File struct {
+	Profile  string                       // Profile applied when -profile is not given
+	Settings map[string]string            // Top-level settings
+	Profiles map[string]map[string]string // Named profiles
+}
+
+// LoadFile reads a YAML config file such as:
+//
+//	profile: wan
+//	connect: backup.example.com:8000
+//	log-dir: /var/log/jdc
+//	profiles:
+//	  nightly:
+//	    chunk: 4194304
+//	    verify: true
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	file := &File{Profiles: make(map[string]map[string]string)}
+
+	if profile, ok := raw["profile"]; ok {
+		name, ok := profile.(string)
+		if !ok {
+			return nil, fmt.Errorf("config file %s: profile must be a name", path)
+		}
+		file.Profile = name
+		delete(raw, "profile")
+	}
+
+	if profiles, ok := raw["profiles"]; ok {
+		entries, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config file %s: profiles must be a mapping of names to settings", path)
+		}
+		for name, entry := range entries {
+			values, ok := entry.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("config file %s: profile %q must be a mapping of settings", path, name)
+			}
+			settings, err := settingValues(values)
+			if err != nil {
+				return nil, fmt.Errorf("config file %s: profile %q: %w", path, name, err)
+			}
+			file.Profiles[name] = settings
+		}
+		delete(raw, "profiles")
+	}
+
+	settings, err := settingValues(raw)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	file.Settings = settings
+
+	return file, nil
+}
+
+// settingValues converts decoded YAML scalars into flag values
+func settingValues(raw map[string]interface{}) (map[string]string, error) {
+	settings := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("setting %q must be a single value", name)
+		case nil:
+			settings[name] = ""
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+	return settings, nil
+}
+
+// applySettings fills in the flags of fs that were not given on the command line
+// from the config file and profile, then applies JDC_* environment overrides
+func applySettings(fs *flag.FlagSet, environ []string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	env := envSettings(fs, environ)
+
+	path := fs.Lookup("config").Value.String()
+	if value, ok := env["config"]; ok {
+		path = value
+	}
+	profileName := fs.Lookup("profile").Value.String()
+	if value, ok := env["profile"]; ok {
+		profileName = value
+	}
+
+	settings := make(map[string]string)
+	profiles := builtinProfiles
+
+	if path != "" {
+		file, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		for name, value := range file.Settings {
+			settings[name] = value
+		}
+		if profileName == "" {
+			profileName = file.Profile
+		}
+		if _, ok := file.Profiles[profileName]; ok {
+			profiles = file.Profiles
+		}
+	}
+
+	if profileName != "" {
+		profile, ok := profiles[profileName]
+		if !ok {
+			return fmt.Errorf("unknown profile %q", profileName)
+		}
+		for name, value := range profile {
+			settings[name] = value
+		}
+	}
+
+	for _, name := range sortedKeys(settings) {
+		if name == "config" || name == "profile" || !knownSetting(name) {
+			return fmt.Errorf("unknown setting %q in config file or profile", name)
+		}
+		// Settings of other commands are skipped, so one file can serve them all
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, settings[name]); err != nil {
+			return fmt.Errorf("invalid value %q for setting %q: %w", settings[name], name, err)
+		}
+	}
+
+	for _, name := range sortedKeys(env) {
+		if err := fs.Set(name, env[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s%s: %w", env[name], EnvPrefix, envName(name), err)
+		}
+	}
+
+	return nil
+}
+
+// envSettings returns the flag values set through JDC_* environment variables.
+// Variables that do not name a flag are ignored.
+func envSettings(fs *flag.FlagSet, environ []string) map[string]string {
+	settings := make(map[string]string)
+	for _, entry := range environ {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, EnvPrefix), "_", "-"))
+		if envExcluded[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		settings[name] = value
+	}
+	return settings
+}
+
+// envName returns the environment variable suffix for a flag name
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// sortedKeys returns the keys of m in order, so errors are reported consistently
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jdc.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestParseArgs_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+connect: files.example.com:8000
+workers: 3
+retries: 7
+profiles:
+  nightly:
+    chunk: 4194304
+    verify: true
+    timeout: 10m
+`)
+
+	cfg, err := ParseArgs(
+		[]string{"-config", path, "-profile", "nightly", "-file", "data.bin", "-retries", "2", "-workers", "5"},
+		[]string{"JDC_WORKERS=6", "JDC_FILE=other.bin", "HOME=/root"},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "files.example.com:8000", cfg.ServerAddress) // config file
+	assert.Equal(t, int64(4194304), cfg.ChunkSize)               // profile
+	assert.True(t, cfg.VerifyHash)
+	assert.Equal(t, 10*time.Minute, cfg.Timeout)
+	assert.Equal(t, 2, cfg.Retries)           // flag over config file
+	assert.Equal(t, 6, cfg.Workers)           // environment over flag
+	assert.Equal(t, "data.bin", cfg.FilePath) // JDC_FILE is reserved for hooks
+	assert.Equal(t, DefaultBufferSize, cfg.BufferSize)
+}
+
+func TestParseArgs_BuiltinProfiles(t *testing.T) {
+	for name := range builtinProfiles {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseArgs([]string{"-profile", name, "-file", "data.bin"}, nil)
+			assert.NoError(t, err)
+		})
+	}
+
+	cfg, err := ParseArgs([]string{"-file", "data.bin"}, []string{"JDC_PROFILE=wan"})
+	require.NoError(t, err)
+	assert.True(t, cfg.Compression)
+	assert.True(t, cfg.AdaptiveDelay)
+}
+
+func TestParseArgs_DefaultProfileFromFile(t *testing.T) {
+	path := writeConfigFile(t, "profile: lan\nworkers: 2\n")
+
+	cfg, err := ParseArgs([]string{"-config", path, "-file", "data.bin"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8388608), cfg.ChunkSize)
+	assert.Equal(t, 8, cfg.Workers) // the profile refines the top-level settings
+}
+
+func TestParseArgs_ConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+		errMsg  string
+	}{
+		{
+			name:   "unknown profile",
+			args:   []string{"-profile", "satellite"},
+			errMsg: `unknown profile "satellite"`,
+		},
+		{
+			name:    "unknown setting",
+			content: "chunk-size: 1024\n",
+			errMsg:  `unknown setting "chunk-size"`,
+		},
+		{
+			name:    "invalid value",
+			content: "workers: many\n",
+			errMsg:  `invalid value "many" for setting "workers"`,
+		},
+		{
+			name:    "nested setting",
+			content: "connect:\n  host: example.com\n",
+			errMsg:  `setting "connect" must be a single value`,
+		},
+		{
+			name:    "malformed file",
+			content: "workers: [\n",
+			errMsg:  "failed to parse config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-file", "data.bin"}, tt.args...)
+			if tt.content != "" {
+				args = append(args, "-config", writeConfigFile(t, tt.content))
+			}
+
+			_, err := ParseArgs(args, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
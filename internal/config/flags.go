@@ -0,0 +1,245 @@
+package config
+
+import (
+	"flag"
+	"runtime"
+	"strconv"
+
+	"justdatacopier/internal/ratelimit"
+)
+
+// Subcommands
+const (
+	CommandServe  = "serve"  // Receive files from clients
+	CommandSend   = "send"   // Send a file to a server
+	CommandGet    = "get"    // Download a file from a server
+	CommandVerify = "verify" // Compare a local file with the server's copy
+	CommandRelay  = "relay"  // Receive files from clients and forward them to a server
+)
+
+// flagGroup defines a related set of flags bound to the fields of a Config
+type flagGroup func(fs *flag.FlagSet, c *Config)
+
+// commandFlags lists the flag groups accepted by each subcommand
+var commandFlags = map[string][]flagGroup{
+	CommandServe: {
+		configFileFlags, listenFlags, serverLimitFlags, storageFlags, historyFlags, hookFlags,
+		notifyFlags, loggingFlags, monitoringFlags, progressFlags, transferFlags,
+	},
+	CommandSend: {
+		configFileFlags, connectFlags, reconnectFlags, fileFlags,
+		notifyFlags, loggingFlags, monitoringFlags, progressFlags, transferFlags,
+	},
+	CommandGet: {
+		configFileFlags, connectFlags, remoteNameFlags, storageFlags, historyFlags, hookFlags,
+		notifyFlags, loggingFlags, monitoringFlags, progressFlags, transferFlags,
+	},
+	CommandVerify: {
+		configFileFlags, connectFlags, fileFlags, remoteNameFlags, loggingFlags, timeoutFlags,
+	},
+	CommandRelay: {
+		configFileFlags, listenFlags, serverLimitFlags, relayFlags, historyFlags, hookFlags,
+		notifyFlags, loggingFlags, monitoringFlags, progressFlags, transferFlags,
+	},
+}
+
+// allFlagGroups holds every flag group, as accepted by the flag-only form
+var allFlagGroups = []flagGroup{
+	configFileFlags, listenFlags, serverLimitFlags, storageFlags, historyFlags, hookFlags,
+	connectFlags, reconnectFlags, fileFlags, remoteNameFlags,
+	notifyFlags, loggingFlags, monitoringFlags, progressFlags, transferFlags,
+}
+
+// knownSetting reports whether name is a flag of any command, so that one config
+// file can be shared by all of them
+func knownSetting(name string) bool {
+	if name == "server" {
+		return true
+	}
+	fs := flag.NewFlagSet("settings", flag.ContinueOnError)
+	for _, group := range allFlagGroups {
+		group(fs, &Config{})
+	}
+	if fs.Lookup(name) != nil {
+		return true
+	}
+
+	// Relay flags are only accepted by jdc relay
+	fs = flag.NewFlagSet("relay", flag.ContinueOnError)
+	for _, group := range commandFlags[CommandRelay] {
+		group(fs, &Config{})
+	}
+	return fs.Lookup(name) != nil
+}
+
+// configFileFlags selects the config file and profile
+func configFileFlags(fs *flag.FlagSet, c *Config) {
+	fs.String("config", "", "YAML config file with default settings and named profiles")
+	fs.String("profile", "", "Settings profile to apply: lan, wan, backup or one defined in the config file")
+}
+
+// listenFlags configure the server's listeners
+func listenFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.ListenAddress, "listen", DefaultListenAddr, "Address to listen on (server mode)")
+	fs.StringVar(&c.ReverseConnect, "reverse-connect", "",
+		"Connect out to a client waiting with -reverse-listen at this address instead of listening, for servers behind NAT")
+	fs.StringVar(&c.AdminAddress, "admin-listen", "", "Address to serve the server admin API on, e.g. 127.0.0.1:9200 (disabled by default)")
+	fs.BoolVar(&c.AllowGet, "allow-get", false, "Allow clients to download files from the output directory with jdc get and check them with jdc verify (server mode)")
+}
+
+// serverLimitFlags limit the server as a whole
+func serverLimitFlags(fs *flag.FlagSet, c *Config) {
+	fs.Int64Var(&c.MaxClientChunk, "max-chunk", DefaultMaxChunk,
+		"Largest chunk size in bytes accepted from clients; each transfer buffers one chunk")
+	fs.Var((*byteRate)(&c.TotalRateLimit), "total-rate-limit",
+		"Bandwidth shared by all transfers of the server, e.g. 200mbit or 20MB, 0 for unlimited")
+	fs.Var(&c.TotalRateSchedule, "total-rate-schedule",
+		"Weekly schedule for -total-rate-limit, e.g. \"mon-fri 08:00-18:00 100mbit\"")
+}
+
+// storageFlags control where and how received files are stored
+func storageFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.OutputDir, "output", DefaultOutputDir, "Directory to store received files")
+	fs.StringVar(&c.CollisionPolicy, "on-collision", DefaultCollisionPolicy,
+		"Policy when the destination file exists: fail, overwrite, rename-with-suffix, version")
+	fs.IntVar(&c.KeepVersions, "keep-versions", 0, "Number of previous versions of an overwritten file to keep, 0 disables")
+	fs.StringVar(&c.VersionsDir, "versions-dir", "", "Directory for previous file versions (default: <output>/"+VersionsDir+")")
+	fs.DurationVar(&c.VersionsMaxAge, "versions-max-age", 0, "Remove previous versions older than this, 0 keeps them regardless of age")
+}
+
+// historyFlags control the history of received files
+func historyFlags(fs *flag.FlagSet, c *Config) {
+	fs.BoolVar(&c.History, "history", true, "Record finished transfers in the history file")
+	fs.StringVar(&c.HistoryFile, "history-file", "", "History file for finished transfers (default: <output>/"+HistoryFile+")")
+}
+
+// hookFlags configure commands run after a file is received
+func hookFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.OnCompleteHook, "on-complete", "", "Command to run after a file is received successfully")
+	fs.StringVar(&c.OnFailureHook, "on-failure", "", "Command to run after a transfer fails")
+	fs.DurationVar(&c.HookTimeout, "hook-timeout", DefaultHookTimeout, "Maximum run time for hook commands")
+}
+
+// connectFlags select the server to connect to
+func connectFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.ServerAddress, "connect", DefaultServerAddr,
+		"Server address to connect to; send accepts a comma-separated list to send the file to each server")
+	routeFlags(fs, c)
+}
+
+// relayFlags select the spool directory and the upstream server of a relay
+func relayFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.ServerAddress, "upstream", "", "Server address to forward received files to (required)")
+	fs.StringVar(&c.OutputDir, "spool", DefaultSpoolDir, "Directory to keep received files in until they are forwarded")
+	routeFlags(fs, c)
+}
+
+// routeFlags select how connections to the server are made
+func routeFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Proxy, "proxy", "",
+		"Proxy to connect through: socks5://[user:pass@]host:port, socks5h://..., http://[user:pass@]host:port or direct (default: ALL_PROXY, HTTPS_PROXY or HTTP_PROXY)")
+	fs.StringVar(&c.ReverseListen, "reverse-listen", "",
+		"Wait on this address for a server started with -reverse-connect to connect, instead of connecting to -connect")
+}
+
+// reconnectFlags control reconnecting after the connection drops mid-transfer
+func reconnectFlags(fs *flag.FlagSet, c *Config) {
+	fs.DurationVar(&c.ReconnectTimeout, "reconnect-timeout", DefaultReconnectTimeout,
+		"Keep reconnecting and resuming for this long after the connection drops, 0 disables")
+}
+
+// fileFlags select the local file
+func fileFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.FilePath, "file", "", "Local file to transfer or verify")
+}
+
+// remoteNameFlags select a file on the server
+func remoteNameFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.RemoteName, "name", "", "Name of the file in the server's output directory (verify defaults to the local file name)")
+}
+
+// notifyFlags configure webhook notifications
+func notifyFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.WebhookURL, "webhook-url", "", "HTTP endpoint to POST transfer events to")
+	fs.StringVar(&c.WebhookSecret, "webhook-secret", "", "Secret for the webhook HMAC-SHA256 signature (or JDC_WEBHOOK_SECRET)")
+	fs.IntVar(&c.WebhookRetries, "webhook-retries", DefaultWebhookRetries, "Number of retries for failed webhook deliveries")
+}
+
+// loggingFlags configure log output and log files
+func loggingFlags(fs *flag.FlagSet, c *Config) {
+	c.LogRotateSize = DefaultLogRotateSize * 1024 * 1024
+	fs.StringVar(&c.LogLevel, "log-level", DefaultLogLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", LogFormatText, "Log format: text or json")
+	fs.StringVar(&c.LogDir, "log-dir", DefaultLogDir, "Directory for log files, empty logs to the console only")
+	fs.Var((*megabytes)(&c.LogRotateSize), "log-rotate-size", "Start a new log file after this many MB, 0 disables")
+	fs.DurationVar(&c.LogRotateAge, "log-rotate-age", DefaultLogRotateAge, "Start a new log file after this long, 0 disables")
+	fs.IntVar(&c.LogKeep, "log-keep", DefaultLogKeep, "Number of old log files to keep, 0 keeps all")
+	fs.DurationVar(&c.LogKeepAge, "log-keep-age", 0, "Remove old log files older than this, 0 keeps them regardless of age")
+}
+
+// monitoringFlags configure metrics and transfer reports
+func monitoringFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.MetricsAddress, "metrics-listen", "", "Address to serve Prometheus metrics on, e.g. 127.0.0.1:9100 (disabled by default)")
+	fs.StringVar(&c.ReportDir, "report-dir", "", "Directory to write a JSON report to after each transfer (disabled by default)")
+}
+
+// progressFlags configure progress output
+func progressFlags(fs *flag.FlagSet, c *Config) {
+	fs.BoolVar(&c.ShowProgress, "progress", true, "Show progress during transfer")
+	fs.StringVar(&c.ProgressJSON, "progress-json", "", "Write newline-delimited JSON progress events to stdout, stderr or a file descriptor number")
+}
+
+// transferFlags tune how file data is transferred
+func transferFlags(fs *flag.FlagSet, c *Config) {
+	fs.Int64Var(&c.ChunkSize, "chunk", DefaultChunkSize, "Chunk size in bytes (2MB default)")
+	fs.IntVar(&c.BufferSize, "buffer", DefaultBufferSize, "Buffer size in bytes (512KB default)")
+	fs.IntVar(&c.Workers, "workers", max(1, runtime.NumCPU()/2), "Number of worker threads")
+	fs.BoolVar(&c.Compression, "compress", false, "Enable gzip compression")
+	fs.BoolVar(&c.VerifyHash, "verify", false, "Verify file integrity using hash comparison between client and server")
+	timeoutFlags(fs, c)
+	fs.IntVar(&c.Retries, "retries", DefaultRetries, "Number of retries for failed operations")
+	fs.DurationVar(&c.ChunkDelay, "delay", DefaultChunkDelay, "Delay between chunk transfers without -adaptive")
+	fs.BoolVar(&c.AdaptiveDelay, "adaptive", false, "Pace chunks and keep several in flight based on measured bandwidth and RTT")
+	fs.DurationVar(&c.MinDelay, "min-delay", DefaultMinDelay, "Deprecated, has no effect: -adaptive paces chunks instead of delaying them")
+	fs.DurationVar(&c.MaxDelay, "max-delay", DefaultMaxDelay, "Deprecated, has no effect: -adaptive paces chunks instead of delaying them")
+	fs.Var((*byteRate)(&c.RateLimit), "rate-limit", "Bandwidth of each transfer, e.g. 50mbit or 5MB, 0 for unlimited")
+	fs.Var(&c.RateSchedule, "rate-schedule", "Weekly schedule for -rate-limit, e.g. \"mon-fri 08:00-18:00 10mbit\"")
+}
+
+// timeoutFlags set the operation timeout
+func timeoutFlags(fs *flag.FlagSet, c *Config) {
+	fs.DurationVar(&c.Timeout, "timeout", DefaultTimeout, "Operation timeout")
+}
+
+// megabytes is a flag value given in MB and stored in bytes
+type megabytes int64
+
+func (m *megabytes) String() string {
+	return strconv.FormatInt(int64(*m)/(1024*1024), 10)
+}
+
+func (m *megabytes) Set(value string) error {
+	mb, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*m = megabytes(mb * 1024 * 1024)
+	return nil
+}
+
+// byteRate is a flag value given as a rate such as 50mbit and stored in bytes
+// per second
+type byteRate int64
+
+func (r *byteRate) String() string {
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+func (r *byteRate) Set(value string) error {
+	rate, err := ratelimit.ParseRate(value)
+	if err != nil {
+		return err
+	}
+	*r = byteRate(rate)
+	return nil
+}
@@ -1,19 +1,22 @@
 package errors
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Error types for different categories of failures
 var (
-	ErrNetwork     = errors.New("network error")
-	ErrFileSystem  = errors.New("file system error")
-	ErrProtocol    = errors.New("protocol error")
-	ErrCompression = errors.New("compression error")
-	ErrValidation  = errors.New("validation error")
-	ErrTimeout     = errors.New("timeout error")
-	ErrCancelled   = errors.New("operation cancelled")
+	ErrNetwork      = errors.New("network error")
+	ErrFileSystem   = errors.New("file system error")
+	ErrProtocol     = errors.New("protocol error")
+	ErrCompression  = errors.New("compression error")
+	ErrValidation   = errors.New("validation error")
+	ErrTimeout      = errors.New("timeout error")
+	ErrCancelled    = errors.New("operation cancelled")
+	ErrHashMismatch = errors.New("hash mismatch")
 )
 
 // NetworkError represents network-related errors
@@ -109,6 +112,56 @@ func (e *ValidationError) Is(target error) bool {
 	return target == ErrValidation
 }
 
+// HashMismatchError reports that a file's hash differs from the expected one
+type HashMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *HashMismatchError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("hash mismatch (%s): expected %s", e.Algorithm, e.Expected)
+	}
+	return fmt.Sprintf("hash mismatch (%s): expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+func (e *HashMismatchError) Is(target error) bool {
+	return target == ErrHashMismatch
+}
+
+// TransferError attaches the ID of the transfer in which an error occurred
+type TransferError struct {
+	TransferID string
+	Err        error
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("transfer %s: %v", e.TransferID, e.Err)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// FanoutError reports the servers a file could not be sent to, of the several
+// it was sent to at once. It wraps the first of their errors, which decides the
+// error's category.
+type FanoutError struct {
+	Failed  []string
+	Servers int
+	Err     error
+}
+
+func (e *FanoutError) Error() string {
+	return fmt.Sprintf("sending to %d of %d servers failed (%s): %v",
+		len(e.Failed), e.Servers, strings.Join(e.Failed, ", "), e.Err)
+}
+
+func (e *FanoutError) Unwrap() error {
+	return e.Err
+}
+
 // Helper functions for creating errors
 
 func NewNetworkError(op, addr string, err error) error {
@@ -130,3 +183,107 @@ func NewCompressionError(op string, err error) error {
 func NewValidationError(field string, value interface{}, message string) error {
 	return &ValidationError{Field: field, Value: value, Message: message}
 }
+
+func NewHashMismatchError(algorithm, expected, actual string) error {
+	return &HashMismatchError{Algorithm: algorithm, Expected: expected, Actual: actual}
+}
+
+func NewFanoutError(failed []string, servers int, err error) error {
+	return &FanoutError{Failed: failed, Servers: servers, Err: err}
+}
+
+// WithTransferID wraps err with the ID of the transfer it occurred in. Errors
+// that already carry a transfer ID are returned unchanged.
+func WithTransferID(err error, transferID string) error {
+	if err == nil || transferID == "" || TransferID(err) != "" {
+		return err
+	}
+	return &TransferError{TransferID: transferID, Err: err}
+}
+
+// TransferID returns the transfer ID attached to err, if any
+func TransferID(err error) string {
+	var transferErr *TransferError
+	if errors.As(err, &transferErr) {
+		return transferErr.TransferID
+	}
+	return ""
+}
+
+// Is reports whether any error in err's chain matches target, as errors.Is does
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, as errors.As does
+func As(err error, target any) bool {
+	return errors.As(err, target)
+}
+
+// Category returns a short name for the category of an error, suitable for
+// reporting to external systems
+func Category(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrCancelled), errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrNetwork):
+		return "network"
+	case errors.Is(err, ErrFileSystem):
+		return "filesystem"
+	case errors.Is(err, ErrProtocol):
+		return "protocol"
+	case errors.Is(err, ErrCompression):
+		return "compression"
+	case errors.Is(err, ErrHashMismatch):
+		return "hash_mismatch"
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	default:
+		return "unknown"
+	}
+}
+
+// Process exit codes by error category, so that schedulers can tell failures
+// worth retrying from those that need attention
+const (
+	ExitOK           = 0   // Success
+	ExitUnknown      = 1   // Uncategorized failure
+	ExitValidation   = 2   // Invalid flags, configuration or input
+	ExitNetwork      = 3   // Connection failed or was lost
+	ExitFileSystem   = 4   // Local file could not be read or written
+	ExitProtocol     = 5   // Peer sent something unexpected or reported an error
+	ExitCompression  = 6   // Data could not be compressed or decompressed
+	ExitTimeout      = 7   // An operation timed out
+	ExitHashMismatch = 8   // File hashes differ after transfer or verification
+	ExitCancelled    = 130 // Interrupted by a signal or cancelled
+)
+
+// ExitCode returns the process exit code for an error's category
+func ExitCode(err error) int {
+	switch Category(err) {
+	case "":
+		return ExitOK
+	case "validation":
+		return ExitValidation
+	case "network":
+		return ExitNetwork
+	case "filesystem":
+		return ExitFileSystem
+	case "protocol":
+		return ExitProtocol
+	case "compression":
+		return ExitCompression
+	case "timeout":
+		return ExitTimeout
+	case "hash_mismatch":
+		return ExitHashMismatch
+	case "cancelled":
+		return ExitCancelled
+	default:
+		return ExitUnknown
+	}
+}
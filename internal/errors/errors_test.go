@@ -1,7 +1,9 @@
 package errors
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -74,3 +76,86 @@ func TestCompressionError(t *testing.T) {
 	assert.Contains(t, err.Error(), cause.Error())
 	assert.Contains(t, err.Error(), "compression error")
 }
+
+func TestHashMismatchError(t *testing.T) {
+	err := NewHashMismatchError("sha256", "abc", "def")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sha256")
+	assert.Contains(t, err.Error(), "abc")
+	assert.Contains(t, err.Error(), "def")
+	assert.ErrorIs(t, err, ErrHashMismatch)
+	assert.NotErrorIs(t, err, ErrValidation)
+}
+
+func TestCategory(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{NewNetworkError("dial", "localhost:8000", errors.New("refused")), "network"},
+		{NewFileSystemError("open", "/tmp/x", errors.New("denied")), "filesystem"},
+		{NewProtocolError("read", "bad command", nil), "protocol"},
+		{NewCompressionError("compress", errors.New("failed")), "compression"},
+		{NewValidationError("field", 1, "invalid"), "validation"},
+		{NewHashMismatchError("md5", "a", "b"), "hash_mismatch"},
+		{ErrTimeout, "timeout"},
+		{context.DeadlineExceeded, "timeout"},
+		{ErrCancelled, "cancelled"},
+		{fmt.Errorf("wrapped: %w", NewNetworkError("read", "", errors.New("reset"))), "network"},
+		{errors.New("something else"), "unknown"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, Category(tt.err))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, ExitOK},
+		{errors.New("something else"), ExitUnknown},
+		{NewValidationError("field", 1, "invalid"), ExitValidation},
+		{NewNetworkError("dial", "localhost:8000", errors.New("refused")), ExitNetwork},
+		{NewFileSystemError("open", "/tmp/x", errors.New("denied")), ExitFileSystem},
+		{NewProtocolError("read", "bad command", nil), ExitProtocol},
+		{NewCompressionError("compress", errors.New("failed")), ExitCompression},
+		{NewNetworkError("read", "", context.DeadlineExceeded), ExitTimeout},
+		{WithTransferID(NewHashMismatchError("md5", "a", "b"), "t1"), ExitHashMismatch},
+		{context.Canceled, ExitCancelled},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, ExitCode(tt.err), "error: %v", tt.err)
+	}
+}
+
+func TestWithTransferID(t *testing.T) {
+	cause := NewNetworkError("read", "", errors.New("reset"))
+	err := WithTransferID(cause, "abc123")
+
+	assert.Equal(t, "abc123", TransferID(err))
+	assert.Contains(t, err.Error(), "transfer abc123")
+	assert.Equal(t, "network", Category(err))
+
+	var networkErr *NetworkError
+	assert.True(t, As(err, &networkErr))
+
+	// Already tagged errors and nil are left alone
+	assert.Same(t, err, WithTransferID(err, "other"))
+	assert.Nil(t, WithTransferID(nil, "abc123"))
+	assert.Empty(t, TransferID(cause))
+}
+
+func TestFanoutError(t *testing.T) {
+	cause := WithTransferID(NewNetworkError("dial", "site-b:8000", errors.New("refused")), "abc123")
+	err := NewFanoutError([]string{"site-b:8000"}, 2, cause)
+
+	assert.Contains(t, err.Error(), "sending to 1 of 2 servers failed (site-b:8000)")
+	assert.Equal(t, "abc123", TransferID(err))
+	assert.Equal(t, ExitNetwork, ExitCode(err))
+}
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"time"
+)
+
+// Event types
+const (
+	SessionStart     = "session_start"
+	TransferProgress = "transfer_progress"
+	TransferComplete = "transfer_complete"
+	TransferFailed   = "transfer_failed"
+)
+
+// Roles of the process reporting an event
+const (
+	RoleServer = "server"
+	RoleClient = "client"
+)
+
+// Event describes a transfer milestone or outcome for consumption by external tooling
+type Event struct {
+	Type             string    `json:"type"`
+	Role             string    `json:"role,omitempty"`
+	TransferID       string    `json:"transfer_id,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	Filename         string    `json:"filename"`
+	Path             string    `json:"path,omitempty"`
+	Size             int64     `json:"size"`
+	BytesTransferred int64     `json:"bytes_transferred,omitempty"`
+	Percent          float64   `json:"percent,omitempty"`
+	HashAlgorithm    string    `json:"hash_algorithm,omitempty"`
+	Hash             string    `json:"hash,omitempty"`
+	RemoteAddr       string    `json:"remote_addr,omitempty"`
+	DurationSeconds  float64   `json:"duration_seconds"`
+	Error            string    `json:"error,omitempty"`
+	ErrorCategory    string    `json:"error_category,omitempty"`
+}
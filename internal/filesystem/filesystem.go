@@ -11,6 +11,8 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 // TransferState represents the state of a file transfer for resume capability
 type TransferState struct {
 	Filename       string    `json:"filename"`
+	TransferID     string    `json:"transfer_id,omitempty"`
 	FileSize       int64     `json:"file_size"`
 	ChunkSize      int64     `json:"chunk_size"`
 	NumChunks      int64     `json:"num_chunks"`
@@ -121,6 +124,39 @@ func LoadTransferState(filename, outputDir string) (*TransferState, error) {
 	return &state, nil
 }
 
+// ListTransferStates loads all transfer state files in outputDir
+func ListTransferStates(outputDir string) ([]*TransferState, error) {
+	matches, err := filepath.Glob(filepath.Join(outputDir, "*"+config.StateFileExt))
+	if err != nil {
+		return nil, errors.NewFileSystemError("glob_state", outputDir, err)
+	}
+
+	states := make([]*TransferState, 0, len(matches))
+	for _, match := range matches {
+		filename := strings.TrimSuffix(filepath.Base(match), config.StateFileExt)
+		state, err := LoadTransferState(filename, outputDir)
+		if err != nil {
+			slog.Warn("Skipping unreadable transfer state", "error", err)
+			continue
+		}
+		state.Filename = filename
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// CountReceivedChunks returns the number of chunks already received for a transfer
+func (s *TransferState) CountReceivedChunks() int64 {
+	var count int64
+	for _, received := range s.ChunksReceived {
+		if received {
+			count++
+		}
+	}
+	return count
+}
+
 // RemoveTransferState removes the transfer state file
 func RemoveTransferState(filename, outputDir string) error {
 	stateFile := filepath.Join(outputDir, filename+config.StateFileExt)
@@ -132,6 +168,191 @@ func RemoveTransferState(filename, outputDir string) error {
 	return nil
 }
 
+// PartFilePath returns the temporary path a file is written to until it is finalized
+func PartFilePath(outputPath string) string {
+	return outputPath + config.PartFileExt
+}
+
+// SuffixedFilename returns filename with a numeric suffix before its extension (name_1.ext)
+func SuffixedFilename(filename string, n int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(filename, ext), n, ext)
+}
+
+// TimestampedFilename returns filename with a timestamp before its extension (name.20060102-150405.ext)
+func TimestampedFilename(filename string, t time.Time) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(filename, ext), t.Format(versionTimestampLayout), ext)
+}
+
+// FileExists reports whether a file exists at path
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// versionTimestampLayout is the timestamp format embedded in version filenames
+const versionTimestampLayout = "20060102-150405"
+
+// Version describes a previous version of a file kept in a versions directory
+type Version struct {
+	Path      string
+	Timestamp time.Time
+}
+
+// RotateVersion moves an existing file into versionsDir under a timestamped name
+// and returns the new path
+func RotateVersion(path, versionsDir string, now time.Time) (string, error) {
+	if err := EnsureDirectoryExists(versionsDir); err != nil {
+		return "", err
+	}
+
+	versionName := TimestampedFilename(filepath.Base(path), now)
+	versionPath := filepath.Join(versionsDir, versionName)
+	for n := 1; FileExists(versionPath); n++ {
+		versionPath = filepath.Join(versionsDir, SuffixedFilename(versionName, n))
+	}
+
+	if err := os.Rename(path, versionPath); err != nil {
+		return "", errors.NewFileSystemError("rotate_version", path, err)
+	}
+
+	return versionPath, nil
+}
+
+// ListVersions returns the versions of filename kept in versionsDir, newest first
+func ListVersions(versionsDir, filename string) ([]Version, error) {
+	entries, err := os.ReadDir(versionsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.NewFileSystemError("read_dir", versionsDir, err)
+	}
+
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filename, ext) + "."
+
+	var versions []Version
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		// The timestamp directly follows the prefix and may carry a _N suffix
+		middle := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if len(middle) < len(versionTimestampLayout) {
+			continue
+		}
+		ts, err := time.ParseInLocation(versionTimestampLayout, middle[:len(versionTimestampLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+
+		versions = append(versions, Version{Path: filepath.Join(versionsDir, name), Timestamp: ts})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Timestamp.Equal(versions[j].Timestamp) {
+			return versions[i].Path > versions[j].Path
+		}
+		return versions[i].Timestamp.After(versions[j].Timestamp)
+	})
+
+	return versions, nil
+}
+
+// PruneVersions removes versions of filename beyond the newest keep versions and,
+// when maxAge is positive, versions older than maxAge
+func PruneVersions(versionsDir, filename string, keep int, maxAge time.Duration, now time.Time) error {
+	versions, err := ListVersions(versionsDir, filename)
+	if err != nil {
+		return err
+	}
+
+	for i, version := range versions {
+		expired := maxAge > 0 && now.Sub(version.Timestamp) > maxAge
+		if i < keep && !expired {
+			continue
+		}
+
+		if err := os.Remove(version.Path); err != nil && !os.IsNotExist(err) {
+			return errors.NewFileSystemError("remove_version", version.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// FinalizeFile flushes a completed temporary file to disk and atomically renames it
+// to its final path. The file is closed by this call.
+func FinalizeFile(file *os.File, finalPath string) error {
+	partPath := file.Name()
+	if err := syncAndClose(file); err != nil {
+		return err
+	}
+
+	if err := os.Rename(partPath, finalPath); err != nil {
+		return errors.NewFileSystemError("rename", finalPath, err)
+	}
+
+	return SyncDirectory(filepath.Dir(finalPath))
+}
+
+// FinalizeFileNoReplace is FinalizeFile for a destination that must not be
+// replaced. If a file exists at finalPath, the completed file is left at its
+// temporary path and the returned error wraps os.ErrExist.
+func FinalizeFileNoReplace(file *os.File, finalPath string) error {
+	partPath := file.Name()
+	if err := syncAndClose(file); err != nil {
+		return err
+	}
+
+	// Unlike a rename, a hard link fails rather than replace an existing file
+	if err := os.Link(partPath, finalPath); err != nil {
+		return errors.NewFileSystemError("link", finalPath, err)
+	}
+	if err := os.Remove(partPath); err != nil {
+		return errors.NewFileSystemError("remove", partPath, err)
+	}
+
+	return SyncDirectory(filepath.Dir(finalPath))
+}
+
+// syncAndClose flushes file to disk and closes it
+func syncAndClose(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.NewFileSystemError("sync", file.Name(), err)
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.NewFileSystemError("close", file.Name(), err)
+	}
+	return nil
+}
+
+// SyncDirectory flushes directory metadata so that a completed rename survives a crash
+func SyncDirectory(dir string) error {
+	// Directories cannot be opened for syncing on Windows
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.NewFileSystemError("open_dir", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return errors.NewFileSystemError("sync_dir", dir, err)
+	}
+
+	return nil
+}
+
 // PreallocateFile preallocates disk space for a file to improve performance
 func PreallocateFile(file *os.File, size int64) error {
 	// Try to use fallocate on supported systems
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -106,3 +107,142 @@ func TestValidateFilePath(t *testing.T) {
 	assert.Error(t, ValidateFilePath("../test.txt"))
 	assert.Error(t, ValidateFilePath("dir/../../test.txt"))
 }
+
+func TestFinalizeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	finalPath := filepath.Join(tmpDir, "backup.dat")
+	partPath := PartFilePath(finalPath)
+
+	file, err := os.Create(partPath)
+	require.NoError(t, err)
+
+	content := "completed transfer content"
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+
+	// Finalize should move the part file into place
+	require.NoError(t, FinalizeFile(file, finalPath))
+
+	data, err := os.ReadFile(finalPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	_, err = os.Stat(partPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFinalizeFileNoReplace(t *testing.T) {
+	tmpDir := t.TempDir()
+	finalPath := filepath.Join(tmpDir, "backup.dat")
+	partPath := PartFilePath(finalPath)
+
+	file, err := os.Create(partPath)
+	require.NoError(t, err)
+	require.NoError(t, FinalizeFileNoReplace(file, finalPath))
+
+	_, err = os.Stat(partPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// A file that appeared at the destination is not replaced
+	file, err = os.Create(partPath)
+	require.NoError(t, err)
+	_, err = file.WriteString("new content")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(finalPath, []byte("existing content"), 0644))
+
+	err = FinalizeFileNoReplace(file, finalPath)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	data, err := os.ReadFile(finalPath)
+	require.NoError(t, err)
+	assert.Equal(t, "existing content", string(data))
+	data, err = os.ReadFile(partPath)
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(data))
+}
+
+func TestSuffixedFilename(t *testing.T) {
+	assert.Equal(t, "backup_1.bak", SuffixedFilename("backup.bak", 1))
+	assert.Equal(t, "backup_12", SuffixedFilename("backup", 12))
+	assert.Equal(t, "archive.tar_2.gz", SuffixedFilename("archive.tar.gz", 2))
+}
+
+func TestTimestampedFilename(t *testing.T) {
+	ts := time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+	assert.Equal(t, "backup.20250314-092653.bak", TimestampedFilename("backup.bak", ts))
+	assert.Equal(t, "backup.20250314-092653", TimestampedFilename("backup", ts))
+}
+
+func TestRotateAndPruneVersions(t *testing.T) {
+	outputDir := t.TempDir()
+	versionsDir := filepath.Join(outputDir, ".versions")
+	path := filepath.Join(outputDir, "nightly.bak")
+	base := time.Date(2025, 3, 14, 1, 0, 0, 0, time.Local)
+
+	// Rotate four nightly backups, one day apart
+	for day := 0; day < 4; day++ {
+		require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("day %d", day)), 0644))
+		_, err := RotateVersion(path, versionsDir, base.AddDate(0, 0, day))
+		require.NoError(t, err)
+		assert.False(t, FileExists(path))
+	}
+
+	// An unrelated file must not be treated as a version
+	require.NoError(t, os.WriteFile(filepath.Join(versionsDir, "other.bak"), []byte("x"), 0644))
+
+	versions, err := ListVersions(versionsDir, "nightly.bak")
+	require.NoError(t, err)
+	require.Len(t, versions, 4)
+	assert.Equal(t, base.AddDate(0, 0, 3), versions[0].Timestamp)
+
+	// Keep the newest three, then drop anything older than 36 hours
+	now := base.AddDate(0, 0, 3)
+	require.NoError(t, PruneVersions(versionsDir, "nightly.bak", 3, 0, now))
+	versions, err = ListVersions(versionsDir, "nightly.bak")
+	require.NoError(t, err)
+	assert.Len(t, versions, 3)
+
+	require.NoError(t, PruneVersions(versionsDir, "nightly.bak", 3, 36*time.Hour, now))
+	versions, err = ListVersions(versionsDir, "nightly.bak")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	data, err := os.ReadFile(versions[1].Path)
+	require.NoError(t, err)
+	assert.Equal(t, "day 2", string(data))
+	assert.True(t, FileExists(filepath.Join(versionsDir, "other.bak")))
+}
+
+func TestListTransferStates(t *testing.T) {
+	outputDir := t.TempDir()
+
+	states, err := ListTransferStates(outputDir)
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	require.NoError(t, SaveTransferState(&TransferState{
+		Filename:       "first.bak",
+		FileSize:       300,
+		ChunkSize:      100,
+		NumChunks:      3,
+		ChunksReceived: []bool{true, false, true},
+	}, outputDir))
+	require.NoError(t, SaveTransferState(&TransferState{
+		Filename:       "second.bak",
+		FileSize:       100,
+		ChunkSize:      100,
+		NumChunks:      1,
+		ChunksReceived: []bool{false},
+	}, outputDir))
+
+	// Corrupt state files are skipped
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "broken.bak.justdatacopier.state"), []byte("{"), 0644))
+
+	states, err = ListTransferStates(outputDir)
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	assert.Equal(t, "first.bak", states[0].Filename)
+	assert.Equal(t, int64(2), states[0].CountReceivedChunks())
+	assert.Equal(t, "second.bak", states[1].Filename)
+}
@@ -0,0 +1,161 @@
+package history
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+)
+
+// dateLayout is the format accepted for whole days
+const dateLayout = "2006-01-02"
+
+// RunCommand implements `jdc history`, printing the transfers that match the
+// filters given in args to out
+func RunCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	outputDir := fs.String("output", config.DefaultOutputDir, "Server output directory holding the history file")
+	historyFile := fs.String("history-file", "", "History file (default: <output>/"+config.HistoryFile+")")
+	date := fs.String("date", "", "Only transfers finished on this day (YYYY-MM-DD)")
+	since := fs.String("since", "", "Only transfers finished at or after this time (YYYY-MM-DD, RFC 3339 or a duration such as 48h)")
+	until := fs.String("until", "", "Only transfers finished before this time (YYYY-MM-DD includes the whole day)")
+	client := fs.String("client", "", "Only transfers from client addresses containing this text")
+	name := fs.String("name", "", "Only transfers of this filename or glob pattern")
+	status := fs.String("status", "", "Only transfers with this status: completed or failed")
+	limit := fs.Int("limit", 0, "Show only the most recent entries, 0 shows all")
+	asJSON := fs.Bool("json", false, "Print entries as JSON lines")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errors.NewValidationError("arguments", args, err.Error())
+	}
+
+	filter, err := buildFilter(*date, *since, *until, time.Now())
+	if err != nil {
+		return err
+	}
+	filter.Client = *client
+	filter.Filename = *name
+	filter.Limit = *limit
+
+	switch *status {
+	case "", StatusCompleted, StatusFailed:
+		filter.Status = *status
+	default:
+		return errors.NewValidationError("status", *status, "must be completed or failed")
+	}
+	if _, err := filepath.Match(filter.Filename, ""); err != nil {
+		return errors.NewValidationError("name", filter.Filename, "invalid pattern")
+	}
+
+	path := *historyFile
+	if path == "" {
+		path = filepath.Join(*outputDir, config.HistoryFile)
+	}
+
+	entries, err := Query(path, filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(out)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return printTable(out, entries)
+}
+
+// buildFilter converts the date arguments of the history command into a filter
+func buildFilter(date, since, until string, now time.Time) (Filter, error) {
+	var filter Filter
+
+	if date != "" {
+		day, err := time.ParseInLocation(dateLayout, date, time.Local)
+		if err != nil {
+			return filter, errors.NewValidationError("date", date, "expected YYYY-MM-DD")
+		}
+		filter.Since = day
+		filter.Until = day.AddDate(0, 0, 1)
+	}
+
+	if since != "" {
+		t, err := parseTime(since, now, false)
+		if err != nil {
+			return filter, errors.NewValidationError("since", since, err.Error())
+		}
+		filter.Since = t
+	}
+
+	if until != "" {
+		t, err := parseTime(until, now, true)
+		if err != nil {
+			return filter, errors.NewValidationError("until", until, err.Error())
+		}
+		filter.Until = t
+	}
+
+	return filter, nil
+}
+
+// parseTime parses an absolute time, a day or a duration before now. A bare day
+// refers to its end when endOfDay is set, so that an upper bound includes it.
+func parseTime(value string, now time.Time, endOfDay bool) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected YYYY-MM-DD, RFC 3339 time or duration")
+}
+
+// printTable writes entries as an aligned table
+func printTable(out io.Writer, entries []Entry) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FINISHED\tSTATUS\tVERIFIED\tCLIENT\tSIZE_MB\tDURATION\tFILE\tERROR")
+
+	for _, entry := range entries {
+		verified := "-"
+		if entry.HashAlgorithm != "" {
+			verified = "no"
+			if entry.Verified {
+				verified = "yes"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f\t%s\t%s\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.Status,
+			verified,
+			entry.Client,
+			float64(entry.Size)/(1024*1024),
+			time.Duration(entry.DurationSeconds*float64(time.Second)).Round(100*time.Millisecond),
+			entry.Filename,
+			entry.Error)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+)
+
+// Transfer outcomes recorded in the history
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// maxLineSize bounds a single history record when reading the file
+const maxLineSize = 1024 * 1024
+
+// appendMu serializes appends from concurrent transfers
+var appendMu sync.Mutex
+
+// Entry is the record of one finished transfer
+type Entry struct {
+	Time            time.Time `json:"time"`
+	StartTime       time.Time `json:"start_time"`
+	Status          string    `json:"status"`
+	TransferID      string    `json:"transfer_id,omitempty"`
+	Filename        string    `json:"filename"`
+	Destination     string    `json:"destination,omitempty"`
+	Client          string    `json:"client"`
+	Size            int64     `json:"size"`
+	HashAlgorithm   string    `json:"hash_algorithm,omitempty"`
+	Hash            string    `json:"hash,omitempty"`
+	Verified        bool      `json:"verified"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+	ErrorCategory   string    `json:"error_category,omitempty"`
+}
+
+// Filter selects history entries. Zero fields match everything.
+type Filter struct {
+	Since    time.Time // Entries finished at or after this time
+	Until    time.Time // Entries finished before this time
+	Client   string    // Substring of the client address
+	Filename string    // Filename or glob pattern, e.g. "backup-*.tar"
+	Status   string    // StatusCompleted or StatusFailed
+	Limit    int       // Keep only the most recent entries
+}
+
+// Match reports whether an entry passes the filter, ignoring the limit
+func (f Filter) Match(entry Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Client != "" && !strings.Contains(entry.Client, f.Client) {
+		return false
+	}
+	if f.Filename != "" {
+		if matched, err := filepath.Match(f.Filename, entry.Filename); err != nil || !matched {
+			return false
+		}
+	}
+	if f.Status != "" && entry.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+// Append adds an entry to the history file, creating it if necessary
+func Append(path string, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.NewFileSystemError("marshal_history", path, err)
+	}
+
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), config.LogDirPerms); err != nil {
+		return errors.NewFileSystemError("create_history_dir", path, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, config.StateFilePerms)
+	if err != nil {
+		return errors.NewFileSystemError("open_history", path, err)
+	}
+	defer file.Close()
+
+	// Write each record with a single call so concurrent readers never see partial lines
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.NewFileSystemError("write_history", path, err)
+	}
+
+	return nil
+}
+
+// Query returns the entries matching the filter, oldest first. A missing history
+// file yields no entries. Lines that cannot be parsed are skipped.
+func Query(path string, filter Filter) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.NewFileSystemError("open_history", path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.NewFileSystemError("read_history", path, err)
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
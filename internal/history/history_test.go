@@ -0,0 +1,115 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntries() []Entry {
+	day := time.Date(2025, 6, 3, 0, 0, 0, 0, time.Local)
+	return []Entry{
+		{Time: day.Add(-time.Hour), Status: StatusCompleted, Filename: "backup-0602.tar", Client: "10.0.11.5"},
+		{Time: day.Add(2 * time.Hour), Status: StatusCompleted, Filename: "backup-0603.tar", Client: "10.0.12.7", HashAlgorithm: "sha256", Verified: true},
+		{Time: day.Add(3 * time.Hour), Status: StatusFailed, Filename: "report.pdf", Client: "10.0.12.7", Error: "connection reset"},
+		{Time: day.Add(26 * time.Hour), Status: StatusCompleted, Filename: "backup-0604.tar", Client: "10.0.12.7"},
+	}
+}
+
+func writeHistory(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "history", "history.jsonl")
+	for _, entry := range testEntries() {
+		require.NoError(t, Append(path, entry))
+	}
+	return path
+}
+
+func TestQuery(t *testing.T) {
+	path := writeHistory(t)
+	day := time.Date(2025, 6, 3, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"backup-0602.tar", "backup-0603.tar", "report.pdf", "backup-0604.tar"}},
+		{"day", Filter{Since: day, Until: day.AddDate(0, 0, 1)}, []string{"backup-0603.tar", "report.pdf"}},
+		{"client", Filter{Client: "10.0.12."}, []string{"backup-0603.tar", "report.pdf", "backup-0604.tar"}},
+		{"pattern", Filter{Filename: "backup-*.tar"}, []string{"backup-0602.tar", "backup-0603.tar", "backup-0604.tar"}},
+		{"status", Filter{Status: StatusFailed}, []string{"report.pdf"}},
+		{"limit", Filter{Filename: "backup-*", Limit: 2}, []string{"backup-0603.tar", "backup-0604.tar"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Query(path, tt.filter)
+			require.NoError(t, err)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Filename)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestQuerySkipsCorruptLines(t *testing.T) {
+	path := writeHistory(t)
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString("{not json\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	entries, err := Query(path, Filter{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestQueryMissingFile(t *testing.T) {
+	entries, err := Query(filepath.Join(t.TempDir(), "missing.jsonl"), Filter{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBuildFilter(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.Local)
+
+	filter, err := buildFilter("2025-06-03", "", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 3, 0, 0, 0, 0, time.Local), filter.Since)
+	assert.Equal(t, time.Date(2025, 6, 4, 0, 0, 0, 0, time.Local), filter.Until)
+
+	filter, err = buildFilter("", "48h", "2025-06-09", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-48*time.Hour), filter.Since)
+	assert.Equal(t, time.Date(2025, 6, 10, 0, 0, 0, 0, time.Local), filter.Until)
+
+	_, err = buildFilter("last tuesday", "", "", now)
+	assert.Error(t, err)
+}
+
+func TestRunCommand(t *testing.T) {
+	path := writeHistory(t)
+
+	var out bytes.Buffer
+	require.NoError(t, RunCommand([]string{"-history-file", path, "-date", "2025-06-03", "-client", "10.0.12.7"}, &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "VERIFIED")
+	assert.Contains(t, lines[1], "backup-0603.tar")
+	assert.Contains(t, lines[1], "yes")
+	assert.Contains(t, lines[2], "connection reset")
+
+	assert.Error(t, RunCommand([]string{"-history-file", path, "-status", "unknown"}, &out))
+}
@@ -0,0 +1,93 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"justdatacopier/internal/events"
+)
+
+// maxLoggedOutput limits how much hook output is written to the log
+const maxLoggedOutput = 4096
+
+// Run executes a hook command through the system shell. The event is passed both
+// as JDC_* environment variables and as JSON on the command's standard input.
+func Run(command string, event events.Event, timeout time.Duration) error {
+	if command == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), Environment(event)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	// Don't wait on background processes the hook leaves holding its output
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err = cmd.Run()
+	duration := time.Since(start)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook timed out after %s", timeout)
+	}
+	if err != nil {
+		slog.Warn("Hook output", "event", event.Type, "output", truncate(output.String()))
+		return fmt.Errorf("hook failed: %w", err)
+	}
+
+	slog.Info("Hook completed", "event", event.Type, "duration_ms", duration.Milliseconds())
+	slog.Debug("Hook output", "event", event.Type, "output", truncate(output.String()))
+	return nil
+}
+
+// Environment returns the JDC_* environment variables describing an event
+func Environment(event events.Event) []string {
+	return []string{
+		"JDC_EVENT=" + event.Type,
+		"JDC_TRANSFER_ID=" + event.TransferID,
+		"JDC_FILE=" + event.Path,
+		"JDC_FILENAME=" + event.Filename,
+		"JDC_SIZE=" + strconv.FormatInt(event.Size, 10),
+		"JDC_HASH_ALGORITHM=" + event.HashAlgorithm,
+		"JDC_HASH=" + event.Hash,
+		"JDC_CLIENT_ADDR=" + event.RemoteAddr,
+		"JDC_DURATION=" + strconv.FormatFloat(event.DurationSeconds, 'f', 3, 64),
+		"JDC_ERROR=" + event.Error,
+	}
+}
+
+// shellCommand wraps a command line in the platform shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// truncate shortens hook output for logging
+func truncate(output string) string {
+	if len(output) > maxLoggedOutput {
+		return output[:maxLoggedOutput] + "..."
+	}
+	return output
+}
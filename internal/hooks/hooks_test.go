@@ -0,0 +1,72 @@
+package hooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"justdatacopier/internal/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent() events.Event {
+	return events.Event{
+		Type:            events.TransferComplete,
+		Filename:        "backup.bak",
+		Path:            "/data/backup.bak",
+		Size:            1024,
+		HashAlgorithm:   "md5",
+		Hash:            "abc123",
+		RemoteAddr:      "10.0.0.5:51234",
+		DurationSeconds: 12.5,
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	env := Environment(testEvent())
+
+	assert.Contains(t, env, "JDC_EVENT=transfer_complete")
+	assert.Contains(t, env, "JDC_FILE=/data/backup.bak")
+	assert.Contains(t, env, "JDC_SIZE=1024")
+	assert.Contains(t, env, "JDC_HASH_ALGORITHM=md5")
+	assert.Contains(t, env, "JDC_HASH=abc123")
+	assert.Contains(t, env, "JDC_CLIENT_ADDR=10.0.0.5:51234")
+	assert.Contains(t, env, "JDC_DURATION=12.500")
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook test uses a POSIX shell")
+	}
+
+	outDir := t.TempDir()
+	stdinFile := filepath.Join(outDir, "stdin.json")
+	envFile := filepath.Join(outDir, "env.txt")
+
+	command := "cat > " + stdinFile + "; echo $JDC_SIZE > " + envFile
+	require.NoError(t, Run(command, testEvent(), 5*time.Second))
+
+	data, err := os.ReadFile(stdinFile)
+	require.NoError(t, err)
+
+	var received events.Event
+	require.NoError(t, json.Unmarshal(data, &received))
+	assert.Equal(t, "backup.bak", received.Filename)
+	assert.Equal(t, "abc123", received.Hash)
+
+	envData, err := os.ReadFile(envFile)
+	require.NoError(t, err)
+	assert.Equal(t, "1024\n", string(envData))
+
+	// Failing commands and timeouts are reported
+	assert.Error(t, Run("exit 3", testEvent(), 5*time.Second))
+	assert.Error(t, Run("sleep 5", testEvent(), 100*time.Millisecond))
+
+	// An empty command is a no-op
+	assert.NoError(t, Run("", testEvent(), time.Second))
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// TransferIDKey is the log attribute holding the transfer ID
+const TransferIDKey = "transfer_id"
+
+// transferIDContextKey is the context key for the transfer ID
+type transferIDContextKey struct{}
+
+// WithTransferID returns a context whose log records carry the transfer ID
+func WithTransferID(ctx context.Context, transferID string) context.Context {
+	return context.WithValue(ctx, transferIDContextKey{}, transferID)
+}
+
+// TransferIDFromContext returns the transfer ID stored in ctx, if any
+func TransferIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	transferID, _ := ctx.Value(transferIDContextKey{}).(string)
+	return transferID
+}
+
+// contextHandler adds the transfer ID from the record's context to every record,
+// so concurrent transfers can be told apart in the logs
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if transferID := TransferIDFromContext(ctx); transferID != "" {
+		r.AddAttrs(slog.String(TransferIDKey, transferID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,52 +1,68 @@
 package logging
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"time"
 
 	"justdatacopier/internal/config"
 	"justdatacopier/internal/errors"
-	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/ratelimit"
 )
 
-// SetupLogger initializes structured logging with file and console output
-func SetupLogger() error {
-	// Create logs directory if it doesn't exist
-	if err := filesystem.EnsureDirectoryExists("logs"); err != nil {
-		return err
+// SetupLogger initializes structured logging with console output and, unless
+// cfg.LogDir is empty, rotating log files. Console logs go to stderr instead of
+// stdout when stdout carries the JSON progress stream.
+func SetupLogger(cfg *config.Config) error {
+	console := io.Writer(os.Stdout)
+	if cfg.ProgressJSON == config.ProgressStdout {
+		console = os.Stderr
 	}
 
-	// Create log file with timestamp
-	logFileName := filepath.Join("logs",
-		"justdatacopier_"+time.Now().Format("20060102_150405")+".log")
-
-	logFile, err := os.Create(logFileName)
-	if err != nil {
-		// Continue with console logging only
-		slog.Warn("Failed to create log file, using console only", "error", err)
-		return nil
+	var level slog.Level
+	if cfg.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			return errors.NewValidationError("log_level", cfg.LogLevel, "must be debug, info, warn or error")
+		}
 	}
 
-	// Create multi-writer to log to both console and file
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
+	// Log to files as well as the console if a log directory is configured
+	output := console
+	var fileErr error
+	if cfg.LogDir != "" {
+		fileWriter, err := newRotatingWriter(cfg.LogDir, cfg.LogRotateSize, cfg.LogRotateAge, cfg.LogKeep, cfg.LogKeepAge)
+		if err != nil {
+			// Continue with console logging only
+			fileErr = err
+		} else {
+			output = io.MultiWriter(console, fileWriter)
+		}
+	}
 
 	// Create structured logger without source file/line information
 	opts := &slog.HandlerOptions{
-		Level:     slog.LevelInfo,
+		Level:     level,
 		AddSource: false, // Remove file names and line numbers
 	}
 
-	// Use text handler for better console readability
-	handler := slog.NewTextHandler(multiWriter, opts)
-	logger := slog.New(handler)
+	// Text handler for console readability unless JSON was requested
+	var handler slog.Handler
+	if cfg.LogFormat == config.LogFormatJSON {
+		handler = slog.NewJSONHandler(output, opts)
+	} else {
+		handler = slog.NewTextHandler(output, opts)
+	}
+
+	// Set as default logger, tagging records logged with a transfer context
+	slog.SetDefault(slog.New(contextHandler{handler}))
 
-	// Set as default logger
-	slog.SetDefault(logger)
+	if fileErr != nil {
+		slog.Warn("Failed to create log file, using console only", "error", fileErr)
+	}
 
-	slog.Info("Logging initialized", "session_id", time.Now().Format("20060102_150405"))
+	slog.Info("Logging initialized", "session_id", time.Now().Format("20060102_150405"), "log_level", level.String())
 	return nil
 }
 
@@ -64,14 +80,26 @@ func LogConfig(cfg *config.Config) {
 		"workers", cfg.Workers,
 		"compression", cfg.Compression,
 		"adaptive_delay", cfg.AdaptiveDelay,
-		"verify_hash", cfg.VerifyHash)
+		"verify_hash", cfg.VerifyHash,
+		"rate_limit", ratelimit.FormatRate(cfg.RateLimit),
+		"rate_schedule", cfg.RateSchedule.String())
 
 	if cfg.IsServer {
 		slog.Info("Server configuration",
 			"listen_address", cfg.ListenAddress,
+			"reverse_connect", cfg.ReverseConnect,
 			"max_file_size_mb", "unlimited",
 			"buffer_size_kb", float64(cfg.BufferSize)/1024,
-			"timeout_seconds", int(cfg.Timeout.Seconds()))
+			"timeout_seconds", int(cfg.Timeout.Seconds()),
+			"total_rate_limit", ratelimit.FormatRate(cfg.TotalRateLimit),
+			"total_rate_schedule", cfg.TotalRateSchedule.String())
+
+		if cfg.Command == config.CommandRelay {
+			slog.Info("Relay configuration",
+				"upstream_address", cfg.ServerAddress,
+				"proxy", redactedProxy(cfg),
+				"reverse_listen", cfg.ReverseListen)
+		}
 	} else {
 		// Get file size if file exists, but don't log the path
 		var fileSizeMB float64
@@ -81,53 +109,91 @@ func LogConfig(cfg *config.Config) {
 
 		slog.Info("Client configuration",
 			"server_address", cfg.ServerAddress,
+			"proxy", redactedProxy(cfg),
+			"reverse_listen", cfg.ReverseListen,
 			"file_size_mb", fileSizeMB,
 			"estimated_chunks", int64(fileSizeMB*1024*1024)/cfg.ChunkSize)
 	}
 }
 
-// LogError logs an error with appropriate context
+// redactedProxy returns the proxy of cfg for logging, as proxy credentials are
+// never logged
+func redactedProxy(cfg *config.Config) string {
+	if u, err := config.ParseProxy(cfg.Proxy); cfg.Proxy != "" && err == nil {
+		return u.Redacted()
+	}
+	return "none"
+}
+
+// LogError logs an error with appropriate context, including the transfer ID
+// attached to it, if any
 func LogError(err error, context string) {
-	switch e := err.(type) {
-	case *errors.NetworkError:
-		slog.Error("Network error",
+	logger := slog.Default()
+	if transferID := errors.TransferID(err); transferID != "" {
+		logger = logger.With(TransferIDKey, transferID)
+	}
+
+	var (
+		networkErr     *errors.NetworkError
+		fileSystemErr  *errors.FileSystemError
+		protocolErr    *errors.ProtocolError
+		compressionErr *errors.CompressionError
+		validationErr  *errors.ValidationError
+		hashErr        *errors.HashMismatchError
+	)
+
+	switch {
+	case errors.Category(err) == "cancelled":
+		// Whatever the error, the operation stopped because it was cancelled
+		logger.Warn("Cancelled",
 			"context", context,
-			"operation", e.Op,
-			"address", e.Addr,
+			"error_type", "cancelled")
+	case errors.As(err, &networkErr):
+		logger.Error("Network error",
+			"context", context,
+			"operation", networkErr.Op,
+			"address", networkErr.Addr,
 			"error_type", "network")
-	case *errors.FileSystemError:
-		slog.Error("File system error",
+	case errors.As(err, &fileSystemErr):
+		logger.Error("File system error",
 			"context", context,
-			"operation", e.Op,
+			"operation", fileSystemErr.Op,
 			"error_type", "filesystem")
-	case *errors.ProtocolError:
-		slog.Error("Protocol error",
+	case errors.As(err, &protocolErr):
+		logger.Error("Protocol error",
 			"context", context,
-			"operation", e.Op,
-			"message", e.Message,
+			"operation", protocolErr.Op,
+			"message", protocolErr.Message,
 			"error_type", "protocol")
-	case *errors.CompressionError:
-		slog.Error("Compression error",
+	case errors.As(err, &compressionErr):
+		logger.Error("Compression error",
 			"context", context,
-			"operation", e.Op,
+			"operation", compressionErr.Op,
 			"error_type", "compression")
-	case *errors.ValidationError:
-		slog.Error("Validation error",
+	case errors.As(err, &validationErr):
+		logger.Error("Validation error",
 			"context", context,
-			"field", e.Field,
-			"message", e.Message,
+			"field", validationErr.Field,
+			"message", validationErr.Message,
 			"error_type", "validation")
+	case errors.As(err, &hashErr):
+		logger.Error("Hash mismatch",
+			"context", context,
+			"algorithm", hashErr.Algorithm,
+			"expected", hashErr.Expected,
+			"actual", hashErr.Actual,
+			"error_type", "hash_mismatch")
 	default:
-		slog.Error("Unhandled error",
+		logger.Error("Unhandled error",
 			"context", context,
 			"error_type", "unknown")
 	}
 }
 
 // LogTransferProgress logs transfer progress information
-func LogTransferProgress(filename string, transferred, total int64, rate float64) {
+func LogTransferProgress(ctx context.Context, filename string, transferred, total int64, rate float64) {
 	percent := float64(transferred) / float64(total) * 100
-	slog.Info("Transfer progress",
+	slog.InfoContext(ctx, "Transfer progress",
 		"transferred_mb", float64(transferred)/(1024*1024),
 		"total_mb", float64(total)/(1024*1024),
 		"percent_complete", percent,
@@ -136,9 +202,9 @@ func LogTransferProgress(filename string, transferred, total int64, rate float64
 }
 
 // LogTransferComplete logs successful transfer completion
-func LogTransferComplete(filename string, size int64, duration time.Duration) {
+func LogTransferComplete(ctx context.Context, filename string, size int64, duration time.Duration) {
 	rate := float64(size) / (1024 * 1024) / duration.Seconds()
-	slog.Info("Transfer completed successfully",
+	slog.InfoContext(ctx, "Transfer completed successfully",
 		"total_size_mb", float64(size)/(1024*1024),
 		"duration_seconds", int(duration.Seconds()),
 		"average_rate_mbps", rate,
@@ -156,18 +222,19 @@ func LogChunkTransfer(chunkNum int64, chunkSize int64, totalChunks int64, rate f
 }
 
 // LogNetworkMetrics logs network performance metrics
-func LogNetworkMetrics(rtt time.Duration, bandwidth int64, packetLoss float64) {
+func LogNetworkMetrics(rtt, jitter time.Duration, bandwidth int64, packetLoss float64) {
 	slog.Info("Network metrics",
 		"round_trip_time_ms", rtt.Milliseconds(),
-		"estimated_bandwidth_mbps", float64(bandwidth)/(1024*1024),
+		"jitter_ms", float64(jitter.Microseconds())/1000,
+		"bandwidth_mbps", float64(bandwidth)/(1024*1024),
 		"packet_loss_percent", packetLoss*100,
 		"network_quality", getNetworkQuality(rtt, packetLoss))
 }
 
 // LogSessionStart logs the start of a transfer session
-func LogSessionStart(mode string, totalSize int64, chunkSize int64, workers int) {
+func LogSessionStart(ctx context.Context, mode string, totalSize int64, chunkSize int64, workers int) {
 	totalChunks := (totalSize + chunkSize - 1) / chunkSize // Ceiling division
-	slog.Info("Transfer session started",
+	slog.InfoContext(ctx, "Transfer session started",
 		"mode", mode,
 		"total_size_mb", float64(totalSize)/(1024*1024),
 		"chunk_size_kb", float64(chunkSize)/1024,
@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+)
+
+// Log file naming
+const (
+	logFilePrefix    = "justdatacopier_"
+	logFileExt       = ".log"
+	logFileTimestamp = "20060102_150405"
+)
+
+// rotatingWriter writes to a log file in a directory and starts a new file once
+// the current one exceeds its size or age limit, removing old files beyond the
+// retention limits
+type rotatingWriter struct {
+	mu         sync.Mutex
+	dir        string
+	maxSize    int64
+	maxAge     time.Duration
+	keep       int
+	keepAge    time.Duration
+	now        func() time.Time
+	file       *os.File
+	size       int64
+	openedTime time.Time
+}
+
+// newRotatingWriter creates the log directory and opens the first log file
+func newRotatingWriter(dir string, maxSize int64, maxAge time.Duration, keep int, keepAge time.Duration) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		keep:    keep,
+		keepAge: keepAge,
+		now:     time.Now,
+	}
+
+	if err := os.MkdirAll(dir, config.LogDirPerms); err != nil {
+		return nil, errors.NewFileSystemError("create_log_dir", dir, err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.prune()
+
+	return w, nil
+}
+
+// Write writes a log record, rotating first if the current file is full or too old
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// shouldRotate reports whether writing n more bytes requires a new file
+func (w *rotatingWriter) shouldRotate(n int64) bool {
+	if w.maxSize > 0 && w.size > 0 && w.size+n > w.maxSize {
+		return true
+	}
+	return w.maxAge > 0 && w.now().Sub(w.openedTime) >= w.maxAge
+}
+
+// rotate closes the current file, opens a new one and applies retention
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return errors.NewFileSystemError("close_log", w.file.Name(), err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// open creates a new timestamped log file, adding a suffix if the name is taken
+func (w *rotatingWriter) open() error {
+	now := w.now()
+	name := logFilePrefix + now.Format(logFileTimestamp) + logFileExt
+
+	for n := 0; ; n++ {
+		candidate := name
+		if n > 0 {
+			candidate = filesystem.SuffixedFilename(name, n)
+		}
+		path := filepath.Join(w.dir, candidate)
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, config.StateFilePerms)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.NewFileSystemError("create_log", path, err)
+		}
+
+		w.file = file
+		w.size = 0
+		w.openedTime = now
+		return nil
+	}
+}
+
+// prune removes old log files beyond the retention count or age. The current
+// file is never removed. Failures are ignored, as there is nowhere to log them.
+func (w *rotatingWriter) prune() {
+	if w.keep <= 0 && w.keepAge <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return
+	}
+
+	type logFile struct {
+		path    string
+		modTime time.Time
+	}
+
+	current := filepath.Base(w.file.Name())
+	var old []logFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == current || !strings.HasPrefix(name, logFilePrefix) || !strings.HasSuffix(name, logFileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		old = append(old, logFile{path: filepath.Join(w.dir, name), modTime: info.ModTime()})
+	}
+
+	// Newest first
+	sort.Slice(old, func(i, j int) bool { return old[i].modTime.After(old[j].modTime) })
+
+	now := w.now()
+	for i, file := range old {
+		if (w.keep > 0 && i >= w.keep) || (w.keepAge > 0 && now.Sub(file.modTime) > w.keepAge) {
+			os.Remove(file.path)
+		}
+	}
+}
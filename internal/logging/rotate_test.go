@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func logFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRotatingWriterSize(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	w, err := newRotatingWriter(dir, 100, 0, 0, 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for i := 0; i < 5; i++ {
+		_, err := w.Write(line)
+		require.NoError(t, err)
+	}
+
+	// 40-byte records with a 100-byte limit fit two per file
+	names := logFiles(t, dir)
+	assert.Len(t, names, 3)
+	for _, name := range names {
+		assert.True(t, strings.HasPrefix(name, logFilePrefix), name)
+		assert.True(t, strings.HasSuffix(name, logFileExt), name)
+	}
+}
+
+func TestRotatingWriterAgeAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	w := &rotatingWriter{dir: dir, maxAge: time.Hour, keep: 2, now: func() time.Time { return now }}
+	require.NoError(t, w.open())
+	defer w.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := w.Write([]byte("record\n"))
+		require.NoError(t, err)
+
+		// Give each file a distinct age so retention keeps the newest
+		require.NoError(t, os.Chtimes(w.file.Name(), now, now))
+		now = now.Add(time.Hour)
+	}
+
+	// The current file plus the two newest old files remain
+	names := logFiles(t, dir)
+	assert.Equal(t, []string{
+		"justdatacopier_20250601_120000.log",
+		"justdatacopier_20250601_130000.log",
+		"justdatacopier_20250601_140000.log",
+	}, names)
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"justdatacopier/internal/errors"
+)
+
+// metric is a single value exposed in the Prometheus text format
+type metric interface {
+	name() string
+	help() string
+	kind() string
+	value() float64
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	metricName string
+	metricHelp string
+	val        atomic.Int64
+}
+
+// Add increases the counter by delta
+func (c *Counter) Add(delta int64) {
+	c.val.Add(delta)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() {
+	c.val.Add(1)
+}
+
+// Value returns the current counter value
+func (c *Counter) Value() int64 {
+	return c.val.Load()
+}
+
+func (c *Counter) name() string   { return c.metricName }
+func (c *Counter) help() string   { return c.metricHelp }
+func (c *Counter) kind() string   { return "counter" }
+func (c *Counter) value() float64 { return float64(c.val.Load()) }
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	metricName string
+	metricHelp string
+	bits       atomic.Uint64
+}
+
+// Set replaces the gauge value
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add changes the gauge value by delta
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) name() string   { return g.metricName }
+func (g *Gauge) help() string   { return g.metricHelp }
+func (g *Gauge) kind() string   { return "gauge" }
+func (g *Gauge) value() float64 { return g.Value() }
+
+// gaugeFunc is a gauge computed from other metrics when scraped
+type gaugeFunc struct {
+	metricName string
+	metricHelp string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) name() string   { return g.metricName }
+func (g *gaugeFunc) help() string   { return g.metricHelp }
+func (g *gaugeFunc) kind() string   { return "gauge" }
+func (g *gaugeFunc) value() float64 { return g.fn() }
+
+// registry holds all exposed metrics in output order
+var registry []metric
+
+func newCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, metricHelp: help}
+	registry = append(registry, c)
+	return c
+}
+
+func newGauge(name, help string) *Gauge {
+	g := &Gauge{metricName: name, metricHelp: help}
+	registry = append(registry, g)
+	return g
+}
+
+func newGaugeFunc(name, help string, fn func() float64) {
+	registry = append(registry, &gaugeFunc{metricName: name, metricHelp: help, fn: fn})
+}
+
+// Transfer metrics
+var (
+	BytesReceived            = newCounter("jdc_bytes_received_total", "Bytes of file data received.")
+	BytesSent                = newCounter("jdc_bytes_sent_total", "Bytes of file data sent.")
+	ActiveTransfers          = newGauge("jdc_active_transfers", "Transfers currently in progress.")
+	TransfersCompleted       = newCounter("jdc_transfers_completed_total", "Transfers that completed successfully.")
+	TransfersFailed          = newCounter("jdc_transfers_failed_total", "Transfers that failed.")
+	ChunkRetries             = newCounter("jdc_chunk_retries_total", "Chunk transfer attempts that were retried.")
+	Reconnects               = newCounter("jdc_reconnects_total", "Reconnects after a client lost its connection mid-transfer.")
+	HashVerificationFailures = newCounter("jdc_hash_verification_failures_total", "Transfers that failed hash verification.")
+	CompressionInputBytes    = newCounter("jdc_compression_input_bytes_total", "Uncompressed bytes of compressed chunks.")
+	CompressionOutputBytes   = newCounter("jdc_compression_output_bytes_total", "Compressed bytes of compressed chunks.")
+)
+
+// Network metrics
+var (
+	TransferRate        = newGauge("jdc_transfer_rate_bytes_per_second", "Smoothed delivery rate of the most recently updated transfer.")
+	BottleneckBandwidth = newGauge("jdc_bottleneck_bandwidth_bytes_per_second", "Bottleneck bandwidth estimated by the congestion controller of the most recently updated transfer.")
+	PacingRate          = newGauge("jdc_pacing_rate_bytes_per_second", "Rate at which the most recently updated transfer requests chunks.")
+	ChunksInFlight      = newGauge("jdc_chunks_in_flight", "Chunks requested but not yet received by the most recently updated transfer.")
+	NetworkRTT          = newGauge("jdc_network_rtt_seconds", "Round-trip time measured by the last network profile.")
+	NetworkJitter       = newGauge("jdc_network_jitter_seconds", "Round-trip time jitter measured by the last network profile.")
+	NetworkBandwidth    = newGauge("jdc_network_bandwidth_bytes_per_second", "Bandwidth measured or estimated by the last network profile.")
+	NetworkPacketLoss   = newGauge("jdc_network_packet_loss_ratio", "Share of data retransmitted during the last network profile.")
+)
+
+func init() {
+	newGaugeFunc("jdc_compression_ratio", "Ratio of uncompressed to compressed bytes for compressed chunks.", func() float64 {
+		output := CompressionOutputBytes.Value()
+		if output == 0 {
+			return 0
+		}
+		return float64(CompressionInputBytes.Value()) / float64(output)
+	})
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func WriteText(w io.Writer) error {
+	for _, m := range registry {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			m.name(), m.help(), m.name(), m.kind(), m.name(), formatValue(m.value())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler serving the metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteText(w); err != nil {
+			slog.Debug("Failed to write metrics", "error", err)
+		}
+	})
+}
+
+// Serve starts an HTTP listener exposing the metrics at /metrics. It returns once
+// the listener is bound and serves in the background.
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.NewNetworkError("listen", address, err)
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics listener stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Metrics endpoint ready", "address", listener.Addr().String())
+	return nil
+}
+
+// formatValue formats a metric value the way Prometheus expects
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
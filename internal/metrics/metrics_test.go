@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+	c := &Counter{}
+	c.Inc()
+	c.Add(41)
+	assert.Equal(t, int64(42), c.Value())
+
+	g := &Gauge{}
+	g.Set(2.5)
+	g.Add(-1)
+	assert.Equal(t, 1.5, g.Value())
+}
+
+func TestWriteText(t *testing.T) {
+	BytesReceived.Add(1024)
+	CompressionInputBytes.Add(300)
+	CompressionOutputBytes.Add(100)
+	NetworkRTT.Set(0.025)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteText(&buf))
+	output := buf.String()
+
+	assert.Contains(t, output, "# TYPE jdc_bytes_received_total counter\n")
+	assert.Contains(t, output, "# HELP jdc_active_transfers Transfers currently in progress.\n")
+	assert.Contains(t, output, "# TYPE jdc_network_rtt_seconds gauge\njdc_network_rtt_seconds 0.025\n")
+	assert.Contains(t, output, "jdc_compression_ratio 3\n")
+
+	// Every sample line must be "name value"
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		assert.Len(t, strings.Fields(line), 2, "line: %s", line)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "jdc_bytes_sent_total")
+}
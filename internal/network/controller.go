@@ -0,0 +1,319 @@
+package network
+
+import (
+	"log/slog"
+	"math"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/metrics"
+)
+
+// Phase is a phase of the congestion controller
+type Phase string
+
+// Controller phases, following BBR
+const (
+	PhaseFixed    Phase = "fixed"     // Adaptive control disabled: one chunk at a time with a fixed delay
+	PhaseStartup  Phase = "startup"   // Grow the sending rate until the bandwidth stops increasing
+	PhaseDrain    Phase = "drain"     // Drain the queue built up during startup
+	PhaseProbeBW  Phase = "probe_bw"  // Send at the bottleneck bandwidth, briefly probing above and below it
+	PhaseProbeRTT Phase = "probe_rtt" // Send one chunk at a time to measure the minimum RTT again
+)
+
+// Controller tuning
+const (
+	startupGain           = 2.885 // 2/ln(2), doubles the sending rate every round trip
+	drainGain             = 1 / startupGain
+	windowGain            = 2.0              // Chunks in flight as a multiple of the bandwidth-delay product
+	bandwidthWindowRounds = 10               // Round trips over which the bottleneck bandwidth is the maximum
+	minRTTWindow          = 10 * time.Second // Age after which the minimum RTT is measured again
+	probeRTTDuration      = 200 * time.Millisecond
+	fullBandwidthGrowth   = 1.25 // Startup ends once bandwidth grows less than this...
+	fullBandwidthRounds   = 3    // ...for this many round trips
+	initialChunksInFlight = 2
+	maxChunksInFlight     = 32
+)
+
+// probeBWGains cycles the pacing rate around the bottleneck bandwidth, one
+// phase per minimum RTT: probe for more bandwidth, drain the queue that built
+// up, then cruise
+var probeBWGains = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// Request records the controller's state when a chunk was requested, from
+// which the delivery rate and RTT are sampled when the chunk arrives
+type Request struct {
+	sentAt      time.Time
+	delivered   int64
+	deliveredAt time.Time
+}
+
+// Controller decides how many chunks a receiver keeps requested and how fast it
+// requests them. It estimates the bottleneck bandwidth as the maximum delivery
+// rate over recent round trips and the propagation delay as the minimum RTT,
+// then keeps about twice their product in flight, pacing requests at the
+// bottleneck bandwidth rather than sleeping between chunks. Without adaptive
+// networking it requests one chunk at a time with the configured fixed delay.
+type Controller struct {
+	chunkSize  int64
+	fixedDelay time.Duration
+	phase      Phase
+
+	delivered   int64     // Bytes delivered so far
+	deliveredAt time.Time // Time of the last delivery
+	inFlight    int       // Chunks requested but not yet delivered
+	nextSend    time.Time // Earliest time of the next request
+
+	round          int64 // Round trips counted so far
+	roundDelivered int64 // Delivered bytes at which the next round trip starts
+	roundStart     bool  // The last delivery started a new round trip
+
+	bandwidthRounds [bandwidthWindowRounds]float64 // Maximum delivery rate of recent round trips
+	btlBw           float64                        // Bottleneck bandwidth in bytes/second
+	minRTT          time.Duration
+	minRTTStamp     time.Time
+
+	fullBandwidth       float64
+	fullBandwidthRounds int
+
+	cycleIndex    int
+	cycleStart    time.Time
+	probeRTTUntil time.Time
+	probeRTTRound int64
+
+	avgRate float64 // Smoothed delivery rate
+}
+
+// NewController creates a controller for chunks of cfg.ChunkSize, adaptive if
+// cfg.AdaptiveDelay is set
+func NewController(cfg *config.Config) *Controller {
+	now := time.Now()
+	c := &Controller{
+		chunkSize:   cfg.ChunkSize,
+		fixedDelay:  cfg.ChunkDelay,
+		phase:       PhaseFixed,
+		deliveredAt: now,
+		nextSend:    now,
+	}
+	if cfg.AdaptiveDelay {
+		c.phase = PhaseStartup
+	}
+	return c
+}
+
+// Phase returns the controller's current phase
+func (c *Controller) Phase() Phase {
+	return c.phase
+}
+
+// BottleneckBandwidth returns the estimated bottleneck bandwidth in bytes/second
+func (c *Controller) BottleneckBandwidth() float64 {
+	return c.btlBw
+}
+
+// MinRTT returns the minimum RTT of a chunk, from its request to its arrival
+func (c *Controller) MinRTT() time.Duration {
+	return c.minRTT
+}
+
+// Window returns the number of chunks that may be in flight
+func (c *Controller) Window() int {
+	switch c.phase {
+	case PhaseFixed, PhaseProbeRTT:
+		return 1
+	}
+	if c.btlBw == 0 || c.minRTT == 0 {
+		return initialChunksInFlight
+	}
+
+	gain := windowGain
+	if c.phase == PhaseStartup {
+		gain = startupGain
+	}
+	chunks := int(math.Ceil(gain * c.bdp() / float64(c.chunkSize)))
+	return max(1, min(chunks, maxChunksInFlight))
+}
+
+// CanSend reports whether another chunk may be requested now
+func (c *Controller) CanSend() bool {
+	return c.inFlight < c.Window()
+}
+
+// NextSend returns the earliest time the next chunk may be requested
+func (c *Controller) NextSend() time.Time {
+	return c.nextSend
+}
+
+// PacingRate returns the rate at which chunks are requested in bytes/second, 0
+// if requests are not paced
+func (c *Controller) PacingRate() float64 {
+	return c.pacingGain() * c.btlBw
+}
+
+// OnSend records that a chunk was requested at now
+func (c *Controller) OnSend(now time.Time) Request {
+	c.inFlight++
+	if rate := c.PacingRate(); rate > 0 {
+		interval := time.Duration(float64(c.chunkSize) / rate * float64(time.Second))
+		c.nextSend = later(c.nextSend, now).Add(interval)
+	}
+	metrics.ChunksInFlight.Set(float64(c.inFlight))
+
+	return Request{sentAt: now, delivered: c.delivered, deliveredAt: c.deliveredAt}
+}
+
+// OnLost records that a requested chunk did not arrive and will be requested again
+func (c *Controller) OnLost() {
+	c.inFlight = max(0, c.inFlight-1)
+}
+
+// OnDelivered records that the chunk of req arrived at now with size bytes,
+// and updates the bandwidth and RTT estimates
+func (c *Controller) OnDelivered(req Request, size int64, now time.Time) {
+	c.inFlight = max(0, c.inFlight-1)
+	c.delivered += size
+	c.deliveredAt = now
+
+	if interval := now.Sub(req.deliveredAt); interval > 0 {
+		rate := float64(c.delivered-req.delivered) / interval.Seconds()
+		if c.avgRate == 0 {
+			c.avgRate = rate
+		} else {
+			c.avgRate = 0.7*c.avgRate + 0.3*rate
+		}
+		metrics.TransferRate.Set(c.avgRate)
+
+		if c.phase != PhaseFixed {
+			c.updateBandwidth(req, rate)
+		}
+	}
+
+	if c.phase == PhaseFixed {
+		c.nextSend = now.Add(c.fixedDelay)
+		return
+	}
+
+	c.updateMinRTT(now.Sub(req.sentAt), now)
+	c.updatePhase(now)
+
+	metrics.BottleneckBandwidth.Set(c.btlBw)
+	metrics.PacingRate.Set(c.PacingRate())
+}
+
+// updateBandwidth counts round trips and keeps the maximum delivery rate of
+// the recent ones
+func (c *Controller) updateBandwidth(req Request, rate float64) {
+	c.roundStart = false
+	if req.delivered >= c.roundDelivered {
+		c.round++
+		c.roundDelivered = c.delivered
+		c.roundStart = true
+		c.bandwidthRounds[c.round%bandwidthWindowRounds] = 0
+	}
+
+	slot := &c.bandwidthRounds[c.round%bandwidthWindowRounds]
+	*slot = max(*slot, rate)
+
+	c.btlBw = 0
+	for _, bandwidth := range c.bandwidthRounds {
+		c.btlBw = max(c.btlBw, bandwidth)
+	}
+}
+
+// updateMinRTT keeps the minimum RTT and enters the probe RTT phase once it
+// has not been seen for a while
+func (c *Controller) updateMinRTT(rtt time.Duration, now time.Time) {
+	expired := !c.minRTTStamp.IsZero() && now.Sub(c.minRTTStamp) > minRTTWindow
+	if rtt > 0 && (c.minRTT == 0 || rtt <= c.minRTT || expired) {
+		c.minRTT = rtt
+		c.minRTTStamp = now
+	}
+
+	if expired && c.phase != PhaseProbeRTT && c.phase != PhaseStartup {
+		c.probeRTTUntil = now.Add(probeRTTDuration)
+		c.probeRTTRound = c.round
+		c.setPhase(PhaseProbeRTT)
+	}
+}
+
+// updatePhase moves between the controller's phases
+func (c *Controller) updatePhase(now time.Time) {
+	switch c.phase {
+	case PhaseStartup:
+		if !c.roundStart {
+			return
+		}
+		if c.btlBw >= c.fullBandwidth*fullBandwidthGrowth {
+			c.fullBandwidth = c.btlBw
+			c.fullBandwidthRounds = 0
+			return
+		}
+		c.fullBandwidthRounds++
+		if c.fullBandwidthRounds >= fullBandwidthRounds {
+			c.setPhase(PhaseDrain)
+		}
+
+	case PhaseDrain:
+		if float64(c.inFlight*int(c.chunkSize)) <= c.bdp() {
+			c.enterProbeBW(now)
+		}
+
+	case PhaseProbeBW:
+		if now.Sub(c.cycleStart) > c.minRTT {
+			c.cycleIndex = (c.cycleIndex + 1) % len(probeBWGains)
+			c.cycleStart = now
+		}
+
+	case PhaseProbeRTT:
+		if now.After(c.probeRTTUntil) && c.round > c.probeRTTRound {
+			c.minRTTStamp = now
+			c.enterProbeBW(now)
+		}
+	}
+}
+
+// enterProbeBW starts cycling the pacing gain, beginning at the cruising rate
+func (c *Controller) enterProbeBW(now time.Time) {
+	c.cycleIndex = 2
+	c.cycleStart = now
+	c.setPhase(PhaseProbeBW)
+}
+
+// setPhase changes the phase and logs the current estimates
+func (c *Controller) setPhase(phase Phase) {
+	c.phase = phase
+	slog.Debug("Congestion controller phase changed",
+		"phase", phase,
+		"bottleneck_bandwidth_mbps", c.btlBw/(1024*1024),
+		"min_rtt", c.minRTT,
+		"window_chunks", c.Window())
+}
+
+// pacingGain returns the multiple of the bottleneck bandwidth to request at
+func (c *Controller) pacingGain() float64 {
+	switch c.phase {
+	case PhaseStartup:
+		return startupGain
+	case PhaseDrain:
+		return drainGain
+	case PhaseProbeBW:
+		return probeBWGains[c.cycleIndex]
+	case PhaseProbeRTT:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// bdp returns the bandwidth-delay product in bytes
+func (c *Controller) bdp() float64 {
+	return c.btlBw * c.minRTT.Seconds()
+}
+
+// later returns the later of two times
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
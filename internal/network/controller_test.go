@@ -0,0 +1,97 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"justdatacopier/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// simulateLink delivers chunks over a link with the given bandwidth and
+// propagation delay, requesting them as the controller allows until until and
+// then receiving those still in flight
+func simulateLink(c *Controller, bandwidth float64, delay time.Duration, start, until time.Time) time.Time {
+	type inFlight struct {
+		req     Request
+		arrival time.Time
+	}
+	var pending []inFlight
+	linkFree := start
+	now := start
+
+	for now.Before(until) || len(pending) > 0 {
+		for now.Before(until) && c.CanSend() && !c.NextSend().After(now) {
+			req := c.OnSend(now)
+			// The chunk leaves the sender once the link is free and arrives after the delay
+			sendStart := later(linkFree, now.Add(delay/2))
+			linkFree = sendStart.Add(time.Duration(float64(c.chunkSize) / bandwidth * float64(time.Second)))
+			pending = append(pending, inFlight{req, linkFree.Add(delay / 2)})
+		}
+
+		next := c.NextSend()
+		if len(pending) > 0 && (pending[0].arrival.Before(next) || !c.CanSend() || !now.Before(until)) {
+			now = later(now, pending[0].arrival)
+			c.OnDelivered(pending[0].req, c.chunkSize, now)
+			pending = pending[1:]
+		} else {
+			now = later(now, next)
+		}
+	}
+	return now
+}
+
+func TestControllerFixed(t *testing.T) {
+	c := NewController(&config.Config{ChunkSize: 1024, ChunkDelay: 10 * time.Millisecond})
+
+	assert.Equal(t, PhaseFixed, c.Phase())
+	assert.Equal(t, 1, c.Window())
+
+	now := time.Now()
+	req := c.OnSend(now)
+	assert.False(t, c.CanSend())
+
+	c.OnDelivered(req, 1024, now.Add(time.Millisecond))
+	assert.True(t, c.CanSend())
+	assert.Equal(t, now.Add(11*time.Millisecond), c.NextSend())
+}
+
+func TestControllerConverges(t *testing.T) {
+	const chunkSize = 1024 * 1024
+	const bandwidth = 100 * 1024 * 1024
+	delay := 20 * time.Millisecond
+
+	c := NewController(&config.Config{ChunkSize: chunkSize, AdaptiveDelay: true})
+	assert.Equal(t, PhaseStartup, c.Phase())
+	assert.Equal(t, initialChunksInFlight, c.Window())
+
+	start := time.Now()
+	simulateLink(c, bandwidth, delay, start, start.Add(5*time.Second))
+
+	assert.Equal(t, PhaseProbeBW, c.Phase())
+	assert.InEpsilon(t, bandwidth, c.BottleneckBandwidth(), 0.1)
+	assert.InDelta(t, float64(delay+10*time.Millisecond), float64(c.MinRTT()), float64(5*time.Millisecond))
+
+	// About twice the bandwidth-delay product of 3 chunks is kept in flight
+	assert.GreaterOrEqual(t, c.Window(), 5)
+	assert.LessOrEqual(t, c.Window(), 8)
+	assert.Greater(t, c.PacingRate(), 0.0)
+}
+
+func TestControllerProbeRTT(t *testing.T) {
+	c := NewController(&config.Config{ChunkSize: 1024 * 1024, AdaptiveDelay: true})
+
+	start := time.Now()
+	now := simulateLink(c, 50*1024*1024, 10*time.Millisecond, start, start.Add(5*time.Second))
+	assert.Equal(t, PhaseProbeBW, c.Phase())
+
+	// Once the minimum RTT is old, the controller measures it again with one chunk in flight
+	c.minRTTStamp = now.Add(-minRTTWindow - time.Second)
+	now = simulateLink(c, 50*1024*1024, 10*time.Millisecond, now, now.Add(100*time.Millisecond))
+	assert.Equal(t, PhaseProbeRTT, c.Phase())
+	assert.Equal(t, 1, c.Window())
+
+	simulateLink(c, 50*1024*1024, 10*time.Millisecond, now, now.Add(time.Second))
+	assert.Equal(t, PhaseProbeBW, c.Phase())
+}
@@ -3,9 +3,9 @@ package network
 import (
 	"bufio"
 	"context"
-	"fmt"
 	"log/slog"
 	"net"
+	"slices"
 	"time"
 
 	"justdatacopier/internal/config"
@@ -13,130 +13,61 @@ import (
 	"justdatacopier/internal/protocol"
 )
 
-// NetworkStats tracks network performance metrics to enable adaptive chunk delays
-type NetworkStats struct {
-	LastChunkTime   time.Time
-	LastChunkSize   int64
-	AvgTransferRate float64 // bytes per second
-	DelayMultiplier float64 // adjusts delay up/down
-	minDelay        time.Duration
-	maxDelay        time.Duration
-}
-
 // NetworkProfile contains information about the network environment
 type NetworkProfile struct {
-	RTT              time.Duration // Round-trip time
-	Bandwidth        int64         // Estimated bandwidth in bytes/second
-	PacketLoss       float64       // Estimated packet loss rate
-	OptimalChunkSize int64         // Calculated optimal chunk size
-}
-
-// NewNetworkStats initializes a new NetworkStats instance with values from config
-func NewNetworkStats(cfg *config.Config) *NetworkStats {
-	minDelay := config.DefaultMinDelay
-	maxDelay := config.DefaultMaxDelay
-
-	// Use config values if adaptive delay is enabled
-	if cfg.AdaptiveDelay {
-		minDelay = cfg.MinDelay
-		maxDelay = cfg.MaxDelay
-	}
-
-	return &NetworkStats{
-		LastChunkTime:   time.Now(),
-		DelayMultiplier: 1.0,
-		minDelay:        minDelay,
-		maxDelay:        maxDelay,
-	}
+	RTT               time.Duration // Round-trip time
+	Jitter            time.Duration // Mean variation between round-trip times
+	Bandwidth         int64         // Bandwidth in bytes/second
+	BandwidthMeasured bool          // Bandwidth was probed rather than estimated
+	PacketLoss        float64       // Share of data retransmitted while profiling
+	LossMeasured      bool          // PacketLoss comes from kernel counters
+	OptimalChunkSize  int64         // Calculated optimal chunk size
 }
 
-// UpdateStats updates network statistics based on the latest chunk transfer
-func (ns *NetworkStats) UpdateStats(chunkSize int64) {
-	now := time.Now()
-	duration := now.Sub(ns.LastChunkTime)
-
-	// Calculate bytes per second
-	if duration > 0 {
-		currentRate := float64(chunkSize) / duration.Seconds()
-		prevMultiplier := ns.DelayMultiplier
-
-		// Smooth the rate with exponential moving average
-		if ns.AvgTransferRate == 0 {
-			ns.AvgTransferRate = currentRate
-		} else {
-			ns.AvgTransferRate = 0.7*ns.AvgTransferRate + 0.3*currentRate
-		}
-
-		// Adjust delay multiplier based on transfer rate
-		if currentRate < 0.7*ns.AvgTransferRate {
-			ns.DelayMultiplier *= 1.2
-		} else if currentRate > 1.2*ns.AvgTransferRate {
-			ns.DelayMultiplier *= 0.8
-		}
-
-		// Keep multiplier in reasonable bounds
-		if ns.DelayMultiplier < 0.1 {
-			ns.DelayMultiplier = 0.1
-		} else if ns.DelayMultiplier > 10 {
-			ns.DelayMultiplier = 10
-		}
-
-		// Log significant changes in network conditions
-		if ns.DelayMultiplier != prevMultiplier {
-			currentRateMB := currentRate / (1024 * 1024)
-			avgRateMB := ns.AvgTransferRate / (1024 * 1024)
-
-			if ns.DelayMultiplier > prevMultiplier {
-				slog.Info("Network congestion detected",
-					"current_rate_mbps", fmt.Sprintf("%.2f", currentRateMB),
-					"avg_rate_mbps", fmt.Sprintf("%.2f", avgRateMB),
-					"delay_factor", fmt.Sprintf("%.1f", ns.DelayMultiplier))
-			} else {
-				slog.Info("Network improving",
-					"current_rate_mbps", fmt.Sprintf("%.2f", currentRateMB),
-					"avg_rate_mbps", fmt.Sprintf("%.2f", avgRateMB),
-					"delay_factor", fmt.Sprintf("%.1f", ns.DelayMultiplier))
-			}
-		}
-	}
-
-	ns.LastChunkTime = now
-	ns.LastChunkSize = chunkSize
-}
-
-// GetDelay calculates the adaptive delay based on current network conditions
-func (ns *NetworkStats) GetDelay(baseDelay time.Duration) time.Duration {
-	delay := time.Duration(float64(baseDelay) * ns.DelayMultiplier)
-
-	// Apply bounds
-	if delay < ns.minDelay {
-		delay = ns.minDelay
-	}
-	if delay > ns.maxDelay {
-		delay = ns.maxDelay
-	}
+// Profiling limits
+const (
+	minProbeSize  = 256 * 1024             // First bandwidth probe burst
+	probeDuration = 200 * time.Millisecond // Stop probing once a burst takes this long
+	minChunkSize  = 512 * 1024             // Smallest optimal chunk size
+	maxChunkSize  = 8 * 1024 * 1024        // Largest optimal chunk size
+)
 
-	return delay
-}
+// LossyThreshold is the packet loss rate above which a link is treated as lossy
+const LossyThreshold = 0.01
 
-// ProfileNetwork performs network profiling to determine optimal transfer parameters
-func ProfileNetwork(conn net.Conn) NetworkProfile {
-	profile := NetworkProfile{
+// DefaultProfile returns the profile assumed for a network that is not measured
+func DefaultProfile() NetworkProfile {
+	return NetworkProfile{
 		RTT:              100 * time.Millisecond,  // Default values
 		Bandwidth:        10 * 1024 * 1024,        // 10 MB/s default
-		PacketLoss:       0.01,                    // Default
 		OptimalChunkSize: config.DefaultChunkSize, // Default
 	}
+}
+
+// ProfileNetwork measures the connection's round-trip time and jitter with
+// pings, its bandwidth with a short bulk probe and, on Linux, its packet loss
+// from the kernel's retransmit counters, to determine optimal transfer
+// parameters. The probe is skipped when cfg limits the transfer rate, so that
+// profiling never exceeds the limit. Values that cannot be measured fall back
+// to estimates.
+func ProfileNetwork(conn net.Conn, cfg *config.Config) NetworkProfile {
+	profile := DefaultProfile()
 
 	ctx, cancel := context.WithTimeout(context.Background(), config.ProfileTimeout)
 	defer cancel()
 
-	connAddr := conn.RemoteAddr().String()
-	connType := conn.RemoteAddr().Network()
+	// Through a proxy the connection's remote address is the proxy's, so the
+	// profiling connection goes to the server through the proxy as well
+	address := conn.RemoteAddr().String()
+	if cfg.Proxy != "" {
+		address = cfg.ServerAddress
+	}
+	dialer := NewDialer(cfg)
+	dialer.Timeout = 5 * time.Second
 
-	slog.Info("Creating profiling connection", "address", connAddr)
+	slog.Info("Creating profiling connection", "address", address)
 
-	profConn, err := net.DialTimeout(connType, connAddr, 5*time.Second)
+	profConn, err := dialer.Dial(address)
 	if err != nil {
 		slog.Warn("Failed to create profiling connection, using defaults", "error", err)
 		return profile
@@ -149,96 +80,203 @@ func ProfileNetwork(conn net.Conn) NetworkProfile {
 	profReader := bufio.NewReader(profConn)
 	profWriter := bufio.NewWriter(profConn)
 
-	// Send ping packets to measure RTT
-	var totalRTT time.Duration
-	successfulPings := 0
+	// Send ping packets to measure RTT and jitter
+	rtts := measureRTT(ctx, profReader, profWriter)
+	if len(rtts) > 0 {
+		profile.RTT, profile.Jitter = summarizeRTT(rtts)
+	}
+
+	slog.Info("Network profiling complete",
+		"rtt", profile.RTT,
+		"jitter", profile.Jitter,
+		"successful_pings", len(rtts))
+
+	// Measure bandwidth with bulk data unless the transfer is rate limited
+	limited := cfg.RateLimit > 0 || !cfg.RateSchedule.Empty()
+	if !limited && len(rtts) > 0 {
+		minRTT := slices.Min(rtts)
+		if bandwidth, ok := probeBandwidth(ctx, profReader, profWriter, minRTT); ok {
+			profile.Bandwidth = bandwidth
+			profile.BandwidthMeasured = true
+		}
+	}
+	if !profile.BandwidthMeasured {
+		profile.Bandwidth = estimateBandwidth(profile.RTT)
+		if cfg.RateLimit > 0 {
+			profile.Bandwidth = min(profile.Bandwidth, cfg.RateLimit)
+		}
+	}
+
+	// The kernel counts retransmissions of the profiling traffic
+	if info, ok := readTCPInfo(profConn); ok {
+		profile.PacketLoss, profile.LossMeasured = info.lossRate()
+	}
+
+	slog.Info("Network bandwidth and loss",
+		"bandwidth_mbps", float64(profile.Bandwidth)/(1024*1024),
+		"bandwidth_measured", profile.BandwidthMeasured,
+		"packet_loss_percent", profile.PacketLoss*100,
+		"loss_measured", profile.LossMeasured)
+
+	profile.OptimalChunkSize = optimalChunkSize(profile)
+	return profile
+}
+
+// measureRTT pings the server and returns the round-trip times of successful pings
+func measureRTT(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer) []time.Duration {
+	var rtts []time.Duration
 
 	for i := 0; i < config.PingCount; i++ {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			slog.Info("Profiling timed out, using partial results")
-			goto ProfileCompletion
-		default:
-			startTime := time.Now()
-
-			// Send ping command
-			if err := protocol.SendCommand(profWriter, protocol.CmdPing); err != nil {
-				slog.Debug("Ping write failed", "error", err)
-				continue
-			}
+			break
+		}
 
-			if err := protocol.FlushWriter(profWriter); err != nil {
-				slog.Debug("Ping flush failed", "error", err)
-				continue
-			}
+		startTime := time.Now()
 
-			// Read response with deadline
-			response, err := protocol.ReadCommand(ctx, profReader)
-			if err != nil {
-				slog.Debug("Ping read response failed", "error", err)
-				continue
-			}
+		// Send ping command
+		if err := protocol.SendCommand(writer, protocol.CmdPing); err != nil {
+			slog.Debug("Ping write failed", "error", err)
+			continue
+		}
 
-			if response != protocol.CmdPong {
-				slog.Debug("Unexpected response to ping", "response", response)
-				continue
-			}
+		if err := protocol.FlushWriter(writer); err != nil {
+			slog.Debug("Ping flush failed", "error", err)
+			continue
+		}
 
-			// Calculate RTT
-			rtt := time.Since(startTime)
-			totalRTT += rtt
-			successfulPings++
+		// Read response with deadline
+		response, err := protocol.ReadCommand(ctx, reader)
+		if err != nil {
+			slog.Debug("Ping read response failed", "error", err)
+			continue
+		}
 
-			// Small delay between pings
-			time.Sleep(100 * time.Millisecond)
+		if response != protocol.CmdPong {
+			slog.Debug("Unexpected response to ping", "response", response)
+			continue
 		}
+
+		rtts = append(rtts, time.Since(startTime))
+
+		// Small delay between pings
+		time.Sleep(100 * time.Millisecond)
 	}
 
-ProfileCompletion:
-	// Calculate average RTT if we had any successful pings
-	if successfulPings > 0 {
-		profile.RTT = totalRTT / time.Duration(successfulPings)
+	return rtts
+}
+
+// summarizeRTT returns the mean round-trip time and the jitter, the mean
+// difference between consecutive round-trip times
+func summarizeRTT(rtts []time.Duration) (time.Duration, time.Duration) {
+	var total, variation time.Duration
+	for i, rtt := range rtts {
+		total += rtt
+		if i > 0 {
+			diff := rtt - rtts[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			variation += diff
+		}
 	}
 
-	slog.Info("Network profiling complete",
-		"rtt", profile.RTT,
-		"successful_pings", successfulPings)
+	mean := total / time.Duration(len(rtts))
+	if len(rtts) < 2 {
+		return mean, 0
+	}
+	return mean, variation / time.Duration(len(rtts)-1)
+}
 
-	// Estimate bandwidth based on RTT (simple heuristic)
-	switch {
-	case profile.RTT < 10*time.Millisecond:
-		profile.Bandwidth = 50 * 1024 * 1024 // 50 MB/s for very low latency
-	case profile.RTT < 50*time.Millisecond:
-		profile.Bandwidth = 20 * 1024 * 1024 // 20 MB/s for medium latency
-	case profile.RTT < 100*time.Millisecond:
-		profile.Bandwidth = 10 * 1024 * 1024 // 10 MB/s for high latency
-	default:
-		profile.Bandwidth = 5 * 1024 * 1024 // 5 MB/s for very high latency
+// probeBandwidth sends bursts of growing size until one takes at least
+// probeDuration, and returns the throughput of the largest burst. The time of
+// the server's acknowledgement, one round trip, is not counted.
+func probeBandwidth(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, minRTT time.Duration) (int64, bool) {
+	payload := make([]byte, config.LargeWriteSize)
+	var bandwidth int64
+
+	for size := int64(minProbeSize); size <= protocol.MaxProbeSize; size *= 2 {
+		if ctx.Err() != nil {
+			break
+		}
+
+		start := time.Now()
+		if err := sendProbe(writer, payload, size); err != nil {
+			slog.Debug("Bandwidth probe failed", "error", err)
+			break
+		}
+
+		response, err := protocol.ReadCommand(ctx, reader)
+		if err != nil || response != protocol.CmdPong {
+			// Servers without probe support reject it, so keep the estimate
+			slog.Debug("Bandwidth probe not acknowledged", "error", err, "response", response)
+			break
+		}
+
+		elapsed := time.Since(start)
+		transferTime := max(elapsed-minRTT, elapsed/2)
+		bandwidth = int64(float64(size) / transferTime.Seconds())
+
+		if elapsed >= probeDuration {
+			break
+		}
 	}
 
-	// Calculate optimal chunk size based on bandwidth-delay product (BDP)
-	bdp := float64(profile.Bandwidth) * profile.RTT.Seconds()
-	optimalChunkSize := int64(bdp)
+	return bandwidth, bandwidth > 0
+}
 
-	// Apply limits
-	if optimalChunkSize < 512*1024 {
-		optimalChunkSize = 512 * 1024 // Minimum 512KB
+// sendProbe sends size bytes of probe data
+func sendProbe(writer *bufio.Writer, payload []byte, size int64) error {
+	if err := protocol.SendCommand(writer, protocol.CmdProbe); err != nil {
+		return err
 	}
-	if optimalChunkSize > 8*1024*1024 {
-		optimalChunkSize = 8 * 1024 * 1024 // Maximum 8MB
+	if err := protocol.SendInt64(writer, size); err != nil {
+		return err
+	}
+
+	for sent := int64(0); sent < size; {
+		n := min(int64(len(payload)), size-sent)
+		if _, err := writer.Write(payload[:n]); err != nil {
+			return errors.NewNetworkError("probe", "", err)
+		}
+		sent += n
+	}
+
+	return protocol.FlushWriter(writer)
+}
+
+// estimateBandwidth guesses bandwidth from the round-trip time when it cannot
+// be measured
+func estimateBandwidth(rtt time.Duration) int64 {
+	switch {
+	case rtt < 10*time.Millisecond:
+		return 50 * 1024 * 1024 // 50 MB/s for very low latency
+	case rtt < 50*time.Millisecond:
+		return 20 * 1024 * 1024 // 20 MB/s for medium latency
+	case rtt < 100*time.Millisecond:
+		return 10 * 1024 * 1024 // 10 MB/s for high latency
+	default:
+		return 5 * 1024 * 1024 // 5 MB/s for very high latency
 	}
+}
 
-	profile.OptimalChunkSize = optimalChunkSize
+// optimalChunkSize derives a chunk size from the bandwidth-delay product,
+// using smaller chunks on lossy links so a failed chunk costs less to resend
+func optimalChunkSize(profile NetworkProfile) int64 {
+	bdp := float64(profile.Bandwidth) * profile.RTT.Seconds()
+	chunkSize := int64(bdp)
 
 	// Adjust for higher latency
 	if profile.RTT > 50*time.Millisecond {
-		increase := int64(float64(profile.OptimalChunkSize) * 1.5)
-		if increase <= 8*1024*1024 {
-			profile.OptimalChunkSize = increase
-		}
+		chunkSize = int64(float64(chunkSize) * 1.5)
 	}
 
-	return profile
+	if profile.PacketLoss > LossyThreshold {
+		chunkSize /= 2
+	}
+
+	// Apply limits
+	return max(minChunkSize, min(chunkSize, maxChunkSize))
 }
 
 // OptimizeTCPConnection applies TCP optimizations to a connection
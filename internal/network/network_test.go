@@ -4,74 +4,50 @@ import (
 	"testing"
 	"time"
 
-	"justdatacopier/internal/config"
-
 	"github.com/stretchr/testify/assert"
 )
 
-func TestNewNetworkStats(t *testing.T) {
-	cfg := &config.Config{
-		AdaptiveDelay: true,
-		MinDelay:      time.Millisecond,
-		MaxDelay:      100 * time.Millisecond,
-	}
-
-	stats := NewNetworkStats(cfg)
-
-	assert.NotNil(t, stats)
-	assert.Equal(t, 1.0, stats.DelayMultiplier)
-	assert.NotZero(t, stats.LastChunkTime)
-}
-
-func TestUpdateStats(t *testing.T) {
-	cfg := &config.Config{
-		AdaptiveDelay: true,
-		MinDelay:      time.Millisecond,
-		MaxDelay:      100 * time.Millisecond,
-	}
-
-	stats := NewNetworkStats(cfg)
-
-	// Wait a small amount to ensure time difference
-	time.Sleep(time.Millisecond)
+func TestSummarizeRTT(t *testing.T) {
+	rtt, jitter := summarizeRTT([]time.Duration{
+		10 * time.Millisecond,
+		14 * time.Millisecond,
+		12 * time.Millisecond,
+	})
 
-	// Test updating stats with chunk data
-	chunkSize := int64(1024)
-	stats.UpdateStats(chunkSize)
+	assert.Equal(t, 12*time.Millisecond, rtt)
+	assert.Equal(t, 3*time.Millisecond, jitter)
 
-	assert.Equal(t, chunkSize, stats.LastChunkSize)
-	assert.True(t, stats.AvgTransferRate > 0)
+	rtt, jitter = summarizeRTT([]time.Duration{5 * time.Millisecond})
+	assert.Equal(t, 5*time.Millisecond, rtt)
+	assert.Zero(t, jitter)
 }
 
-func TestGetDelay(t *testing.T) {
-	cfg := &config.Config{
-		AdaptiveDelay: true,
-		MinDelay:      time.Millisecond,
-		MaxDelay:      100 * time.Millisecond,
-		ChunkDelay:    10 * time.Millisecond,
+func TestOptimalChunkSize(t *testing.T) {
+	profile := NetworkProfile{
+		RTT:       40 * time.Millisecond,
+		Bandwidth: 100 * 1024 * 1024,
 	}
+	assert.Equal(t, int64(4194304), optimalChunkSize(profile))
 
-	stats := NewNetworkStats(cfg)
+	// Lossy links use smaller chunks
+	profile.PacketLoss = 0.05
+	assert.Equal(t, int64(2097152), optimalChunkSize(profile))
 
-	baseDelay := 10 * time.Millisecond
-	delay := stats.GetDelay(baseDelay)
-
-	// Should return a delay within the configured range
-	assert.True(t, delay >= cfg.MinDelay)
-	assert.True(t, delay <= cfg.MaxDelay)
+	// Limits apply
+	assert.Equal(t, int64(minChunkSize), optimalChunkSize(NetworkProfile{RTT: time.Millisecond, Bandwidth: 1024}))
+	assert.Equal(t, int64(maxChunkSize), optimalChunkSize(NetworkProfile{RTT: time.Second, Bandwidth: 1 << 30}))
 }
 
-func TestGetDelayWithoutAdaptive(t *testing.T) {
-	cfg := &config.Config{
-		AdaptiveDelay: false,
-		ChunkDelay:    10 * time.Millisecond,
-	}
-
-	stats := NewNetworkStats(cfg)
+func TestLossRate(t *testing.T) {
+	loss, ok := tcpInfo{BytesSent: 1000, BytesRetransmit: 20}.lossRate()
+	assert.True(t, ok)
+	assert.InDelta(t, 0.02, loss, 1e-9)
 
-	baseDelay := 10 * time.Millisecond
-	delay := stats.GetDelay(baseDelay)
+	// Segment counters are used when byte counters are unavailable
+	loss, ok = tcpInfo{SegmentsSent: 200, SegmentsRetransmit: 1}.lossRate()
+	assert.True(t, ok)
+	assert.InDelta(t, 0.005, loss, 1e-9)
 
-	// Should return the base delay when adaptive is disabled
-	assert.Equal(t, baseDelay, delay)
+	_, ok = tcpInfo{}.lossRate()
+	assert.False(t, ok)
 }
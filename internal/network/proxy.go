@@ -0,0 +1,313 @@
+package network
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"justdatacopier/internal/config"
+)
+
+// Dialer connects to servers directly or through a SOCKS5 or HTTP CONNECT proxy
+type Dialer struct {
+	Proxy   string        // Proxy URL, empty to connect directly
+	NoProxy string        // Comma-separated hosts to connect to directly
+	Timeout time.Duration // Timeout for connecting, including the proxy handshake
+
+	// ReverseListen, if set, is the address to accept the server's connections
+	// on instead of dialing, for servers started with -reverse-connect
+	ReverseListen string
+}
+
+// NewDialer returns a dialer for the proxy, reverse-connect and timeout
+// settings of cfg
+func NewDialer(cfg *config.Config) *Dialer {
+	return &Dialer{Proxy: cfg.Proxy, NoProxy: cfg.NoProxy, Timeout: cfg.Timeout, ReverseListen: cfg.ReverseListen}
+}
+
+// Dial connects to address, through the proxy unless address is excluded by
+// NoProxy. In reverse-connect mode it instead waits for the server to connect,
+// whatever address is.
+func (d *Dialer) Dial(address string) (net.Conn, error) {
+	if d.ReverseListen != "" {
+		return AcceptReverse(d.ReverseListen, d.Timeout)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Proxy == "" || noProxyMatch(d.NoProxy, host) {
+		return net.DialTimeout("tcp", address, d.Timeout)
+	}
+
+	proxy, err := config.ParseProxy(d.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", proxy.Host, d.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	// The handshake must finish within the timeout as well
+	if d.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.Timeout))
+	}
+
+	tunnel := conn
+	switch proxy.Scheme {
+	case config.ProxyHTTP:
+		tunnel, err = connectHTTP(conn, proxy, address)
+	case config.ProxySOCKS5:
+		// Resolve the server name locally, as with curl's socks5://
+		var addrs []string
+		if addrs, err = net.LookupHost(host); err == nil {
+			err = connectSOCKS5(conn, proxy, addrs[0], port)
+		}
+	default:
+		err = connectSOCKS5(conn, proxy, host, port)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Redacted(), err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// noProxyMatch reports whether host is listed in noProxy, a comma-separated
+// list of host names, domains (matching their subdomains), IP addresses and
+// CIDR ranges, or * for all hosts
+func noProxyMatch(noProxy, host string) bool {
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			name := strings.ToLower(host)
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// connectHTTP asks an HTTP proxy to open a tunnel to address with CONNECT
+func connectHTTP(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT failed: %s", resp.Status)
+	}
+
+	// Keep any data the server sent right after the proxy's response
+	if reader.Buffered() > 0 {
+		return NewBufferedConn(conn, reader), nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads from a buffered reader holding data already received
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewBufferedConn returns conn reading through reader, which holds data
+// already received from it
+func NewBufferedConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	return &bufferedConn{Conn: conn, reader: reader}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// SOCKS5 protocol values (RFC 1928 and RFC 1929)
+const (
+	socksVersion         = 5
+	socksAuthNone        = 0
+	socksAuthPassword    = 2
+	socksAuthUnavailable = 0xff
+	socksConnect         = 1
+	socksIPv4            = 1
+	socksDomain          = 3
+	socksIPv6            = 4
+)
+
+// socksReplies describe the SOCKS5 reply codes
+var socksReplies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// connectSOCKS5 asks a SOCKS5 proxy to connect to host and port
+func connectSOCKS5(conn net.Conn, proxy *url.URL, host, port string) error {
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	// Offer username/password authentication only when credentials are set
+	methods := []byte{socksAuthNone}
+	if proxy.User != nil {
+		methods = []byte{socksAuthNone, socksAuthPassword}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := authenticateSOCKS5(conn, proxy.User); err != nil {
+			return err
+		}
+	case socksAuthUnavailable:
+		return fmt.Errorf("proxy requires an authentication method that is not supported")
+	default:
+		return fmt.Errorf("proxy selected unknown authentication method %d", reply[1])
+	}
+
+	request := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, socksIPv4), ip4...)
+		} else {
+			request = append(append(request, socksIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		request = append(append(request, socksDomain, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(portNumber))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address and port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socksReplies) {
+			return fmt.Errorf("connect failed: %s", socksReplies[header[1]])
+		}
+		return fmt.Errorf("connect failed with code %d", header[1])
+	}
+
+	var addressLength int
+	switch header[3] {
+	case socksIPv4:
+		addressLength = net.IPv4len
+	case socksIPv6:
+		addressLength = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		addressLength = int(length[0])
+	default:
+		return fmt.Errorf("unknown address type %d in reply", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, addressLength+2))
+	return err
+}
+
+// authenticateSOCKS5 sends the username and password of user to a SOCKS5 proxy
+func authenticateSOCKS5(conn net.Conn, user *url.Userinfo) error {
+	if user == nil {
+		return fmt.Errorf("proxy requires a username and password")
+	}
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("proxy username or password too long")
+	}
+
+	request := []byte{1, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("proxy authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a TCP listener that serves each connection with handle
+func listen(t *testing.T, handle func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoServer echoes everything it receives
+func echoServer(t *testing.T) string {
+	return listen(t, func(conn net.Conn) { io.Copy(conn, conn) })
+}
+
+// tunnel copies data between the client and a new connection to address
+func tunnel(client net.Conn, address string) {
+	server, err := net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	go io.Copy(server, client)
+	io.Copy(client, server)
+}
+
+// socks5Proxy is a minimal SOCKS5 proxy requiring the given credentials if set
+func socks5Proxy(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		methods := make([]byte, header[1])
+		io.ReadFull(conn, methods)
+
+		if username != "" {
+			conn.Write([]byte{5, 2})
+			version := make([]byte, 2)
+			io.ReadFull(conn, version)
+			user := make([]byte, version[1])
+			io.ReadFull(conn, user)
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			pass := make([]byte, length[0])
+			io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		} else {
+			conn.Write([]byte{5, 0})
+		}
+
+		request := make([]byte, 4)
+		io.ReadFull(conn, request)
+		var host string
+		switch request[3] {
+		case socksIPv4:
+			ip := make([]byte, 4)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socksDomain:
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			name := make([]byte, length[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+
+		if host == "unreachable.test" {
+			conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		if host == "localhost" {
+			host = "127.0.0.1"
+		}
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		tunnel(conn, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	})
+}
+
+// connectProxy is a minimal HTTP CONNECT proxy requiring the given
+// Proxy-Authorization header if set
+func connectProxy(t *testing.T, authorization string) string {
+	return listen(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != authorization {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		tunnel(conn, req.Host)
+	})
+}
+
+// assertEcho checks that conn reaches the echo server
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+}
+
+func TestDialer_SOCKS5(t *testing.T) {
+	target := echoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	proxy := socks5Proxy(t, "", "")
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		dialer := &Dialer{Proxy: scheme + "://" + proxy, Timeout: time.Second}
+		conn, err := dialer.Dial(net.JoinHostPort("localhost", port))
+		require.NoError(t, err, scheme)
+		assertEcho(t, conn)
+	}
+
+	_, err := (&Dialer{Proxy: "socks5h://" + proxy, Timeout: time.Second}).Dial("unreachable.test:8000")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host unreachable")
+}
+
+func TestDialer_SOCKS5Auth(t *testing.T) {
+	target := echoServer(t)
+	proxy := socks5Proxy(t, "user", "secret")
+
+	conn, err := (&Dialer{Proxy: "socks5://user:secret@" + proxy, Timeout: time.Second}).Dial(target)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+
+	_, err = (&Dialer{Proxy: "socks5://user:wrong@" + proxy, Timeout: time.Second}).Dial(target)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "authentication failed")
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestDialer_HTTPConnect(t *testing.T) {
+	target := echoServer(t)
+
+	conn, err := (&Dialer{Proxy: connectProxy(t, ""), Timeout: time.Second}).Dial(target)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+
+	proxy := connectProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+	conn, err = (&Dialer{Proxy: "http://user:secret@" + proxy, Timeout: time.Second}).Dial(target)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+
+	_, err = (&Dialer{Proxy: "http://" + proxy, Timeout: time.Second}).Dial(target)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "407")
+}
+
+func TestDialer_NoProxy(t *testing.T) {
+	target := echoServer(t)
+
+	// The proxy address is unused, as the target is excluded
+	dialer := &Dialer{Proxy: "socks5://127.0.0.1:1", NoProxy: "127.0.0.0/8", Timeout: time.Second}
+	conn, err := dialer.Dial(target)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+}
+
+func TestNoProxyMatch(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		host    string
+		want    bool
+	}{
+		{"", "example.com", false},
+		{"*", "example.com", true},
+		{"example.com", "example.com", true},
+		{"example.com", "files.example.com", true},
+		{".example.com", "files.example.com", true},
+		{"example.com", "badexample.com", false},
+		{"localhost, files.internal:8000", "files.internal", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"192.168.1.1", "192.168.1.1", true},
+		{"::1", "::1", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, noProxyMatch(tt.noProxy, tt.host), "%q %q", tt.noProxy, tt.host)
+	}
+}
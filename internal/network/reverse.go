@@ -0,0 +1,103 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/protocol"
+)
+
+// reverseListeners holds the listeners opened for reverse connections by
+// address, so that all connections of a process are accepted from one listener
+var (
+	reverseMu        sync.Mutex
+	reverseListeners = map[string]*net.TCPListener{}
+)
+
+// reverseListener returns the listener for reverse connections on address,
+// opening it on first use. It stays open for the life of the process, so that
+// a server connecting in advance finds it for every connection of a transfer.
+func reverseListener(address string) (*net.TCPListener, error) {
+	reverseMu.Lock()
+	defer reverseMu.Unlock()
+
+	if listener, ok := reverseListeners[address]; ok {
+		return listener, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener := listener.(*net.TCPListener)
+	reverseListeners[address] = tcpListener
+
+	slog.Info("Waiting for the server to connect", "address", address)
+	return tcpListener, nil
+}
+
+// AcceptReverse waits up to timeout for a server started with -reverse-connect
+// to connect to address and greet with CmdHello. Connections without the
+// greeting, such as ones the server dropped while they waited, are skipped.
+func AcceptReverse(address string, timeout time.Duration) (net.Conn, error) {
+	listener, err := reverseListener(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		listener.SetDeadline(deadline)
+		conn, err := listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, fmt.Errorf("no server connected within %s: %w", timeout, err)
+			}
+			return nil, err
+		}
+
+		conn.SetReadDeadline(deadline)
+		hello := make([]byte, 1)
+		if _, err := conn.Read(hello); err != nil || hello[0] != protocol.CmdHello {
+			slog.Warn("Ignoring connection without server greeting", "remote_addr", conn.RemoteAddr().String(), "error", err)
+			conn.Close()
+			continue
+		}
+
+		conn.SetReadDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+// DialReverse connects to a client waiting with -reverse-listen, greets it with
+// CmdHello and waits for it to take the connection into use by sending its
+// first command, which stays unread in the returned connection
+func DialReverse(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{protocol.CmdHello}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The client may keep the connection waiting for a long time, until it
+	// needs one, so there is no deadline
+	reader := bufio.NewReader(conn)
+	if _, err := reader.Peek(1); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return NewBufferedConn(conn, reader), nil
+}
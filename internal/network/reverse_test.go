@@ -0,0 +1,61 @@
+package network
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/protocol"
+)
+
+func TestReverseConnect(t *testing.T) {
+	const address = "127.0.0.1:0"
+	listener, err := reverseListener(address)
+	require.NoError(t, err)
+	port := listener.Addr().String()
+
+	// A connection without the server greeting is skipped
+	junk, err := net.Dial("tcp", port)
+	require.NoError(t, err)
+	defer junk.Close()
+	junk.Write([]byte("x"))
+
+	// The server answers the client's first command
+	served := make(chan error, 1)
+	go func() {
+		conn, err := DialReverse(port, time.Second)
+		if err != nil {
+			served <- err
+			return
+		}
+		defer conn.Close()
+
+		cmd, err := bufio.NewReader(conn).ReadByte()
+		if err == nil && cmd == protocol.CmdPing {
+			_, err = conn.Write([]byte{protocol.CmdPong})
+		}
+		served <- err
+	}()
+
+	dialer := &Dialer{Proxy: "socks5://127.0.0.1:1", ReverseListen: address, Timeout: 2 * time.Second}
+	conn, err := dialer.Dial("unused:8000")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{protocol.CmdPing})
+	require.NoError(t, err)
+	reply := make([]byte, 1)
+	_, err = conn.Read(reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(protocol.CmdPong), reply[0])
+	require.NoError(t, <-served)
+
+	// Without a server connecting, accepting times out
+	_, err = AcceptReverse(address, 50*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no server connected")
+}
@@ -0,0 +1,22 @@
+package network
+
+// tcpInfo holds the kernel's counters for a TCP connection
+type tcpInfo struct {
+	BytesSent          uint64
+	BytesRetransmit    uint64
+	SegmentsSent       uint64
+	SegmentsRetransmit uint64
+}
+
+// lossRate returns the share of sent data that had to be retransmitted, and
+// false if the counters are not available
+func (t tcpInfo) lossRate() (float64, bool) {
+	switch {
+	case t.BytesSent > 0:
+		return float64(t.BytesRetransmit) / float64(t.BytesSent), true
+	case t.SegmentsSent > 0:
+		return float64(t.SegmentsRetransmit) / float64(t.SegmentsSent), true
+	default:
+		return 0, false
+	}
+}
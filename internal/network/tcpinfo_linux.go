@@ -0,0 +1,38 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo reads the kernel's TCP_INFO counters for conn
+func readTCPInfo(conn net.Conn) (tcpInfo, bool) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return tcpInfo{}, false
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return tcpInfo{}, false
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockErr != nil {
+		return tcpInfo{}, false
+	}
+
+	// Older kernels leave the byte counters and data segment count at zero
+	return tcpInfo{
+		BytesSent:          info.Bytes_sent,
+		BytesRetransmit:    info.Bytes_retrans,
+		SegmentsSent:       uint64(info.Data_segs_out),
+		SegmentsRetransmit: uint64(info.Total_retrans),
+	}, true
+}
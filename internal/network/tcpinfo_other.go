@@ -0,0 +1,10 @@
+//go:build !linux
+
+package network
+
+import "net"
+
+// readTCPInfo is only supported on Linux
+func readTCPInfo(conn net.Conn) (tcpInfo, bool) {
+	return tcpInfo{}, false
+}
@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/events"
+	"justdatacopier/internal/progress"
+)
+
+// Header names used for webhook deliveries
+const (
+	SignatureHeader = "X-JDC-Signature"
+	EventHeader     = "X-JDC-Event"
+)
+
+// progressMilestones are the completion percentages reported as progress events
+var progressMilestones = []float64{25, 50, 75}
+
+// Notifier delivers transfer events to a webhook endpoint. A nil Notifier is
+// valid and discards all events, so callers need not check whether webhooks
+// are configured.
+type Notifier struct {
+	url     string
+	secret  []byte
+	retries int
+	client  *http.Client
+	backoff time.Duration
+	wg      sync.WaitGroup
+}
+
+// New creates a notifier from the configuration, or returns nil if no webhook is configured
+func New(cfg *config.Config) *Notifier {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+
+	return &Notifier{
+		url:     cfg.WebhookURL,
+		secret:  []byte(cfg.WebhookSecret),
+		retries: cfg.WebhookRetries,
+		client:  &http.Client{Timeout: config.WebhookTimeout},
+		backoff: time.Second,
+	}
+}
+
+// Send delivers an event asynchronously, retrying failed deliveries with backoff
+func (n *Notifier) Send(event events.Event) {
+	if n == nil {
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.deliver(event); err != nil {
+			slog.Warn("Webhook delivery failed", "event", event.Type, "error", err)
+		}
+	}()
+}
+
+// Close waits for pending deliveries to finish, up to the flush timeout
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(config.WebhookFlushTimeout):
+		slog.Warn("Timed out waiting for webhook deliveries")
+	}
+}
+
+// WatchProgress sends a progress event each time the transfer passes a milestone
+// percentage. The returned function stops watching.
+func (n *Notifier) WatchProgress(stats *progress.Stats, template events.Event) func() {
+	if n == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		// Skip milestones already passed, e.g. when resuming
+		next := 0
+		for next < len(progressMilestones) && stats.Percent() >= progressMilestones[next] {
+			next++
+		}
+
+		for next < len(progressMilestones) {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				percent := stats.Percent()
+				if percent < progressMilestones[next] {
+					continue
+				}
+				for next < len(progressMilestones) && percent >= progressMilestones[next] {
+					next++
+				}
+
+				event := template
+				event.Type = events.TransferProgress
+				event.Timestamp = time.Now()
+				event.BytesTransferred = stats.GetTransferred()
+				event.Percent = percent
+				event.DurationSeconds = time.Since(stats.StartTime).Seconds()
+				n.Send(event)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// deliver posts an event, retrying on failure
+func (n *Notifier) deliver(event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			backoff := n.backoff * time.Duration(1<<(attempt-1))
+			time.Sleep(min(backoff, 30*time.Second))
+			slog.Debug("Retrying webhook delivery", "event", event.Type, "attempt", attempt+1)
+		}
+
+		if lastErr = n.post(event.Type, body); lastErr == nil {
+			return nil
+		}
+	}
+
+	return lastErr
+}
+
+// post performs a single signed webhook request
+func (n *Notifier) post(eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithoutURL(t *testing.T) {
+	notifier := New(&config.Config{})
+	assert.Nil(t, notifier)
+
+	// A nil notifier discards events
+	notifier.Send(events.Event{Type: events.TransferComplete})
+	notifier.WatchProgress(nil, events.Event{})()
+	notifier.Close()
+}
+
+func TestSendSignsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var received events.Event
+	var signature, eventHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			// Fail the first delivery to exercise the retry path
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		signature = r.Header.Get(SignatureHeader)
+		eventHeader = r.Header.Get(EventHeader)
+
+		// The signature must cover the exact body that was sent
+		if signature != "sha256="+Sign([]byte("s3cret"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := New(&config.Config{
+		WebhookURL:     server.URL,
+		WebhookSecret:  "s3cret",
+		WebhookRetries: 2,
+	})
+	require.NotNil(t, notifier)
+	notifier.backoff = 10 * time.Millisecond
+
+	notifier.Send(events.Event{
+		Type:          events.TransferFailed,
+		Filename:      "backup.bak",
+		ErrorCategory: "network",
+	})
+	notifier.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, events.TransferFailed, eventHeader)
+	assert.Equal(t, "backup.bak", received.Filename)
+	assert.Equal(t, "network", received.ErrorCategory)
+	assert.False(t, received.Timestamp.IsZero())
+}
+
+func TestSign(t *testing.T) {
+	// Known HMAC-SHA256 test vector (RFC 4231 test case 2)
+	assert.Equal(t,
+		"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		Sign([]byte("Jefe"), []byte("what do ya want for nothing?")))
+}
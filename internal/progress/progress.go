@@ -1,14 +1,28 @@
 package progress
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
 	"justdatacopier/internal/logging"
 )
 
+// Progress event types written to the JSON stream
+const (
+	EventProgress = "progress"
+	EventSummary  = "summary"
+)
+
 // Stats holds transfer statistics
 type Stats struct {
 	TotalBytes       int64
@@ -16,14 +30,48 @@ type Stats struct {
 	StartTime        time.Time
 	FileSize         int64
 	Filename         string
+	TransferID       string
+	TotalChunks      int64
+	ResumedChunks    int64
+	ResumedBytes     int64
+	CompletedChunks  atomic.Int64
+	Retries          atomic.Int64
+
+	// Bytes of compressed chunks before and after compression
+	CompressionInputBytes  atomic.Int64
+	CompressionOutputBytes atomic.Int64
+
+	retriesMu    sync.Mutex
+	chunkRetries map[int64]int64
+}
+
+// Event is a single line of the JSON progress stream
+type Event struct {
+	Type             string    `json:"type"`
+	Timestamp        time.Time `json:"timestamp"`
+	TransferID       string    `json:"transfer_id,omitempty"`
+	Filename         string    `json:"filename"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	TotalBytes       int64     `json:"total_bytes"`
+	Percent          float64   `json:"percent"`
+	RateBytesPerSec  float64   `json:"rate_bytes_per_sec"`
+	ETASeconds       *float64  `json:"eta_seconds,omitempty"`
+	ElapsedSeconds   float64   `json:"elapsed_seconds"`
+	ChunksCompleted  int64     `json:"chunks_completed"`
+	ChunksTotal      int64     `json:"chunks_total"`
+	Retries          int64     `json:"retries"`
 }
 
+// jsonMu serializes JSON progress lines from concurrent reporters sharing an output
+var jsonMu sync.Mutex
+
 // Reporter handles progress reporting
 type Reporter struct {
 	stats       *Stats
 	ticker      *time.Ticker
 	done        chan struct{}
 	showConsole bool
+	jsonOut     io.Writer
 }
 
 // NewReporter creates a new progress reporter
@@ -36,6 +84,33 @@ func NewReporter(stats *Stats, showConsole bool) *Reporter {
 	}
 }
 
+// SetJSONOutput enables the newline-delimited JSON progress stream. It must be
+// called before Start.
+func (r *Reporter) SetJSONOutput(w io.Writer) {
+	r.jsonOut = w
+}
+
+// OpenJSONOutput resolves a progress JSON target from the configuration to a writer
+func OpenJSONOutput(target string) (io.Writer, error) {
+	switch target {
+	case config.ProgressStdout:
+		return os.Stdout, nil
+	case config.ProgressStderr:
+		return os.Stderr, nil
+	}
+
+	fd, err := strconv.Atoi(target)
+	if err != nil || fd <= 0 {
+		return nil, errors.NewValidationError("progress_json", target, "must be stdout, stderr or a file descriptor number")
+	}
+
+	file := os.NewFile(uintptr(fd), "progress-json")
+	if _, err := file.Stat(); err != nil {
+		return nil, errors.NewFileSystemError("open_progress_output", target, err)
+	}
+	return file, nil
+}
+
 // Start begins progress reporting
 func (r *Reporter) Start() {
 	go r.reportLoop()
@@ -45,6 +120,14 @@ func (r *Reporter) Start() {
 func (r *Reporter) Stop() {
 	r.ticker.Stop()
 	close(r.done)
+	if r.jsonOut != nil {
+		elapsed := time.Since(r.stats.StartTime).Seconds()
+		var rate float64
+		if elapsed > 0 {
+			rate = float64(r.stats.GetTransferred()) / elapsed
+		}
+		r.writeJSON(r.stats.event(EventSummary, rate, nil))
+	}
 	if r.showConsole {
 		fmt.Println() // Print newline after progress bar
 	}
@@ -97,9 +180,11 @@ func (r *Reporter) updateProgress(lastTransferred *int64, lastUpdateTime *time.T
 
 	// Calculate ETA
 	var eta string
+	var etaSeconds *float64
 	if avgSpeed > 0.1 { // Only show ETA if speed is reasonable
 		remainingBytes := r.stats.TotalBytes - transferred
 		remainingTime := float64(remainingBytes) / (avgSpeed * 1024 * 1024)
+		etaSeconds = &remainingTime
 
 		switch {
 		case remainingTime < 60:
@@ -115,7 +200,8 @@ func (r *Reporter) updateProgress(lastTransferred *int64, lastUpdateTime *time.T
 
 	// Log progress periodically (every 10 seconds)
 	if int(now.Sub(r.stats.StartTime).Seconds())%10 == 0 {
-		logging.LogTransferProgress(r.stats.Filename, transferred, r.stats.TotalBytes, avgSpeed)
+		ctx := logging.WithTransferID(context.Background(), r.stats.TransferID)
+		logging.LogTransferProgress(ctx, r.stats.Filename, transferred, r.stats.TotalBytes, avgSpeed)
 	}
 
 	// Show console progress if enabled
@@ -123,6 +209,11 @@ func (r *Reporter) updateProgress(lastTransferred *int64, lastUpdateTime *time.T
 		r.showConsoleProgress(percent, transferred, avgSpeed, eta)
 	}
 
+	// Emit machine-readable progress if enabled
+	if r.jsonOut != nil {
+		r.writeJSON(r.stats.event(EventProgress, avgSpeed*1024*1024, etaSeconds))
+	}
+
 	// Update for next iteration
 	*lastTransferred = transferred
 	*lastUpdateTime = now
@@ -145,6 +236,18 @@ func (r *Reporter) showConsoleProgress(percent float64, transferred int64, avgSp
 		eta)
 }
 
+// writeJSON writes one progress event as a JSON line
+func (r *Reporter) writeJSON(event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	r.jsonOut.Write(append(line, '\n'))
+}
+
 // GetCurrentStats returns current transfer statistics
 func (r *Reporter) GetCurrentStats() (transferred int64, percent float64, elapsed time.Duration) {
 	transferred = r.stats.TransferredBytes.Load()
@@ -167,3 +270,65 @@ func (s *Stats) GetTransferred() int64 {
 func (s *Stats) SetTransferred(bytes int64) {
 	s.TransferredBytes.Store(bytes)
 }
+
+// Percent returns the completed percentage of the transfer
+func (s *Stats) Percent() float64 {
+	if s.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(s.GetTransferred()) / float64(s.TotalBytes) * 100
+}
+
+// ChunkCompleted records a chunk that was transferred successfully
+func (s *Stats) ChunkCompleted() {
+	s.CompletedChunks.Add(1)
+}
+
+// AddRetry records a retried transfer attempt of the given chunk
+func (s *Stats) AddRetry(chunk int64) {
+	s.Retries.Add(1)
+
+	s.retriesMu.Lock()
+	defer s.retriesMu.Unlock()
+	if s.chunkRetries == nil {
+		s.chunkRetries = make(map[int64]int64)
+	}
+	s.chunkRetries[chunk]++
+}
+
+// ChunkRetries returns the number of retries of each chunk that needed any
+func (s *Stats) ChunkRetries() map[int64]int64 {
+	s.retriesMu.Lock()
+	defer s.retriesMu.Unlock()
+
+	retries := make(map[int64]int64, len(s.chunkRetries))
+	for chunk, count := range s.chunkRetries {
+		retries[chunk] = count
+	}
+	return retries
+}
+
+// AddCompression records the sizes of a chunk before and after compression
+func (s *Stats) AddCompression(input, output int64) {
+	s.CompressionInputBytes.Add(input)
+	s.CompressionOutputBytes.Add(output)
+}
+
+// event builds a progress event from the current statistics
+func (s *Stats) event(eventType string, rate float64, etaSeconds *float64) Event {
+	return Event{
+		Type:             eventType,
+		Timestamp:        time.Now(),
+		TransferID:       s.TransferID,
+		Filename:         s.Filename,
+		BytesTransferred: s.GetTransferred(),
+		TotalBytes:       s.TotalBytes,
+		Percent:          s.Percent(),
+		RateBytesPerSec:  rate,
+		ETASeconds:       etaSeconds,
+		ElapsedSeconds:   time.Since(s.StartTime).Seconds(),
+		ChunksCompleted:  s.CompletedChunks.Load(),
+		ChunksTotal:      s.TotalChunks,
+		Retries:          s.Retries.Load(),
+	}
+}
@@ -0,0 +1,60 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporterJSONOutput(t *testing.T) {
+	stats := &Stats{
+		TotalBytes:  1000,
+		StartTime:   time.Now().Add(-2 * time.Second),
+		FileSize:    1000,
+		Filename:    "data.bin",
+		TotalChunks: 4,
+	}
+	stats.SetTransferred(500)
+	stats.ChunkCompleted()
+	stats.ChunkCompleted()
+	stats.AddRetry(1)
+
+	var buf bytes.Buffer
+	reporter := NewReporter(stats, false)
+	reporter.SetJSONOutput(&buf)
+	reporter.Start()
+	reporter.Stop()
+
+	// Stop always emits a final summary line
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.NotEmpty(t, lines)
+
+	var event Event
+	require.NoError(t, json.Unmarshal(lines[len(lines)-1], &event))
+	assert.Equal(t, EventSummary, event.Type)
+	assert.Equal(t, "data.bin", event.Filename)
+	assert.Equal(t, int64(500), event.BytesTransferred)
+	assert.Equal(t, int64(1000), event.TotalBytes)
+	assert.InDelta(t, 50.0, event.Percent, 0.001)
+	assert.Equal(t, int64(2), event.ChunksCompleted)
+	assert.Equal(t, int64(4), event.ChunksTotal)
+	assert.Equal(t, int64(1), event.Retries)
+	assert.Greater(t, event.RateBytesPerSec, 0.0)
+	assert.Nil(t, event.ETASeconds)
+	assert.Equal(t, map[int64]int64{1: 1}, stats.ChunkRetries())
+}
+
+func TestOpenJSONOutput(t *testing.T) {
+	_, err := OpenJSONOutput("stdout")
+	require.NoError(t, err)
+
+	_, err = OpenJSONOutput("not-a-target")
+	assert.Error(t, err)
+
+	_, err = OpenJSONOutput("987654")
+	assert.Error(t, err)
+}
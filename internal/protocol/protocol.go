@@ -3,16 +3,22 @@ package protocol
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"justdatacopier/internal/errors"
 )
 
-// Protocol version for future compatibility
+// Protocol versions. A sender announces its version with CmdVersion before
+// CmdInit; senders that do not are treated as LegacyProtocolVersion.
 const (
-	ProtocolVersion = 1
+	LegacyProtocolVersion     = 1
+	TransferIDProtocolVersion = 2 // CmdInit carries the transfer ID
+	ChunkSizeProtocolVersion  = 3 // CmdInit carries the chunk size as well
+	ProtocolVersion           = ChunkSizeProtocolVersion
 )
 
 // Command operation codes
@@ -29,6 +35,10 @@ const (
 	CmdVersion   = 10 // Protocol version negotiation
 	CmdResume    = 11 // Resume information
 	CmdResumeAck = 12 // Resume acknowledgment
+	CmdGet       = 13 // Request a file from the server
+	CmdVerify    = 14 // Request the hash of a file on the server
+	CmdProbe     = 15 // Bulk data for bandwidth profiling
+	CmdHello     = 16 // Greeting of a server that connected to a client in reverse-connect mode
 )
 
 // Hash algorithm types
@@ -45,6 +55,35 @@ const (
 	LargeFileSizeThreshold = 50 * 1024 * 1024 * 1024 // 50GB in bytes
 )
 
+// MaxTransferIDLength bounds the transfer ID accepted from a peer
+const MaxTransferIDLength = 64
+
+// MaxProbeSize bounds a single bandwidth probe burst
+const MaxProbeSize = 32 * 1024 * 1024
+
+// NewTransferID returns a random identifier for a transfer
+func NewTransferID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to the clock
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ValidTransferID reports whether id is an acceptable transfer ID from a peer
+func ValidTransferID(id string) bool {
+	if id == "" || len(id) > MaxTransferIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // Message represents a protocol message
 type Message struct {
 	Command byte
@@ -225,6 +264,26 @@ func ReadResumeInfo(ctx context.Context, reader *bufio.Reader) (*ResumeInfo, err
 	return resumeInfo, nil
 }
 
+// SendVersion announces the sender's protocol version
+func SendVersion(writer *bufio.Writer) error {
+	if err := SendCommand(writer, CmdVersion); err != nil {
+		return err
+	}
+	return SendInt64(writer, ProtocolVersion)
+}
+
+// ReadVersion reads the protocol version announced with CmdVersion
+func ReadVersion(ctx context.Context, reader *bufio.Reader) (int64, error) {
+	version, err := ReadInt64(ctx, reader)
+	if err != nil {
+		return 0, err
+	}
+	if version < LegacyProtocolVersion {
+		return 0, errors.NewProtocolError("read_version", "invalid protocol version", nil)
+	}
+	return version, nil
+}
+
 // SendResumeAck sends resume acknowledgment
 func SendResumeAck(writer *bufio.Writer, accepted bool) error {
 	if err := SendCommand(writer, CmdResumeAck); err != nil {
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Burst sizing: a limiter may send this much time's worth of bytes at once after
+// being idle, but never less than minBurst
+const (
+	burstDuration = 100 * time.Millisecond
+	minBurst      = 64 * 1024
+)
+
+// Limiter is a token bucket limiting a byte rate. Bytes taken from a limiter are
+// also taken from its parent, so per-transfer limits can share a server-wide
+// limit. A nil Limiter, or one with a rate of 0, does not limit.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64 // Current bytes per second, 0 for unlimited
+	baseRate  int64   // Rate outside the schedule's windows
+	schedule  *Schedule
+	nextCheck time.Time // When the schedule is consulted again
+	tokens    float64
+	last      time.Time
+	parent    *Limiter
+}
+
+// New returns a limiter allowing bytesPerSec bytes per second, 0 for unlimited,
+// within the limit of parent, which may be nil
+func New(bytesPerSec int64, parent *Limiter) *Limiter {
+	l := &Limiter{parent: parent, last: time.Now()}
+	l.SetRate(bytesPerSec)
+	l.tokens = l.burst()
+	return l
+}
+
+// SetRate changes the rate limit, 0 for unlimited. With a schedule, it is the
+// rate outside the schedule's windows. Transfers already waiting keep their
+// current wait.
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.baseRate = max(bytesPerSec, 0)
+	l.nextCheck = time.Time{}
+	l.update(time.Now())
+}
+
+// SetSchedule makes the limiter follow a weekly schedule, checked every minute.
+// A nil or empty schedule leaves the rate given to New or SetRate in effect.
+func (l *Limiter) SetSchedule(schedule *Schedule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.schedule = schedule
+	l.nextCheck = time.Time{}
+	l.update(time.Now())
+}
+
+// Rate returns the rate limit in bytes per second, 0 for unlimited
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.update(time.Now())
+	return int64(l.rate)
+}
+
+// update applies the scheduled rate when it is due to be checked
+func (l *Limiter) update(now time.Time) {
+	if now.Before(l.nextCheck) {
+		return
+	}
+
+	rate := l.baseRate
+	if l.schedule.Empty() {
+		l.nextCheck = now.Add(24 * time.Hour)
+	} else {
+		rate = l.schedule.RateAt(now, l.baseRate)
+		l.nextCheck = now.Truncate(time.Minute).Add(time.Minute)
+	}
+
+	l.refill(now)
+	if l.schedule != nil && float64(rate) != l.rate {
+		slog.Info("Bandwidth limit changed by schedule", "rate", FormatRate(rate))
+	}
+	l.rate = float64(rate)
+	l.tokens = min(l.tokens, l.burst())
+}
+
+// WaitN blocks until n bytes may be sent or received under this limiter and its
+// parents, or until ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for limiter := l; limiter != nil; limiter = limiter.parent {
+		if err := limiter.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait takes n tokens from this limiter alone, sleeping off any shortfall.
+// Taking more tokens than are available leaves the bucket in debt, so a single
+// large read or write is paced like several small ones.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.update(now)
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(now)
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refill adds the tokens accumulated since the last update
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst())
+	}
+	l.last = now
+}
+
+// burst returns the bucket size for the current rate
+func (l *Limiter) burst() float64 {
+	return max(l.rate*burstDuration.Seconds(), minBurst)
+}
+
+// Rate units. Bit rates use decimal prefixes as network links do; byte rates use
+// binary prefixes as the rest of jdc's sizes do.
+var rateUnits = map[string]float64{
+	"":     1,
+	"b":    1,
+	"kb":   1024,
+	"mb":   1024 * 1024,
+	"gb":   1024 * 1024 * 1024,
+	"bit":  1.0 / 8,
+	"kbit": 1e3 / 8,
+	"mbit": 1e6 / 8,
+	"gbit": 1e9 / 8,
+	"kbps": 1e3 / 8,
+	"mbps": 1e6 / 8,
+	"gbps": 1e9 / 8,
+}
+
+// ParseRate parses a rate such as "50mbit", "10MB/s" or "65536" (bytes per
+// second) into bytes per second. "0" and "unlimited" mean unlimited.
+func ParseRate(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	if value == "unlimited" {
+		return 0, nil
+	}
+
+	split := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := value, ""
+	if split >= 0 {
+		number, unit = value[:split], strings.TrimSpace(value[split:])
+	}
+
+	multiplier, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown rate unit %q, use b, kb, mb, gb, kbit, mbit or gbit", unit)
+	}
+
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	return int64(amount * multiplier), nil
+}
+
+// FormatRate formats a rate in bytes per second for display, e.g. "50.0 Mbit/s"
+func FormatRate(bytesPerSec int64) string {
+	bits := float64(bytesPerSec) * 8
+	switch {
+	case bytesPerSec <= 0:
+		return "unlimited"
+	case bits >= 1e9:
+		return fmt.Sprintf("%.1f Gbit/s", bits/1e9)
+	case bits >= 1e6:
+		return fmt.Sprintf("%.1f Mbit/s", bits/1e6)
+	default:
+		return fmt.Sprintf("%.1f kbit/s", bits/1e3)
+	}
+}
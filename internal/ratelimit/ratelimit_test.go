@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"65536", 65536},
+		{"512kb", 512 * 1024},
+		{"10MB/s", 10 * 1024 * 1024},
+		{"1gb", 1024 * 1024 * 1024},
+		{"50mbit", 6250000},
+		{"50 Mbit/s", 6250000},
+		{"100mbps", 12500000},
+		{"1.5gbit", 187500000},
+	}
+
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, rate, tt.input)
+	}
+
+	for _, input := range []string{"", "fast", "10parsecs", "-5mb"} {
+		_, err := ParseRate(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	assert.Equal(t, "unlimited", FormatRate(0))
+	assert.Equal(t, "50.0 Mbit/s", FormatRate(6250000))
+	assert.Equal(t, "1.5 Gbit/s", FormatRate(187500000))
+	assert.Equal(t, "512.0 kbit/s", FormatRate(64000))
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	var nilLimiter *Limiter
+	require.NoError(t, nilLimiter.WaitN(context.Background(), 1<<30))
+	assert.Equal(t, int64(0), nilLimiter.Rate())
+
+	start := time.Now()
+	require.NoError(t, New(0, nil).WaitN(context.Background(), 1<<30))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestLimiter_PacesToRate(t *testing.T) {
+	// The initial burst is free; the next 100KB at 1MB/s take about 100ms
+	limiter := New(1024*1024, nil)
+	require.NoError(t, limiter.WaitN(context.Background(), int(limiter.burst())))
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, limiter.WaitN(context.Background(), 10*1024))
+	}
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 80*time.Millisecond)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+}
+
+func TestLimiter_Parent(t *testing.T) {
+	// A generous transfer limit is still bound by a slower shared limit
+	parent := New(512*1024, nil)
+	limiter := New(100*1024*1024, parent)
+	require.NoError(t, limiter.WaitN(context.Background(), minBurst))
+
+	start := time.Now()
+	require.NoError(t, limiter.WaitN(context.Background(), 51*1024))
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func TestLimiter_SetRateAndCancel(t *testing.T) {
+	limiter := New(1024, nil)
+	assert.Equal(t, int64(1024), limiter.Rate())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.NoError(t, limiter.WaitN(ctx, minBurst))
+	assert.ErrorIs(t, limiter.WaitN(ctx, 1024*1024), context.DeadlineExceeded)
+
+	limiter.SetRate(0)
+	assert.Equal(t, int64(0), limiter.Rate())
+	require.NoError(t, limiter.WaitN(context.Background(), 1<<30))
+}
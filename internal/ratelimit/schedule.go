@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minutesPerDay is the end of a day in a schedule window, written 24:00
+const minutesPerDay = 24 * 60
+
+// weekdays maps day names in schedules to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule is a weekly bandwidth schedule such as
+//
+//	mon-fri 08:00-18:00 10mbit; sat,sun 10:00-16:00 50mbit
+//
+// Entries are separated by semicolons and the first one covering the current
+// local time sets the rate; outside all of them the limiter's own rate applies.
+// Days are names, ranges such as mon-fri, comma-separated lists or * for every
+// day. A window ending before it starts, such as 22:00-06:00, runs into the
+// next day. The zero Schedule is empty.
+type Schedule struct {
+	spec    string
+	entries []scheduleEntry
+}
+
+// scheduleEntry is one window of a schedule
+type scheduleEntry struct {
+	days       [7]bool
+	start, end int // Minutes since midnight
+	rate       int64
+}
+
+// ParseSchedule parses a schedule. An empty spec gives an empty schedule.
+func ParseSchedule(spec string) (*Schedule, error) {
+	schedule := &Schedule{}
+	if err := schedule.Set(spec); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// Set parses spec into the schedule, implementing flag.Value
+func (s *Schedule) Set(spec string) error {
+	var entries []scheduleEntry
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		entry, err := parseScheduleEntry(part)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	s.spec = strings.TrimSpace(spec)
+	s.entries = entries
+	return nil
+}
+
+// String returns the schedule as given, implementing flag.Value
+func (s *Schedule) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.spec
+}
+
+// Empty reports whether the schedule has no windows
+func (s *Schedule) Empty() bool {
+	return s == nil || len(s.entries) == 0
+}
+
+// RateAt returns the scheduled rate at t in t's location, or fallback outside
+// all windows
+func (s *Schedule) RateAt(t time.Time, fallback int64) int64 {
+	if s.Empty() {
+		return fallback
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, entry := range s.entries {
+		if entry.start < entry.end {
+			if entry.days[today] && minute >= entry.start && minute < entry.end {
+				return entry.rate
+			}
+			continue
+		}
+		// Overnight window: the evening of a listed day or the following morning
+		if (entry.days[today] && minute >= entry.start) || (entry.days[yesterday] && minute < entry.end) {
+			return entry.rate
+		}
+	}
+	return fallback
+}
+
+// parseScheduleEntry parses "DAYS HH:MM-HH:MM RATE"
+func parseScheduleEntry(text string) (scheduleEntry, error) {
+	var entry scheduleEntry
+
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return entry, fmt.Errorf("schedule entry %q must be DAYS HH:MM-HH:MM RATE", strings.TrimSpace(text))
+	}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return entry, err
+	}
+	entry.days = days
+
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return entry, fmt.Errorf("schedule window %q must be HH:MM-HH:MM", fields[1])
+	}
+	if entry.start, err = parseClock(from); err != nil {
+		return entry, err
+	}
+	if entry.end, err = parseClock(to); err != nil {
+		return entry, err
+	}
+	if entry.start == entry.end {
+		return entry, fmt.Errorf("schedule window %q is empty", fields[1])
+	}
+
+	if entry.rate, err = ParseRate(fields[2]); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// parseDays parses "*", "mon", "mon-fri" or "sat,sun"
+func parseDays(text string) ([7]bool, error) {
+	var days [7]bool
+
+	if text == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, item := range strings.Split(strings.ToLower(text), ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q in schedule", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return days, fmt.Errorf("unknown day %q in schedule", last)
+			}
+		}
+		// Ranges may wrap around the week, e.g. fri-mon
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight, allowing 24:00
+func parseClock(text string) (int, error) {
+	hours, minutes, ok := strings.Cut(text, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q in schedule, use HH:MM", text)
+	}
+	return h*60 + m, nil
+}
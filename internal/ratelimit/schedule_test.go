@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// at returns a local time in the week of Monday 2024-01-01
+func at(weekday time.Weekday, hour, minute int) time.Time {
+	return time.Date(2024, 1, 1+(int(weekday)+6)%7, hour, minute, 0, 0, time.Local)
+}
+
+func TestSchedule_RateAt(t *testing.T) {
+	schedule, err := ParseSchedule("mon-fri 08:00-18:00 10mbit; sat,sun 10:00-16:00 50mbit; * 22:00-06:00 unlimited")
+	require.NoError(t, err)
+	const fallback = 1000
+
+	tests := []struct {
+		time     time.Time
+		expected int64
+	}{
+		{at(time.Monday, 8, 0), 1250000},
+		{at(time.Friday, 17, 59), 1250000},
+		{at(time.Friday, 18, 0), fallback},
+		{at(time.Saturday, 12, 0), 6250000},
+		{at(time.Sunday, 9, 59), fallback},
+		{at(time.Wednesday, 23, 0), 0},
+		{at(time.Thursday, 5, 59), 0},
+		{at(time.Thursday, 6, 0), fallback},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, schedule.RateAt(tt.time, fallback), tt.time.String())
+	}
+}
+
+func TestSchedule_WrappingDayRange(t *testing.T) {
+	schedule, err := ParseSchedule("fri-mon 00:00-24:00 1mb")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1024*1024), schedule.RateAt(at(time.Sunday, 12, 0), 0))
+	assert.Equal(t, int64(1024*1024), schedule.RateAt(at(time.Monday, 23, 59), 0))
+	assert.Equal(t, int64(0), schedule.RateAt(at(time.Tuesday, 12, 0), 0))
+}
+
+func TestSchedule_Empty(t *testing.T) {
+	var nilSchedule *Schedule
+	assert.True(t, nilSchedule.Empty())
+	assert.Equal(t, int64(5), nilSchedule.RateAt(time.Now(), 5))
+
+	schedule, err := ParseSchedule("")
+	require.NoError(t, err)
+	assert.True(t, schedule.Empty())
+	assert.Equal(t, "", schedule.String())
+}
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"mon-fri 08:00-18:00",
+		"someday 08:00-18:00 1mbit",
+		"mon 08:00 1mbit",
+		"mon 25:00-26:00 1mbit",
+		"mon 08:60-09:00 1mbit",
+		"mon 08:00-08:00 1mbit",
+		"mon 08:00-09:00 fast",
+	} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLimiter_FollowsSchedule(t *testing.T) {
+	now := time.Now()
+	window := now.Format("15:04") + "-" + now.Add(2*time.Minute).Format("15:04")
+	schedule, err := ParseSchedule("* " + window + " 2mbit")
+	require.NoError(t, err)
+
+	limiter := New(0, nil)
+	limiter.SetSchedule(schedule)
+	assert.Equal(t, int64(250000), limiter.Rate())
+
+	limiter.SetSchedule(nil)
+	assert.Equal(t, int64(0), limiter.Rate())
+}
@@ -0,0 +1,239 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+)
+
+// File is a spooled file being forwarded. The receiving leg reports the chunks
+// it writes, and the forwarding leg waits for each chunk before reading it.
+// The forwarding leg opens the file by path as it reads, and never holds it
+// open while the receiving leg recreates, renames or removes it.
+type File struct {
+	job       Job
+	forwarder *Forwarder
+	start     sync.Once
+
+	sourceMu sync.Mutex // Held while reading the file or replacing it
+	source   *os.File   // The file opened for reading, nil until read
+
+	mu        sync.Mutex
+	changed   chan struct{} // Closed and replaced on every change
+	received  []bool
+	complete  bool // Received, verified and renamed into place
+	aborted   bool // The received data was discarded
+	forwarded bool // The upstream server has the file
+}
+
+// SetReceived sets the chunks received so far, as negotiated when the
+// receiving leg starts or resumes, and starts forwarding the file. It is
+// called before the part file is recreated for a fresh transfer, so that the
+// forwarding leg reopens it rather than reading the data it replaces.
+func (f *File) SetReceived(received []bool) {
+	f.sourceMu.Lock()
+	f.closeSource()
+	f.mu.Lock()
+	copy(f.received, received)
+	f.notify()
+	f.mu.Unlock()
+	f.sourceMu.Unlock()
+
+	f.startForwarding()
+}
+
+// Replace runs replace, which moves or removes the received file, while the
+// forwarding leg has it closed. The file is reopened by path on the next read.
+func (f *File) Replace(replace func() error) error {
+	f.sourceMu.Lock()
+	defer f.sourceMu.Unlock()
+	f.closeSource()
+	return replace()
+}
+
+// startForwarding starts the forwarding leg, unless it is running already
+func (f *File) startForwarding() {
+	f.start.Do(func() { go f.forwarder.forward(f) })
+}
+
+// ChunkReceived records that a chunk was written to the spool
+func (f *File) ChunkReceived(index int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if index >= 0 && index < int64(len(f.received)) {
+		f.received[index] = true
+		f.notify()
+	}
+}
+
+// Complete records that the file was received completely. It is removed from
+// the spool if it was forwarded already.
+func (f *File) Complete() {
+	f.mu.Lock()
+	f.complete = true
+	forwarded := f.forwarded
+	f.notify()
+	f.mu.Unlock()
+
+	if forwarded {
+		f.forwarder.release(f, true)
+	}
+}
+
+// Detach records that the receiving leg ended. Unless the file is complete,
+// forwarding waits for a client to resume sending it, or stops if the received
+// data was discarded.
+func (f *File) Detach() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.complete && !filesystem.FileExists(filesystem.PartFilePath(f.path())) {
+		f.aborted = true
+		f.notify()
+	}
+}
+
+// WaitRange waits until the chunks holding size bytes at offset were received
+func (f *File) WaitRange(ctx context.Context, offset, size int64) error {
+	return f.wait(ctx, func() bool { return f.receivedRange(offset, size) })
+}
+
+// WaitComplete waits until the file was received and verified completely
+func (f *File) WaitComplete(ctx context.Context) error {
+	return f.wait(ctx, func() bool { return f.complete })
+}
+
+// ReadAt reads len(p) bytes at offset once they were received, from the part
+// file while it is received and from the file once renamed into place
+func (f *File) ReadAt(ctx context.Context, p []byte, offset int64) (int, error) {
+	for {
+		if err := f.WaitRange(ctx, offset, int64(len(p))); err != nil {
+			return 0, err
+		}
+
+		// The chunks may have been discarded since, along with their data
+		f.sourceMu.Lock()
+		f.mu.Lock()
+		ready := f.complete || f.receivedRange(offset, int64(len(p)))
+		f.mu.Unlock()
+		if !ready {
+			f.sourceMu.Unlock()
+			continue
+		}
+		n, err := f.readSource(p, offset)
+		f.sourceMu.Unlock()
+		return n, err
+	}
+}
+
+// OpenComplete waits until the file was received and verified completely, and
+// opens it
+func (f *File) OpenComplete(ctx context.Context) (*os.File, error) {
+	if err := f.WaitComplete(ctx); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(f.path())
+	if err != nil {
+		return nil, errors.NewFileSystemError("open", f.path(), err)
+	}
+	return file, nil
+}
+
+// readSource reads from the file, opening it if needed; called with sourceMu held
+func (f *File) readSource(p []byte, offset int64) (int, error) {
+	if f.source == nil {
+		source, err := os.Open(filesystem.PartFilePath(f.path()))
+		if err != nil {
+			if source, err = os.Open(f.path()); err != nil {
+				return 0, errors.NewFileSystemError("open", f.path(), err)
+			}
+		}
+		f.source = source
+	}
+	return f.source.ReadAt(p, offset)
+}
+
+// close closes the file opened for reading, if any
+func (f *File) close() {
+	f.sourceMu.Lock()
+	defer f.sourceMu.Unlock()
+	f.closeSource()
+}
+
+// closeSource closes the file opened for reading; called with sourceMu held
+func (f *File) closeSource() {
+	if f.source != nil {
+		f.source.Close()
+		f.source = nil
+	}
+}
+
+// receivedRange reports whether the chunks holding size bytes at offset were
+// received; called with the lock held
+func (f *File) receivedRange(offset, size int64) bool {
+	last := (offset + max(size, 1) - 1) / f.job.ChunkSize
+	for i := offset / f.job.ChunkSize; i <= last && i < int64(len(f.received)); i++ {
+		if !f.received[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wait waits until ready, which is called with the lock held, returns true
+func (f *File) wait(ctx context.Context, ready func() bool) error {
+	for {
+		f.mu.Lock()
+		if f.aborted {
+			f.mu.Unlock()
+			return errors.NewValidationError("relay", f.job.Filename, "received data was discarded")
+		}
+		if f.complete || ready() {
+			f.mu.Unlock()
+			return nil
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setForwarded records that the upstream server has the file, removing it from
+// the spool if it was received completely
+func (f *File) setForwarded() {
+	f.mu.Lock()
+	f.forwarded = true
+	complete := f.complete
+	f.mu.Unlock()
+
+	if complete {
+		f.forwarder.release(f, true)
+	}
+}
+
+// isAborted reports whether the received data was discarded
+func (f *File) isAborted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.aborted
+}
+
+// notify wakes the waiters; called with the lock held
+func (f *File) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// path returns the path the file has in the spool once received completely
+func (f *File) path() string {
+	return filepath.Join(f.forwarder.cfg.OutputDir, f.job.Filename)
+}
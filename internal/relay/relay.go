@@ -0,0 +1,245 @@
+// Package relay forwards the files a relay receives to its upstream server,
+// streaming each file's chunks upstream as they arrive
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/client"
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/logging"
+)
+
+// Job describes a spooled file to forward. It is saved next to the file, so
+// that forwarding resumes after the relay restarts.
+type Job struct {
+	TransferID string `json:"transfer_id"`
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	ChunkSize  int64  `json:"chunk_size"`
+}
+
+// Forwarder forwards spooled files to the upstream server of cfg. Each file
+// has its own forwarding leg, which resumes independently of the receiving
+// leg and retries with backoff until the upstream server has the file, or
+// rejects it.
+type Forwarder struct {
+	cfg    *config.Config
+	ctx    context.Context // Cancelled by Close
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	files  map[string]*File // By name in the spool directory
+}
+
+// New creates a forwarder for the spool directory and upstream server of cfg
+func New(cfg *config.Config) *Forwarder {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Forwarder{cfg: cfg, ctx: ctx, cancel: cancel, files: make(map[string]*File)}
+}
+
+// Close stops forwarding. The spooled files keep their jobs, so that
+// forwarding resumes when the relay restarts.
+func (f *Forwarder) Close() {
+	f.cancel()
+}
+
+// Resume restarts forwarding the files spooled before the relay restarted.
+// Fully received files are forwarded right away; partly received ones as
+// their clients resume sending them.
+func (f *Forwarder) Resume() error {
+	matches, err := filepath.Glob(filepath.Join(f.cfg.OutputDir, "*"+config.RelayJobExt))
+	if err != nil {
+		return errors.NewFileSystemError("glob_jobs", f.cfg.OutputDir, err)
+	}
+
+	for _, match := range matches {
+		job, err := loadJob(match)
+		if err != nil {
+			slog.Warn("Skipping unreadable relay job", "error", err)
+			continue
+		}
+
+		file := f.newFile(job)
+		if filesystem.FileExists(file.path()) {
+			file.complete = true
+		} else if state, err := filesystem.LoadTransferState(job.Filename, f.cfg.OutputDir); err == nil &&
+			state.FileSize == job.Size && state.ChunkSize == job.ChunkSize &&
+			filesystem.FileExists(filesystem.PartFilePath(file.path())) {
+			copy(file.received, state.ChunksReceived)
+		} else {
+			// The received data is gone, so there is nothing to forward
+			os.Remove(match)
+			continue
+		}
+
+		f.mu.Lock()
+		f.files[job.Filename] = file
+		f.mu.Unlock()
+
+		slog.Info("Resuming forwarding of spooled file", "transfer_id", job.TransferID, "complete", file.complete)
+		file.startForwarding()
+	}
+
+	return nil
+}
+
+// Receive tracks a file the relay starts receiving, or returns the file being
+// forwarded if the transfer resumes an earlier one. Forwarding starts once the
+// receiving leg sets the chunks it has. A file of the same name that was
+// received but not yet forwarded is never replaced.
+func (f *Forwarder) Receive(transferID, filename string, size, chunkSize int64) (*File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if file, ok := f.files[filename]; ok {
+		file.mu.Lock()
+		resumable := !file.complete && !file.aborted && file.job.Size == size && file.job.ChunkSize == chunkSize
+		file.mu.Unlock()
+		if !resumable {
+			return nil, errors.NewValidationError("filename", filename, "an earlier file of this name is still being forwarded")
+		}
+		return file, nil
+	}
+
+	job := Job{TransferID: transferID, Filename: filename, Size: size, ChunkSize: chunkSize}
+	if err := saveJob(f.jobPath(filename), job); err != nil {
+		return nil, err
+	}
+
+	file := f.newFile(job)
+	f.files[filename] = file
+	return file, nil
+}
+
+// forward sends a file upstream until the upstream server has it, then
+// removes it from the spool once it has also been received completely. A file
+// the upstream server rejects stops being forwarded and is left in the spool.
+func (f *Forwarder) forward(file *File) {
+	ctx := logging.WithTransferID(f.ctx, file.job.TransferID)
+	backoff := config.ReconnectMinBackoff
+
+	for {
+		err := f.send(ctx, file)
+		if err == nil {
+			break
+		}
+		if file.isAborted() {
+			slog.WarnContext(ctx, "Received data was discarded, stopped forwarding")
+			f.release(file, false)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !client.Retryable(err) {
+			slog.ErrorContext(ctx, "Forwarding upstream failed, giving up",
+				"error", err,
+				"error_category", errors.Category(err),
+				"path", file.path())
+			f.release(file, false)
+			return
+		}
+
+		slog.WarnContext(ctx, "Forwarding upstream failed, retrying", "error", err, "backoff", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		if backoff *= 2; backoff > config.ReconnectMaxBackoff {
+			backoff = config.ReconnectMaxBackoff
+		}
+	}
+
+	slog.InfoContext(ctx, "File forwarded upstream", "file_size_mb", float64(file.job.Size)/(1024*1024))
+	file.setForwarded()
+}
+
+// send makes one attempt to send a file upstream, resuming what the upstream
+// server already has. The file is closed once the attempt ends, so that it can
+// be removed from the spool.
+func (f *Forwarder) send(ctx context.Context, file *File) error {
+	defer file.close()
+
+	cfg := *f.cfg
+	cfg.ChunkSize = file.job.ChunkSize
+	return client.Forward(ctx, file.job.TransferID, file.job.Filename, file.job.Size, &cfg, file)
+}
+
+// release stops tracking a file and removes its job, and the file itself if it
+// was forwarded. A file replaced by a later one of the same name is left alone.
+func (f *Forwarder) release(file *File, forwarded bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.files[file.job.Filename] != file {
+		return
+	}
+	delete(f.files, file.job.Filename)
+
+	if forwarded {
+		if err := os.Remove(file.path()); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove forwarded file from spool", "transfer_id", file.job.TransferID, "error", err)
+		}
+	}
+	if err := os.Remove(f.jobPath(file.job.Filename)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove relay job", "transfer_id", file.job.TransferID, "error", err)
+	}
+}
+
+// newFile creates the tracking of a spooled file with no chunks received
+func (f *Forwarder) newFile(job Job) *File {
+	return &File{
+		job:       job,
+		forwarder: f,
+		changed:   make(chan struct{}),
+		received:  make([]bool, (job.Size+job.ChunkSize-1)/job.ChunkSize),
+	}
+}
+
+// jobPath returns the path of the job file for a spooled file
+func (f *Forwarder) jobPath(filename string) string {
+	return filepath.Join(f.cfg.OutputDir, filename+config.RelayJobExt)
+}
+
+// saveJob writes a job file
+func saveJob(path string, job Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return errors.NewFileSystemError("marshal_job", path, err)
+	}
+	if err := os.WriteFile(path, data, config.StateFilePerms); err != nil {
+		return errors.NewFileSystemError("write_job", path, err)
+	}
+	return nil
+}
+
+// loadJob reads a job file
+func loadJob(path string) (Job, error) {
+	var job Job
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return job, errors.NewFileSystemError("read_job", path, err)
+	}
+	if err := json.Unmarshal(data, &job); err != nil {
+		return job, errors.NewFileSystemError("unmarshal_job", path, err)
+	}
+
+	// The job's own name is authoritative, as for transfer states
+	job.Filename = strings.TrimSuffix(filepath.Base(path), config.RelayJobExt)
+	if job.Size <= 0 || job.ChunkSize <= 0 || job.ChunkSize > config.MaxChunkSize {
+		return job, errors.NewValidationError("relay_job", job.Filename, "invalid size or chunk size")
+	}
+	return job, nil
+}
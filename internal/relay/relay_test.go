@@ -0,0 +1,238 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/protocol"
+)
+
+func newTestForwarder(t *testing.T) *Forwarder {
+	forwarder := New(&config.Config{OutputDir: t.TempDir(), ServerAddress: "127.0.0.1:1", SkipProfiling: true})
+	t.Cleanup(forwarder.Close)
+	return forwarder
+}
+
+// forwardInBackground runs the forwarding leg of file, returning a channel
+// closed once it ends
+func forwardInBackground(forwarder *Forwarder, file *File) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		forwarder.forward(file)
+	}()
+	return done
+}
+
+func TestForwarder_GivesUpWhenRejected(t *testing.T) {
+	// The upstream server rejects every transfer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			writer := bufio.NewWriter(conn)
+			protocol.SendError(writer, "destination file already exists")
+			writer.Flush()
+			conn.Close()
+		}
+	}()
+
+	forwarder := newTestForwarder(t)
+	forwarder.cfg.ServerAddress = listener.Addr().String()
+	file, err := forwarder.Receive("id", "data.bin", 300, 100)
+	require.NoError(t, err)
+
+	select {
+	case <-forwardInBackground(forwarder, file):
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarding kept retrying a rejected file")
+	}
+	assert.NoFileExists(t, forwarder.jobPath("data.bin"))
+	assert.Empty(t, forwarder.files)
+}
+
+func TestForwarder_CloseStopsRetrying(t *testing.T) {
+	// Nothing listens upstream, so forwarding retries with backoff
+	forwarder := newTestForwarder(t)
+	file, err := forwarder.Receive("id", "data.bin", 300, 100)
+	require.NoError(t, err)
+
+	done := forwardInBackground(forwarder, file)
+	forwarder.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarding did not stop on Close")
+	}
+
+	// The job is kept for the relay to resume forwarding after a restart
+	assert.FileExists(t, forwarder.jobPath("data.bin"))
+}
+
+func TestFile_WaitRange(t *testing.T) {
+	forwarder := newTestForwarder(t)
+	file, err := forwarder.Receive("id", "data.bin", 250, 100)
+	require.NoError(t, err)
+
+	// Chunks not received yet keep the forwarding leg waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, file.WaitRange(ctx, 100, 150), context.DeadlineExceeded)
+
+	waited := make(chan error, 1)
+	go func() { waited <- file.WaitRange(context.Background(), 100, 150) }()
+	file.ChunkReceived(1)
+	select {
+	case <-waited:
+		t.Fatal("range ready before its last chunk was received")
+	case <-time.After(20 * time.Millisecond):
+	}
+	file.ChunkReceived(2)
+	require.NoError(t, <-waited)
+
+	// Once complete, everything is ready
+	go func() { waited <- file.WaitComplete(context.Background()) }()
+	file.Complete()
+	require.NoError(t, <-waited)
+	assert.NoError(t, file.WaitRange(context.Background(), 0, 100))
+}
+
+func TestFile_Detach(t *testing.T) {
+	forwarder := newTestForwarder(t)
+	file, err := forwarder.Receive("id", "data.bin", 100, 100)
+	require.NoError(t, err)
+
+	// A resumable part file keeps the file waiting for its client
+	partPath := filepath.Join(forwarder.cfg.OutputDir, "data.bin"+config.PartFileExt)
+	require.NoError(t, os.WriteFile(partPath, nil, 0644))
+	file.Detach()
+	assert.False(t, file.isAborted())
+
+	// Without it, waiting fails
+	require.NoError(t, os.Remove(partPath))
+	file.Detach()
+	err = file.WaitComplete(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "received data was discarded")
+}
+
+func TestForwarder_Receive(t *testing.T) {
+	forwarder := newTestForwarder(t)
+	file, err := forwarder.Receive("id", "data.bin", 300, 100)
+	require.NoError(t, err)
+
+	job, err := loadJob(forwarder.jobPath("data.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, Job{TransferID: "id", Filename: "data.bin", Size: 300, ChunkSize: 100}, job)
+
+	// A resumed transfer gets the same file
+	resumed, err := forwarder.Receive("id", "data.bin", 300, 100)
+	require.NoError(t, err)
+	assert.Same(t, file, resumed)
+
+	// A different file of the same name is refused until forwarded
+	_, err = forwarder.Receive("other", "data.bin", 400, 100)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "still being forwarded")
+
+	file.Complete()
+	_, err = forwarder.Receive("id", "data.bin", 300, 100)
+	require.Error(t, err)
+
+	// Releasing removes the job
+	forwarder.release(file, false)
+	assert.NoFileExists(t, forwarder.jobPath("data.bin"))
+}
+
+func TestForwarder_Resume(t *testing.T) {
+	forwarder := newTestForwarder(t)
+
+	// A job whose received data is gone is removed
+	require.NoError(t, saveJob(forwarder.jobPath("gone.bin"), Job{TransferID: "id", Size: 100, ChunkSize: 100}))
+
+	// A corrupt job is skipped
+	require.NoError(t, os.WriteFile(forwarder.jobPath("broken.bin"), []byte("{"), 0644))
+
+	require.NoError(t, forwarder.Resume())
+	assert.NoFileExists(t, forwarder.jobPath("gone.bin"))
+	assert.FileExists(t, forwarder.jobPath("broken.bin"))
+	assert.Empty(t, forwarder.files)
+}
+
+func TestFile_ReadAt(t *testing.T) {
+	forwarder := newTestForwarder(t)
+	file, err := forwarder.Receive("id", "data.bin", 200, 100)
+	require.NoError(t, err)
+	file.start.Do(func() {}) // Read here instead of forwarding upstream
+
+	partPath := filepath.Join(forwarder.cfg.OutputDir, "data.bin"+config.PartFileExt)
+	require.NoError(t, os.WriteFile(partPath, bytes.Repeat([]byte("a"), 200), 0644))
+	file.SetReceived([]bool{true, false})
+
+	buf := make([]byte, 100)
+	n, err := file.ReadAt(context.Background(), buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 100), buf)
+
+	// The client rejects resuming: the received chunks are discarded before the
+	// part file is recreated, and reads wait for the new data
+	file.SetReceived([]bool{false, false})
+	require.NoError(t, os.Remove(partPath))
+	require.NoError(t, os.WriteFile(partPath, make([]byte, 200), 0644))
+
+	read := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 100)
+		if _, err := file.ReadAt(context.Background(), buf, 0); err == nil {
+			read <- buf
+		}
+		close(read)
+	}()
+	select {
+	case <-read:
+		t.Fatal("read a chunk discarded by the fresh transfer")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f, err := os.OpenFile(partPath, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt(bytes.Repeat([]byte("b"), 100), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	file.ChunkReceived(0)
+	assert.Equal(t, bytes.Repeat([]byte("b"), 100), <-read)
+
+	// The file is closed while renamed into place, then read under its final name
+	require.NoError(t, file.Replace(func() error {
+		assert.Nil(t, file.source)
+		return os.Rename(partPath, file.path())
+	}))
+	file.ChunkReceived(1)
+	file.Complete()
+
+	n, err = file.ReadAt(context.Background(), buf, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, make([]byte, 100), buf)
+
+	complete, err := file.OpenComplete(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, file.path(), complete.Name())
+	complete.Close()
+}
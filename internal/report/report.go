@@ -0,0 +1,192 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/progress"
+)
+
+// Version is the schema version written to every report
+const Version = 1
+
+// Transfer outcomes recorded in reports
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// fileTimestampLayout is the timestamp format used in report file names
+const fileTimestampLayout = "20060102-150405.000"
+
+// Report describes a finished transfer for audit purposes
+type Report struct {
+	Version           int          `json:"version"`
+	Role              string       `json:"role"`
+	TransferID        string       `json:"transfer_id,omitempty"`
+	Status            string       `json:"status"`
+	Error             string       `json:"error,omitempty"`
+	ErrorCategory     string       `json:"error_category,omitempty"`
+	SourceName        string       `json:"source_name"`
+	DestinationName   string       `json:"destination_name,omitempty"`
+	DestinationPath   string       `json:"destination_path,omitempty"`
+	RemoteAddr        string       `json:"remote_addr"`
+	Size              int64        `json:"size"`
+	ChunkSize         int64        `json:"chunk_size"`
+	TotalChunks       int64        `json:"total_chunks"`
+	ResumedChunks     int64        `json:"resumed_chunks"`
+	TransferredChunks int64        `json:"transferred_chunks"`
+	ResumedBytes      int64        `json:"resumed_bytes"`
+	BytesTransferred  int64        `json:"bytes_transferred"`
+	Retries           int64        `json:"retries"`
+	Reconnects        int          `json:"reconnects"`
+	ChunkRetries      []ChunkRetry `json:"chunk_retries"`
+	Compression       Compression  `json:"compression"`
+	Hash              *Hash        `json:"hash,omitempty"`
+	Network           *Network     `json:"network,omitempty"`
+	Timings           Timings      `json:"timings"`
+}
+
+// ChunkRetry is the number of retries needed by a single chunk
+type ChunkRetry struct {
+	Chunk   int64 `json:"chunk"`
+	Retries int64 `json:"retries"`
+}
+
+// Compression summarizes compression of the transferred chunks. Enabled is set
+// when at least one chunk was sent compressed.
+type Compression struct {
+	Enabled     bool    `json:"enabled"`
+	InputBytes  int64   `json:"input_bytes"`
+	OutputBytes int64   `json:"output_bytes"`
+	Ratio       float64 `json:"ratio"`
+}
+
+// Hash records the integrity check of the transfer
+type Hash struct {
+	Algorithm       string `json:"algorithm"`
+	SourceHash      string `json:"source_hash,omitempty"`
+	DestinationHash string `json:"destination_hash,omitempty"`
+	Verified        bool   `json:"verified"`
+}
+
+// Network records the network profile measured before the transfer
+type Network struct {
+	RTTSeconds           float64 `json:"rtt_seconds"`
+	JitterSeconds        float64 `json:"jitter_seconds"`
+	BandwidthBytesPerSec int64   `json:"bandwidth_bytes_per_sec"`
+	BandwidthMeasured    bool    `json:"bandwidth_measured"`
+	PacketLoss           float64 `json:"packet_loss"`
+	PacketLossMeasured   bool    `json:"packet_loss_measured"`
+}
+
+// Timings records when the transfer ran and how fast it was
+type Timings struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	RateBytesPerSec float64   `json:"rate_bytes_per_sec"`
+}
+
+// New creates a report for a transfer in the given role that started at start
+func New(role string, start time.Time) *Report {
+	return &Report{
+		Version:      Version,
+		Role:         role,
+		ChunkRetries: []ChunkRetry{},
+		Timings:      Timings{Start: start},
+	}
+}
+
+// AddStats copies chunk, retry and compression statistics into the report
+func (r *Report) AddStats(stats *progress.Stats) {
+	r.Size = stats.TotalBytes
+	r.TotalChunks = stats.TotalChunks
+	r.ResumedChunks = stats.ResumedChunks
+	r.TransferredChunks = stats.CompletedChunks.Load() - stats.ResumedChunks
+	r.ResumedBytes = stats.ResumedBytes
+	r.BytesTransferred = stats.GetTransferred()
+	r.Retries = stats.Retries.Load()
+
+	r.ChunkRetries = r.ChunkRetries[:0]
+	for chunk, count := range stats.ChunkRetries() {
+		r.ChunkRetries = append(r.ChunkRetries, ChunkRetry{Chunk: chunk, Retries: count})
+	}
+	sort.Slice(r.ChunkRetries, func(i, j int) bool { return r.ChunkRetries[i].Chunk < r.ChunkRetries[j].Chunk })
+
+	r.Compression.InputBytes = stats.CompressionInputBytes.Load()
+	r.Compression.OutputBytes = stats.CompressionOutputBytes.Load()
+	r.Compression.Enabled = r.Compression.OutputBytes > 0
+	if r.Compression.OutputBytes > 0 {
+		r.Compression.Ratio = float64(r.Compression.InputBytes) / float64(r.Compression.OutputBytes)
+	}
+}
+
+// Finish records the outcome and end time of the transfer
+func (r *Report) Finish(transferErr error, end time.Time) {
+	r.Status = StatusCompleted
+	if transferErr != nil {
+		r.Status = StatusFailed
+		r.Error = transferErr.Error()
+		r.ErrorCategory = errors.Category(transferErr)
+	}
+
+	r.Timings.End = end
+	r.Timings.DurationSeconds = end.Sub(r.Timings.Start).Seconds()
+	if r.Timings.DurationSeconds > 0 {
+		// Only count data moved in this session, not resumed chunks
+		r.Timings.RateBytesPerSec = float64(r.BytesTransferred-r.ResumedBytes) / r.Timings.DurationSeconds
+	}
+}
+
+// Write stores the report as a JSON file in dir and returns its path. The file is
+// written under a temporary name and renamed, so readers never see partial reports.
+func Write(dir string, r *Report) (string, error) {
+	if err := filesystem.EnsureDirectoryExists(dir); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", errors.NewValidationError("report", r.SourceName, "failed to encode report")
+	}
+
+	// Transfers that fail before the filename is known still get a report
+	source := filepath.Base(r.SourceName)
+	if r.SourceName == "" {
+		source = "unknown"
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.json", r.Timings.Start.Format(fileTimestampLayout), r.Role, source)
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return "", errors.NewFileSystemError("create_report", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return "", errors.NewFileSystemError("write_report", path, err)
+	}
+	if err := tmp.Chmod(config.StateFilePerms); err != nil {
+		tmp.Close()
+		return "", errors.NewFileSystemError("chmod_report", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", errors.NewFileSystemError("close_report", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", errors.NewFileSystemError("rename_report", path, err)
+	}
+
+	return path, nil
+}
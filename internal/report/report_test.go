@@ -0,0 +1,78 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/progress"
+)
+
+func TestReportFromStats(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	stats := &progress.Stats{TotalBytes: 4000, TotalChunks: 4, ResumedChunks: 1, ResumedBytes: 1000}
+	stats.SetTransferred(4000)
+	stats.CompletedChunks.Store(4)
+	stats.AddRetry(3)
+	stats.AddRetry(2)
+	stats.AddRetry(3)
+	stats.AddCompression(2000, 500)
+
+	rep := New("client", start)
+	rep.ChunkSize = 1000
+	rep.AddStats(stats)
+	rep.Finish(nil, start.Add(3*time.Second))
+
+	assert.Equal(t, StatusCompleted, rep.Status)
+	assert.Equal(t, int64(3), rep.TransferredChunks)
+	assert.Equal(t, int64(3), rep.Retries)
+	assert.Equal(t, []ChunkRetry{{Chunk: 2, Retries: 1}, {Chunk: 3, Retries: 2}}, rep.ChunkRetries)
+	assert.True(t, rep.Compression.Enabled)
+	assert.InDelta(t, 4.0, rep.Compression.Ratio, 0.001)
+	assert.InDelta(t, 3.0, rep.Timings.DurationSeconds, 0.001)
+	assert.InDelta(t, 1000.0, rep.Timings.RateBytesPerSec, 0.001)
+}
+
+func TestReportFailure(t *testing.T) {
+	rep := New("server", time.Now())
+	rep.Finish(errors.NewNetworkError("read", "", os.ErrClosed), time.Now())
+
+	assert.Equal(t, StatusFailed, rep.Status)
+	assert.Equal(t, "network", rep.ErrorCategory)
+	assert.NotEmpty(t, rep.Error)
+}
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "reports")
+
+	rep := New("server", time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC))
+	rep.SourceName = "data.bin"
+	rep.Hash = &Hash{Algorithm: "sha256", SourceHash: "abc", DestinationHash: "abc", Verified: true}
+	rep.Finish(nil, rep.Timings.Start.Add(time.Second))
+
+	path, err := Write(dir, rep)
+	require.NoError(t, err)
+	assert.Equal(t, "20250601-100000.000_server_data.bin.json", filepath.Base(path))
+
+	// Only the final report remains, no temporary files
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var decoded Report
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, Version, decoded.Version)
+	assert.Equal(t, "data.bin", decoded.SourceName)
+	assert.Equal(t, StatusCompleted, decoded.Status)
+	require.NotNil(t, decoded.Hash)
+	assert.True(t, decoded.Hash.Verified)
+}
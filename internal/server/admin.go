@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/logging"
+	"justdatacopier/internal/progress"
+)
+
+// TransferInfo describes an active transfer as reported by the admin API
+type TransferInfo struct {
+	ID               string    `json:"id"`
+	RemoteAddr       string    `json:"remote_addr"`
+	Filename         string    `json:"filename"`
+	Size             int64     `json:"size"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	Percent          float64   `json:"percent"`
+	RateBytesPerSec  float64   `json:"rate_bytes_per_sec"`
+	StartTime        time.Time `json:"start_time"`
+}
+
+// PartialInfo describes an interrupted transfer that can be resumed
+type PartialInfo struct {
+	Filename       string    `json:"filename"`
+	TransferID     string    `json:"transfer_id,omitempty"`
+	Size           int64     `json:"size"`
+	ChunkSize      int64     `json:"chunk_size"`
+	ChunksReceived int64     `json:"chunks_received"`
+	TotalChunks    int64     `json:"total_chunks"`
+	LastModified   time.Time `json:"last_modified"`
+	Active         bool      `json:"active"`
+}
+
+// activeTransfer is a transfer currently being received
+type activeTransfer struct {
+	mu           sync.Mutex
+	id           string
+	remoteAddr   string
+	startTime    time.Time
+	filename     string
+	size         int64
+	stats        *progress.Stats
+	resumedBytes int64
+	cancel       context.CancelFunc
+	conn         net.Conn
+	done         chan struct{} // Closed once the transfer is removed
+}
+
+// stop cancels the transfer and closes its connection. Cancelling the context
+// stops the transfer loop and saves its state; closing the connection unblocks
+// any pending network I/O.
+func (t *activeTransfer) stop() {
+	t.cancel()
+	t.conn.Close()
+}
+
+// setFile records the destination file of the transfer once it is known
+func (t *activeTransfer) setFile(filename string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.filename = filename
+	t.size = size
+}
+
+// setStats attaches progress statistics once the data phase starts
+func (t *activeTransfer) setStats(stats *progress.Stats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = stats
+	t.resumedBytes = stats.GetTransferred()
+}
+
+// info returns a snapshot of the transfer
+func (t *activeTransfer) info() TransferInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := TransferInfo{
+		ID:         t.id,
+		RemoteAddr: t.remoteAddr,
+		Filename:   t.filename,
+		Size:       t.size,
+		StartTime:  t.startTime,
+	}
+
+	if t.stats != nil {
+		info.BytesTransferred = t.stats.GetTransferred()
+		info.Percent = t.stats.Percent()
+		if elapsed := time.Since(t.stats.StartTime).Seconds(); elapsed > 0 {
+			info.RateBytesPerSec = float64(info.BytesTransferred-t.resumedBytes) / elapsed
+		}
+	}
+
+	return info
+}
+
+// transferRegistry tracks active transfers so they can be listed and cancelled
+type transferRegistry struct {
+	mu        sync.Mutex
+	transfers map[string]*activeTransfer
+}
+
+// staleTransferTimeout bounds the wait for a replaced transfer to stop
+const staleTransferTimeout = 10 * time.Second
+
+// sameHost reports whether two remote addresses belong to the same host
+func sameHost(a, b string) bool {
+	hostA, _, errA := net.SplitHostPort(a)
+	hostB, _, errB := net.SplitHostPort(b)
+	return errA == nil && errB == nil && hostA == hostB
+}
+
+// activeTransfers holds all transfers handled by this process
+var activeTransfers = &transferRegistry{transfers: make(map[string]*activeTransfer)}
+
+// register adds a new transfer with the client's transfer ID on conn; cancel
+// aborts its context. A client reconnecting from the same host takes over a
+// transfer with its ID that has not noticed the old connection drop yet: that
+// transfer is stopped first. Any other transfer ID still in use is rejected.
+func (r *transferRegistry) register(id string, conn net.Conn, cancel context.CancelFunc) (*activeTransfer, error) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.transfers[id]; exists {
+		if !sameHost(existing.remoteAddr, remoteAddr) {
+			return nil, errors.NewValidationError("transfer_id", id, "transfer ID already in use")
+		}
+
+		slog.Info("Replacing stale transfer of reconnecting client",
+			logging.TransferIDKey, id,
+			"remote_addr", remoteAddr)
+
+		r.mu.Unlock()
+		existing.stop()
+		select {
+		case <-existing.done:
+		case <-time.After(staleTransferTimeout):
+		}
+		r.mu.Lock()
+
+		if _, exists := r.transfers[id]; exists {
+			return nil, errors.NewValidationError("transfer_id", id, "transfer ID already in use")
+		}
+	}
+
+	transfer := &activeTransfer{
+		id:         id,
+		remoteAddr: remoteAddr,
+		startTime:  time.Now(),
+		cancel:     cancel,
+		conn:       conn,
+		done:       make(chan struct{}),
+	}
+	r.transfers[id] = transfer
+	return transfer, nil
+}
+
+// remove drops a finished transfer from the registry
+func (r *transferRegistry) remove(transfer *activeTransfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.transfers[transfer.id] == transfer {
+		delete(r.transfers, transfer.id)
+	}
+	close(transfer.done)
+}
+
+// list returns snapshots of all active transfers ordered by start time
+func (r *transferRegistry) list() []TransferInfo {
+	r.mu.Lock()
+	transfers := make([]*activeTransfer, 0, len(r.transfers))
+	for _, transfer := range r.transfers {
+		transfers = append(transfers, transfer)
+	}
+	r.mu.Unlock()
+
+	infos := make([]TransferInfo, 0, len(transfers))
+	for _, transfer := range transfers {
+		infos = append(infos, transfer.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartTime.Before(infos[j].StartTime) })
+	return infos
+}
+
+// cancel aborts an active transfer, returning false if it does not exist
+func (r *transferRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	transfer, ok := r.transfers[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	transfer.stop()
+	return true
+}
+
+// serveAdmin starts the admin HTTP API on address and serves it in the background
+func serveAdmin(address string, cfg *config.Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /transfers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, activeTransfers.list())
+	})
+	mux.HandleFunc("POST /transfers/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !activeTransfers.cancel(id) {
+			writeError(w, http.StatusNotFound, "transfer not found")
+			return
+		}
+		slog.Info("Transfer cancelled via admin API", logging.TransferIDKey, id)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "cancelled"})
+	})
+	mux.HandleFunc("GET /partials", func(w http.ResponseWriter, r *http.Request) {
+		partials, err := listPartials(cfg)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, partials)
+	})
+	mux.HandleFunc("DELETE /partials/{name}", func(w http.ResponseWriter, r *http.Request) {
+		status, err := discardPartial(r.PathValue("name"), cfg)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, status, map[string]string{"status": "discarded"})
+	})
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.NewNetworkError("listen", address, err)
+	}
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Admin API stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Admin API ready", "address", listener.Addr().String())
+	return nil
+}
+
+// listPartials reports the interrupted transfers found in the output directory
+func listPartials(cfg *config.Config) ([]PartialInfo, error) {
+	states, err := filesystem.ListTransferStates(cfg.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	partials := make([]PartialInfo, 0, len(states))
+	for _, state := range states {
+		partials = append(partials, PartialInfo{
+			Filename:       state.Filename,
+			TransferID:     state.TransferID,
+			Size:           state.FileSize,
+			ChunkSize:      state.ChunkSize,
+			ChunksReceived: state.CountReceivedChunks(),
+			TotalChunks:    state.NumChunks,
+			LastModified:   state.LastModified,
+			Active:         activeDestinations.isLocked(filepath.Join(cfg.OutputDir, state.Filename)),
+		})
+	}
+
+	return partials, nil
+}
+
+// discardPartial removes the part file and state of an inactive partial transfer
+// and returns the HTTP status to report
+func discardPartial(name string, cfg *config.Config) (int, error) {
+	if name == "" || filepath.Base(name) != name || filesystem.ValidateFilePath(name) != nil {
+		return http.StatusBadRequest, errors.NewValidationError("name", name, "invalid partial transfer name")
+	}
+
+	if _, err := filesystem.LoadTransferState(name, cfg.OutputDir); err != nil {
+		return http.StatusNotFound, errors.NewValidationError("name", name, "partial transfer not found")
+	}
+
+	// Hold the destination lock so no transfer can resume while discarding
+	outputPath := filepath.Join(cfg.OutputDir, name)
+	if !activeDestinations.tryLock(outputPath) {
+		return http.StatusConflict, errors.NewValidationError("name", name, "transfer is active")
+	}
+	defer activeDestinations.unlock(outputPath)
+
+	partPath := filesystem.PartFilePath(outputPath)
+	if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+		return http.StatusInternalServerError, errors.NewFileSystemError("remove_partial", partPath, err)
+	}
+	if err := filesystem.RemoveTransferState(name, cfg.OutputDir); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	slog.Info("Partial transfer discarded via admin API")
+	return http.StatusOK, nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("Failed to write admin response", "error", err)
+	}
+}
+
+// writeError writes an error message as a JSON response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
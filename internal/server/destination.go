@@ -0,0 +1,139 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+)
+
+// maxSuffixAttempts bounds the search for a free suffixed filename
+const maxSuffixAttempts = 10000
+
+// destinationLocks tracks output paths that have an active transfer, so that
+// concurrent transfers never share a part file or state file
+type destinationLocks struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// activeDestinations holds the locks for all transfers handled by this process
+var activeDestinations = &destinationLocks{paths: make(map[string]bool)}
+
+// tryLock locks a destination path, returning false if it is already in use
+func (d *destinationLocks) tryLock(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.paths[path] {
+		return false
+	}
+	d.paths[path] = true
+	return true
+}
+
+// unlock releases a destination path
+func (d *destinationLocks) unlock(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.paths, path)
+}
+
+// isLocked reports whether a destination path has an active transfer
+func (d *destinationLocks) isLocked(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paths[path]
+}
+
+// resolveDestination applies the configured collision policy to an incoming filename
+// and locks the chosen destination. The caller releases the lock with
+// activeDestinations.unlock once the transfer and its completion actions end.
+func resolveDestination(filename, transferID string, cfg *config.Config) (string, error) {
+	switch cfg.CollisionPolicy {
+	case config.CollisionFail:
+		outputPath := filepath.Join(cfg.OutputDir, filename)
+		if filesystem.FileExists(outputPath) {
+			return "", errors.NewValidationError("filename", filename, "destination file already exists")
+		}
+		return lockDestination(filename, cfg)
+
+	case config.CollisionRename:
+		// The first free name is chosen deterministically, so an interrupted
+		// transfer resumes into the same suffixed destination
+		for n := 0; n < maxSuffixAttempts; n++ {
+			candidate := filename
+			if n > 0 {
+				candidate = filesystem.SuffixedFilename(filename, n)
+			}
+			if filesystem.FileExists(filepath.Join(cfg.OutputDir, candidate)) {
+				continue
+			}
+			if name, err := lockDestination(candidate, cfg); err == nil {
+				return name, nil
+			}
+		}
+		return "", errors.NewValidationError("filename", filename, "no free destination name available")
+
+	case config.CollisionVersion:
+		// A reconnecting transfer resumes into the versioned name it started with
+		if name, ok := pendingVersion(filename, transferID, cfg); ok {
+			return lockDestination(name, cfg)
+		}
+		if !filesystem.FileExists(filepath.Join(cfg.OutputDir, filename)) {
+			if name, err := lockDestination(filename, cfg); err == nil {
+				return name, nil
+			}
+		}
+		versioned := filesystem.TimestampedFilename(filename, time.Now())
+		for n := 1; n < maxSuffixAttempts; n++ {
+			if !filesystem.FileExists(filepath.Join(cfg.OutputDir, versioned)) {
+				if name, err := lockDestination(versioned, cfg); err == nil {
+					return name, nil
+				}
+			}
+			versioned = filesystem.SuffixedFilename(filesystem.TimestampedFilename(filename, time.Now()), n)
+		}
+		return "", errors.NewValidationError("filename", filename, "no free destination name available")
+
+	default:
+		// Overwrite: replace any existing file, but never share a destination
+		// with another transfer in progress
+		return lockDestination(filename, cfg)
+	}
+}
+
+// pendingVersion returns the versioned name that the interrupted transfer
+// transferID of filename was received under, if it can still be resumed
+func pendingVersion(filename, transferID string, cfg *config.Config) (string, bool) {
+	states, err := filesystem.ListTransferStates(cfg.OutputDir)
+	if err != nil {
+		return "", false
+	}
+
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filename, ext) + "."
+	for _, state := range states {
+		name := state.Filename
+		if state.TransferID != transferID || name == filename ||
+			!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if filesystem.FileExists(filesystem.PartFilePath(filepath.Join(cfg.OutputDir, name))) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// lockDestination locks the output path for filename or reports that it is busy
+func lockDestination(filename string, cfg *config.Config) (string, error) {
+	if !activeDestinations.tryLock(filepath.Join(cfg.OutputDir, filename)) {
+		return "", errors.NewValidationError("filename", filename, "destination is in use by another transfer")
+	}
+	return filename, nil
+}
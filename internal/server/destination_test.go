@@ -0,0 +1,125 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/filesystem"
+)
+
+// newDestinationConfig returns a config with policy and an output directory
+// holding existing files of the given names
+func newDestinationConfig(t *testing.T, policy string, existing ...string) *config.Config {
+	cfg := &config.Config{OutputDir: t.TempDir(), CollisionPolicy: policy}
+	for _, name := range existing {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.OutputDir, name), nil, 0644))
+	}
+	return cfg
+}
+
+// resolveAndUnlock resolves a destination and releases its lock when the test ends
+func resolveAndUnlock(t *testing.T, filename, transferID string, cfg *config.Config) (string, error) {
+	name, err := resolveDestination(filename, transferID, cfg)
+	if err == nil {
+		t.Cleanup(func() { activeDestinations.unlock(filepath.Join(cfg.OutputDir, name)) })
+	}
+	return name, err
+}
+
+func TestDestinationLocks(t *testing.T) {
+	locks := &destinationLocks{paths: make(map[string]bool)}
+
+	assert.True(t, locks.tryLock("/out/data.bin"))
+	assert.True(t, locks.isLocked("/out/data.bin"))
+	assert.False(t, locks.tryLock("/out/data.bin"))
+	assert.True(t, locks.tryLock("/out/other.bin"))
+
+	locks.unlock("/out/data.bin")
+	assert.False(t, locks.isLocked("/out/data.bin"))
+	assert.True(t, locks.tryLock("/out/data.bin"))
+}
+
+func TestResolveDestination_Fail(t *testing.T) {
+	cfg := newDestinationConfig(t, config.CollisionFail, "data.bin")
+
+	_, err := resolveAndUnlock(t, "data.bin", "id", cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "destination file already exists")
+
+	name, err := resolveAndUnlock(t, "new.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "new.bin", name)
+
+	// A destination in use by another transfer is rejected
+	_, err = resolveAndUnlock(t, "new.bin", "other", cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "in use by another transfer")
+}
+
+func TestResolveDestination_Overwrite(t *testing.T) {
+	cfg := newDestinationConfig(t, config.CollisionOverwrite, "data.bin")
+
+	name, err := resolveAndUnlock(t, "data.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "data.bin", name)
+
+	_, err = resolveAndUnlock(t, "data.bin", "other", cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "in use by another transfer")
+}
+
+func TestResolveDestination_Rename(t *testing.T) {
+	cfg := newDestinationConfig(t, config.CollisionRename, "data.bin", "data_1.bin")
+
+	name, err := resolveAndUnlock(t, "data.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "data_2.bin", name)
+
+	// A concurrent transfer takes the next free name
+	name, err = resolveAndUnlock(t, "data.bin", "other", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "data_3.bin", name)
+
+	name, err = resolveAndUnlock(t, "new.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "new.bin", name)
+}
+
+func TestResolveDestination_Version(t *testing.T) {
+	cfg := newDestinationConfig(t, config.CollisionVersion, "data.bin")
+
+	name, err := resolveAndUnlock(t, "data.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, "data.bin", name)
+	assert.True(t, strings.HasPrefix(name, "data."), name)
+	assert.True(t, strings.HasSuffix(name, ".bin"), name)
+
+	// A transfer interrupted an hour ago leaves its part and state files behind
+	started := filesystem.TimestampedFilename("data.bin", time.Now().Add(-time.Hour))
+	outputPath := filepath.Join(cfg.OutputDir, started)
+	require.NoError(t, os.WriteFile(filesystem.PartFilePath(outputPath), nil, 0644))
+	require.NoError(t, filesystem.SaveTransferState(&filesystem.TransferState{Filename: started, TransferID: "interrupted"}, cfg.OutputDir))
+
+	// Reconnecting resumes into the name it started with
+	resumed, err := resolveAndUnlock(t, "data.bin", "interrupted", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, started, resumed)
+
+	// Another transfer gets a name of its own
+	other, err := resolveAndUnlock(t, "data.bin", "other", cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, started, other)
+	assert.NotEqual(t, "data.bin", other)
+
+	// Without an existing file, the name is kept
+	name, err = resolveAndUnlock(t, "new.bin", "id", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "new.bin", name)
+}
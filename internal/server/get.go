@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"justdatacopier/internal/client"
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/logging"
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/notify"
+	"justdatacopier/internal/protocol"
+)
+
+// Get downloads cfg.RemoteName from the server at cfg.ServerAddress into the
+// output directory. The server sends the file over the connection opened here,
+// and it is received exactly like an upload.
+func Get(cfg *config.Config) error {
+	notifier = notify.New(cfg)
+	defer notifier.Close()
+
+	if err := startMonitoring(cfg); err != nil {
+		return err
+	}
+
+	if err := filesystem.EnsureDirectoryExists(cfg.OutputDir); err != nil {
+		return err
+	}
+
+	slog.Info("Requesting file from server", "server", cfg.ServerAddress)
+
+	conn, err := network.NewDialer(cfg).Dial(cfg.ServerAddress)
+	if err != nil {
+		return errors.NewNetworkError("dial", cfg.ServerAddress, err)
+	}
+	defer conn.Close()
+
+	if err := network.OptimizeTCPConnection(conn); err != nil {
+		slog.Warn("Failed to optimize TCP connection", "error", err)
+	}
+
+	reader := bufio.NewReaderSize(conn, cfg.BufferSize)
+	writer := bufio.NewWriterSize(conn, cfg.BufferSize)
+
+	if err := protocol.SendCommand(writer, protocol.CmdGet); err != nil {
+		return err
+	}
+	if err := protocol.SendString(writer, cfg.RemoteName); err != nil {
+		return err
+	}
+	if err := protocol.FlushWriter(writer); err != nil {
+		return err
+	}
+
+	// The server now acts as the sender and starts the transfer
+	version := int64(protocol.LegacyProtocolVersion)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		cmdByte, err := protocol.ReadCommand(ctx, reader)
+		cancel()
+		if err != nil {
+			return errors.NewNetworkError("read_command", cfg.ServerAddress, err)
+		}
+
+		switch cmdByte {
+		case protocol.CmdVersion:
+			if version, err = readVersion(reader, cfg); err != nil {
+				return err
+			}
+		case protocol.CmdInit:
+			return handleFileTransfer(reader, writer, conn, version, cfg)
+		case protocol.CmdError:
+			message, _ := protocol.ReadString(context.Background(), reader)
+			return errors.NewProtocolError("server_error", message, nil)
+		default:
+			return errors.NewProtocolError("get", "unexpected command from server", nil)
+		}
+	}
+}
+
+// handleGet sends a file from the output directory to a client running jdc get
+func handleGet(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	name, err := protocol.ReadString(ctx, reader)
+	cancel()
+	if err != nil {
+		slog.Error("Failed to read requested file name", "error", err)
+		return
+	}
+
+	if !cfg.AllowGet {
+		slog.Warn("Download rejected, downloads are disabled", "remote_addr", conn.RemoteAddr().String())
+		protocol.SendError(writer, "Downloads are disabled on this server")
+		return
+	}
+
+	path, err := servedFilePath(name, cfg)
+	if err != nil {
+		slog.Warn("Download rejected", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.SendError(writer, err.Error())
+		return
+	}
+
+	// The client sends nothing more until the transfer starts, so nothing is left
+	// buffered in reader when the sender takes over the connection. The client
+	// cannot be reached by a profiling connection of its own.
+	sendCfg := *cfg
+	sendCfg.FilePath = path
+	sendCfg.ServerAddress = conn.RemoteAddr().String()
+	sendCfg.SkipProfiling = true
+
+	slog.Info("Sending file to client", "remote_addr", sendCfg.ServerAddress)
+	if err := client.SendOverConn(conn, &sendCfg, totalLimiter); err != nil {
+		logging.LogError(err, "get")
+		return
+	}
+	slog.Info("File sent to client", "remote_addr", sendCfg.ServerAddress)
+}
+
+// handleVerify reports the size and hash of a file in the output directory to a
+// client running jdc verify. Like downloads, this needs cfg.AllowGet.
+func handleVerify(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	name, err := protocol.ReadString(ctx, reader)
+	if err != nil {
+		slog.Error("Failed to read file name to verify", "error", err)
+		return
+	}
+
+	cmdByte, err := protocol.ReadCommand(ctx, reader)
+	if err != nil || cmdByte != protocol.CmdHashAlgo {
+		slog.Error("Failed to read hash algorithm", "error", err)
+		protocol.SendError(writer, "Expected hash algorithm")
+		return
+	}
+	algorithm, err := protocol.ReadHashAlgorithm(ctx, reader)
+	if err != nil {
+		slog.Error("Invalid hash algorithm", "error", err)
+		protocol.SendError(writer, "Invalid hash algorithm")
+		return
+	}
+
+	if !cfg.AllowGet {
+		slog.Warn("Verification rejected, downloads are disabled", "remote_addr", conn.RemoteAddr().String())
+		protocol.SendError(writer, "Downloads are disabled on this server")
+		return
+	}
+
+	path, err := servedFilePath(name, cfg)
+	if err != nil {
+		slog.Warn("Verification rejected", "error", err)
+		protocol.SendError(writer, err.Error())
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		protocol.SendError(writer, "Failed to open file")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		protocol.SendError(writer, "Failed to read file size")
+		return
+	}
+
+	start := time.Now()
+	hash, err := filesystem.CalculateFileHashWithAlgorithm(file, algorithm)
+	if err != nil {
+		slog.Error("Failed to calculate hash", "error", err)
+		protocol.SendError(writer, "Failed to calculate hash")
+		return
+	}
+	slog.Info("File hash calculated for verification",
+		"algorithm", algorithm,
+		"size", info.Size(),
+		"duration", time.Since(start))
+
+	if err := protocol.SendCommand(writer, protocol.CmdHash); err != nil {
+		return
+	}
+	if err := protocol.SendInt64(writer, info.Size()); err != nil {
+		return
+	}
+	if err := protocol.SendString(writer, hash); err != nil {
+		return
+	}
+	protocol.FlushWriter(writer)
+}
+
+// servedFilePath returns the path of a completed file in the output directory
+// that may be sent to or verified by a client. Partial transfers, their state
+// files and hidden files are never served.
+func servedFilePath(name string, cfg *config.Config) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") ||
+		strings.HasSuffix(name, config.PartFileExt) || strings.HasSuffix(name, config.StateFileExt) ||
+		filesystem.ValidateFilePath(name) != nil {
+		return "", errors.NewValidationError("name", name, "invalid file name")
+	}
+
+	path := filepath.Join(cfg.OutputDir, name)
+	if activeDestinations.isLocked(path) {
+		return "", errors.NewValidationError("name", name, "file is being received")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", errors.NewValidationError("name", name, "file not found")
+	}
+
+	return path, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/network"
+)
+
+// reverseIdleConnections is the number of connections a reverse-connecting
+// server keeps waiting for the client, enough for a transfer and its network
+// profiling to start at the same time
+const reverseIdleConnections = 2
+
+// serveReverse connects out to the client at cfg.ReverseConnect instead of
+// accepting connections, keeping connections waiting until the client needs
+// them. Each is handled like an accepted connection once the client sends its
+// first command.
+func serveReverse(cfg *config.Config) error {
+	slog.Info("Server ready to connect to client", "address", cfg.ReverseConnect)
+
+	for i := 0; i < reverseIdleConnections; i++ {
+		go connectReverse(cfg)
+	}
+	select {} // Connections are replaced as they are used, so this never returns
+}
+
+// connectReverse keeps a connection waiting for the client, reconnecting with
+// exponential backoff while the client is unreachable. Once the client uses
+// the connection, another starts waiting in its place while it is handled.
+func connectReverse(cfg *config.Config) {
+	backoff := config.ReconnectMinBackoff
+
+	for {
+		conn, err := network.DialReverse(cfg.ReverseConnect, cfg.Timeout)
+		if err == nil {
+			go connectReverse(cfg)
+			handleConnection(conn, cfg)
+			return
+		}
+
+		slog.Debug("Client not reachable, retrying",
+			"address", cfg.ReverseConnect,
+			"error", err,
+			"backoff", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > config.ReconnectMaxBackoff {
+			backoff = config.ReconnectMaxBackoff
+		}
+	}
+}
@@ -14,22 +14,75 @@ import (
 	"justdatacopier/internal/compression"
 	"justdatacopier/internal/config"
 	"justdatacopier/internal/errors"
+	"justdatacopier/internal/events"
 	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/history"
+	"justdatacopier/internal/hooks"
 	"justdatacopier/internal/logging"
+	"justdatacopier/internal/metrics"
 	"justdatacopier/internal/network"
+	"justdatacopier/internal/notify"
 	"justdatacopier/internal/progress"
 	"justdatacopier/internal/protocol"
+	"justdatacopier/internal/ratelimit"
+	"justdatacopier/internal/relay"
+	"justdatacopier/internal/report"
 )
 
+// notifier delivers transfer events to the configured webhook, if any
+var notifier *notify.Notifier
+
+// progressOutput receives the JSON progress stream of all transfers, if enabled
+var progressOutput io.Writer
+
+// totalLimiter limits the bandwidth shared by all transfers of the server
+var totalLimiter *ratelimit.Limiter
+
+// forwarder forwards received files upstream in relay mode, nil otherwise
+var forwarder *relay.Forwarder
+
 // Run starts the server with the given configuration
 func Run(cfg *config.Config) error {
 	slog.Info("Starting server", "address", cfg.ListenAddress, "workers", cfg.Workers)
 
+	notifier = notify.New(cfg)
+	defer notifier.Close()
+
+	totalLimiter = ratelimit.New(cfg.TotalRateLimit, nil)
+	totalLimiter.SetSchedule(&cfg.TotalRateSchedule)
+
+	// Expose metrics and open the JSON progress stream if requested
+	if err := startMonitoring(cfg); err != nil {
+		return err
+	}
+
+	// Expose the admin API if requested
+	if cfg.AdminAddress != "" {
+		if err := serveAdmin(cfg.AdminAddress, cfg); err != nil {
+			return err
+		}
+	}
+
 	// Create output directory if it doesn't exist
 	if err := filesystem.EnsureDirectoryExists(cfg.OutputDir); err != nil {
 		return err
 	}
 
+	// In relay mode, forward received files upstream, starting with those
+	// spooled before a restart
+	if cfg.Command == config.CommandRelay {
+		forwarder = relay.New(cfg)
+		defer forwarder.Close()
+		if err := forwarder.Resume(); err != nil {
+			return err
+		}
+	}
+
+	// Connect out to the client instead of listening in reverse-connect mode
+	if cfg.ReverseConnect != "" {
+		return serveReverse(cfg)
+	}
+
 	// Start listener
 	listener, err := net.Listen("tcp", cfg.ListenAddress)
 	if err != nil {
@@ -51,6 +104,25 @@ func Run(cfg *config.Config) error {
 	}
 }
 
+// startMonitoring exposes metrics and opens the JSON progress stream, if configured
+func startMonitoring(cfg *config.Config) error {
+	if cfg.MetricsAddress != "" {
+		if err := metrics.Serve(cfg.MetricsAddress); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ProgressJSON != "" {
+		output, err := progress.OpenJSONOutput(cfg.ProgressJSON)
+		if err != nil {
+			return err
+		}
+		progressOutput = output
+	}
+
+	return nil
+}
+
 // handleConnection handles a single client connection
 func handleConnection(conn net.Conn, cfg *config.Config) {
 	defer conn.Close()
@@ -73,6 +145,9 @@ func handleConnection(conn net.Conn, cfg *config.Config) {
 	reader := bufio.NewReaderSize(conn, cfg.BufferSize)
 	writer := bufio.NewWriterSize(conn, cfg.BufferSize)
 
+	// Clients that do not announce a version speak the legacy protocol
+	version := int64(protocol.LegacyProtocolVersion)
+
 	// Handle commands in a loop
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
@@ -90,11 +165,27 @@ func handleConnection(conn net.Conn, cfg *config.Config) {
 		}
 
 		switch cmdByte {
+		case protocol.CmdVersion:
+			if version, err = readVersion(reader, cfg); err != nil {
+				slog.Error("Failed to read protocol version", "error", err)
+				return
+			}
 		case protocol.CmdInit:
-			handleFileTransfer(reader, writer, conn, cfg)
+			handleFileTransfer(reader, writer, conn, version, cfg)
 			return // Close connection after file transfer
 		case protocol.CmdPing:
 			handlePing(writer)
+		case protocol.CmdProbe:
+			if err := handleProbe(reader, writer, cfg); err != nil {
+				slog.Error("Bandwidth probe failed", "error", err)
+				return
+			}
+		case protocol.CmdGet:
+			handleGet(reader, writer, conn, cfg)
+			return // Close connection after file transfer
+		case protocol.CmdVerify:
+			handleVerify(reader, writer, conn, cfg)
+			return
 		default:
 			slog.Error("Unknown command", "command", cmdByte)
 			protocol.SendError(writer, "Unknown command")
@@ -103,6 +194,13 @@ func handleConnection(conn net.Conn, cfg *config.Config) {
 	}
 }
 
+// readVersion reads the protocol version a sender announces before CmdInit
+func readVersion(reader *bufio.Reader, cfg *config.Config) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	return protocol.ReadVersion(ctx, reader)
+}
+
 // handlePing responds to ping requests for network profiling
 func handlePing(writer *bufio.Writer) {
 	if err := protocol.SendCommand(writer, protocol.CmdPong); err != nil {
@@ -115,153 +213,387 @@ func handlePing(writer *bufio.Writer) {
 	}
 }
 
-// handleFileTransfer handles the complete file transfer process
-func handleFileTransfer(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, cfg *config.Config) {
-	ctx := context.Background()
+// handleProbe discards the bulk data of a bandwidth probe and acknowledges it
+// once received. The data counts against the server's total bandwidth limit,
+// so the client measures the bandwidth it can actually use.
+func handleProbe(reader *bufio.Reader, writer *bufio.Writer, cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	size, err := protocol.ReadInt64(ctx, reader)
+	if err != nil {
+		return err
+	}
+	if size <= 0 || size > protocol.MaxProbeSize {
+		protocol.SendError(writer, "Invalid probe size")
+		return errors.NewValidationError("probe_size", size, "out of range")
+	}
+
+	buffer := make([]byte, config.LargeWriteSize)
+	for remaining := size; remaining > 0; {
+		n, err := reader.Read(buffer[:min(int64(len(buffer)), remaining)])
+		if err != nil {
+			return errors.NewNetworkError("probe", "", err)
+		}
+		remaining -= int64(n)
+		if err := totalLimiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	if err := protocol.SendCommand(writer, protocol.CmdPong); err != nil {
+		return err
+	}
+	return protocol.FlushWriter(writer)
+}
+
+// transferRecord collects the details of a single incoming transfer for
+// reporting once it has finished
+type transferRecord struct {
+	TransferID    string
+	SourceName    string
+	Filename      string
+	Path          string
+	Size          int64
+	ChunkSize     int64
+	HashAlgorithm protocol.HashAlgorithm
+	Hash          string
+	SourceHash    string
+	HashVerified  bool
+	RemoteAddr    string
+	StartTime     time.Time
+	Stats         *progress.Stats
+
+	// destination is the locked output path, held until the completion actions
+	// have run so that they see the file as this transfer left it
+	destination string
+}
 
+// handleFileTransfer handles the complete file transfer process for a sender
+// speaking the given protocol version
+func handleFileTransfer(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, version int64, cfg *config.Config) error {
+	record := &transferRecord{
+		RemoteAddr: conn.RemoteAddr().String(),
+		StartTime:  time.Now(),
+	}
+
+	// The context can be cancelled through the admin API
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := errors.WithTransferID(receiveFile(ctx, reader, writer, conn, cancel, record, version, cfg), record.TransferID)
+	finishTransfer(logging.WithTransferID(context.Background(), record.TransferID), record, err, cfg)
+	if record.destination != "" {
+		activeDestinations.unlock(record.destination)
+	}
+	return err
+}
+
+// receiveFile receives a file from the client, filling in the transfer record as it goes
+func receiveFile(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, conn net.Conn,
+	cancel context.CancelFunc, record *transferRecord, version int64, cfg *config.Config) error {
 	// Read filename
 	filename, err := protocol.ReadString(ctx, reader)
 	if err != nil {
-		slog.Error("Failed to read filename", "error", err)
+		slog.ErrorContext(ctx, "Failed to read filename", "error", err)
 		protocol.SendError(writer, "Failed to read filename")
-		return
+		return err
 	}
 
 	baseFilename := filepath.Base(filename)
-	slog.Info("Receiving file", "file_size_mb", "pending")
+	record.SourceName = baseFilename
+	record.Filename = baseFilename
 
 	// Read file size
 	fileSize, err := protocol.ReadInt64(ctx, reader)
 	if err != nil {
-		slog.Error("Failed to read file size", "error", err)
+		slog.ErrorContext(ctx, "Failed to read file size", "error", err)
 		protocol.SendError(writer, "Failed to read file size")
-		return
+		return err
 	}
 
 	// Read client's hash verification preference
 	clientWantsVerification, err := protocol.ReadBool(ctx, reader)
 	if err != nil {
-		slog.Error("Failed to read client verification preference", "error", err)
+		slog.ErrorContext(ctx, "Failed to read client verification preference", "error", err)
 		protocol.SendError(writer, "Failed to read verification preference")
-		return
+		return err
+	}
+
+	// Legacy clients do not send a transfer ID, so the server names the transfer
+	transferID := protocol.NewTransferID()
+	if version >= protocol.TransferIDProtocolVersion {
+		// Read the transfer ID generated by the client
+		if transferID, err = protocol.ReadString(ctx, reader); err != nil {
+			slog.ErrorContext(ctx, "Failed to read transfer ID", "error", err)
+			protocol.SendError(writer, "Failed to read transfer ID")
+			return err
+		}
+		if !protocol.ValidTransferID(transferID) {
+			slog.ErrorContext(ctx, "Invalid transfer ID")
+			protocol.SendError(writer, "Invalid transfer ID")
+			return errors.NewValidationError("transfer_id", transferID, "invalid transfer ID")
+		}
+	}
+	record.TransferID = transferID
+	ctx = logging.WithTransferID(ctx, transferID)
+
+	// Older clients do not send a chunk size, so the server requests chunks of
+	// its own size
+	chunkSize := cfg.ChunkSize
+	if version >= protocol.ChunkSizeProtocolVersion {
+		// Read the client's chunk size, which chunk offsets are based on
+		if chunkSize, err = protocol.ReadInt64(ctx, reader); err != nil {
+			slog.ErrorContext(ctx, "Failed to read chunk size", "error", err)
+			protocol.SendError(writer, "Failed to read chunk size")
+			return err
+		}
+	}
+
+	// Register the transfer so it can be listed and cancelled through the admin API
+	transfer, err := activeTransfers.register(transferID, conn, cancel)
+	if err != nil {
+		slog.ErrorContext(ctx, "Transfer rejected", "error", err)
+		protocol.SendError(writer, err.Error())
+		return err
 	}
+	defer activeTransfers.remove(transfer)
+
+	slog.InfoContext(ctx, "Receiving file", "file_size_mb", float64(fileSize)/(1024*1024))
 
 	// Validate file size
 	if fileSize <= 0 {
-		slog.Error("Invalid file size", "size", fileSize)
+		slog.ErrorContext(ctx, "Invalid file size", "size", fileSize)
 		protocol.SendError(writer, "Invalid file size")
-		return
+		return errors.NewValidationError("file_size", fileSize, "file size must be positive")
+	}
+	record.Size = fileSize
+
+	// Validate chunk size and use the client's for this transfer. Each transfer
+	// buffers a chunk, so the server bounds what a client can make it allocate.
+	if chunkSize <= 0 || chunkSize > cfg.AcceptedChunkSize() {
+		slog.ErrorContext(ctx, "Invalid chunk size", "size", chunkSize, "max_chunk", cfg.AcceptedChunkSize())
+		protocol.SendError(writer, fmt.Sprintf("Chunk size must be between 1 and %d bytes", cfg.AcceptedChunkSize()))
+		return errors.NewValidationError("chunk_size", chunkSize, "chunk size out of range")
 	}
+	if chunkSize != cfg.ChunkSize {
+		slog.InfoContext(ctx, "Using client chunk size", "chunk_size_mb", float64(chunkSize)/(1024*1024))
+		transferCfg := *cfg
+		transferCfg.ChunkSize = chunkSize
+		cfg = &transferCfg
+	}
+
+	logging.LogSessionStart(ctx, "SERVER", fileSize, cfg.ChunkSize, cfg.Workers)
+	notifier.Send(record.event(events.SessionStart))
 
-	logging.LogSessionStart("SERVER", fileSize, cfg.ChunkSize, cfg.Workers)
+	metrics.ActiveTransfers.Add(1)
+	defer metrics.ActiveTransfers.Add(-1)
 
 	// Determine if hash verification should be performed
 	// Only verify if BOTH client and server want verification
 	shouldVerifyHash := cfg.VerifyHash && clientWantsVerification
 
-	slog.Info("Hash verification settings",
+	slog.InfoContext(ctx, "Hash verification settings",
 		"server_wants_verification", cfg.VerifyHash,
 		"client_wants_verification", clientWantsVerification,
 		"will_verify", shouldVerifyHash)
 
-	// Setup transfer state
+	// Apply the collision policy and lock the destination against concurrent transfers
+	destFilename, err := resolveDestination(baseFilename, transferID, cfg)
+	if err != nil {
+		slog.ErrorContext(ctx, "Destination rejected", "policy", cfg.CollisionPolicy, "error", err)
+		protocol.SendError(writer, err.Error())
+		return err
+	}
+	if destFilename != baseFilename {
+		slog.InfoContext(ctx, "Destination exists, storing under a new name", "policy", cfg.CollisionPolicy)
+	}
+	baseFilename = destFilename
+	record.Filename = baseFilename
+	transfer.setFile(baseFilename, fileSize)
+
+	// Setup transfer state. Data is written to a temporary part file next to the
+	// state file and only renamed to outputPath once the transfer is complete.
 	outputPath := filepath.Join(cfg.OutputDir, baseFilename)
+	record.destination = outputPath
+	partPath := filesystem.PartFilePath(outputPath)
 	numChunks := (fileSize + cfg.ChunkSize - 1) / cfg.ChunkSize
 
+	// In relay mode, the file is forwarded upstream as its chunks arrive
+	var spooled *relay.File
+	if forwarder != nil {
+		if spooled, err = forwarder.Receive(transferID, baseFilename, fileSize, cfg.ChunkSize); err != nil {
+			slog.ErrorContext(ctx, "Transfer rejected", "error", err)
+			protocol.SendError(writer, err.Error())
+			return err
+		}
+		defer spooled.Detach()
+	}
+
 	// Try to resume existing transfer
-	transferState, resuming := tryResumeTransfer(baseFilename, cfg, fileSize, numChunks)
+	transferState, resuming := tryResumeTransfer(ctx, transferID, baseFilename, partPath, cfg, fileSize, numChunks)
 
 	// Send resume information to client
 	if err := sendResumeInfoToClient(writer, transferState, resuming, numChunks); err != nil {
-		slog.Error("Failed to send resume info", "error", err)
+		slog.ErrorContext(ctx, "Failed to send resume info", "error", err)
 		protocol.SendError(writer, "Resume negotiation failed")
-		return
+		return err
 	}
 
 	// Wait for client's resume decision
 	clientAcceptsResume, err := waitForResumeDecision(ctx, reader)
 	if err != nil {
-		slog.Error("Failed to read resume decision", "error", err)
+		slog.ErrorContext(ctx, "Failed to read resume decision", "error", err)
 		protocol.SendError(writer, "Resume negotiation failed")
-		return
+		return err
 	}
 
 	// If client doesn't accept resume, start fresh
-	if resuming && !clientAcceptsResume {
-		slog.Info("Client rejected resume, starting fresh transfer")
+	rejected := resuming && !clientAcceptsResume
+	if rejected {
+		slog.InfoContext(ctx, "Client rejected resume, starting fresh transfer")
 		resuming = false
-		transferState = nil
+		transferState = newTransferState(transferID, baseFilename, cfg, fileSize, numChunks)
+	}
+
+	// In relay mode, forwarding continues from the chunks kept, reopening the
+	// part file as it is recreated below for a fresh transfer
+	if spooled != nil {
+		spooled.SetReceived(transferState.ChunksReceived)
+	}
+
+	if rejected {
 		// Remove the existing partial file and state
-		if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
-			slog.Warn("Failed to remove partial file", "error", err)
+		if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+			slog.WarnContext(ctx, "Failed to remove partial file", "error", err)
 		}
 		if err := filesystem.RemoveTransferState(baseFilename, cfg.OutputDir); err != nil {
-			slog.Warn("Failed to remove transfer state", "error", err)
+			slog.WarnContext(ctx, "Failed to remove transfer state", "error", err)
 		}
 	}
 
-	// Create or open output file
-	outFile, err := createOrOpenOutputFile(outputPath, resuming)
+	// Create or open the temporary output file
+	outFile, err := createOrOpenOutputFile(partPath, resuming)
 	if err != nil {
-		slog.Error("Failed to create output file", "error", err)
+		slog.ErrorContext(ctx, "Failed to create output file", "error", err)
 		protocol.SendError(writer, "File creation failed")
-		return
+		return errors.NewFileSystemError("create", partPath, err)
 	}
 	defer outFile.Close()
 
 	// Pre-allocate file space if not resuming
 	if !resuming {
 		if err := filesystem.PreallocateFile(outFile, fileSize); err != nil {
-			slog.Warn("Failed to preallocate file space", "error", err)
+			slog.WarnContext(ctx, "Failed to preallocate file space", "error", err)
 		}
 	}
 
 	// Initialize progress tracking
 	stats := &progress.Stats{
-		TotalBytes: fileSize,
-		StartTime:  time.Now(),
-		FileSize:   fileSize,
-		Filename:   baseFilename,
+		TotalBytes:  fileSize,
+		StartTime:   record.StartTime,
+		FileSize:    fileSize,
+		Filename:    baseFilename,
+		TotalChunks: numChunks,
+		TransferID:  transferID,
 	}
 
 	if resuming {
 		resumeOffset := calculateResumeOffset(transferState)
 		stats.SetTransferred(resumeOffset)
-		slog.Info("Resuming transfer", "offset_mb", float64(resumeOffset)/(1024*1024))
+		stats.ResumedChunks = transferState.CountReceivedChunks()
+		stats.ResumedBytes = resumeOffset
+		stats.CompletedChunks.Store(stats.ResumedChunks)
+		slog.InfoContext(ctx, "Resuming transfer", "offset_mb", float64(resumeOffset)/(1024*1024))
 	}
+	transfer.setStats(stats)
+	record.ChunkSize = cfg.ChunkSize
+	record.Stats = stats
 
 	// Start progress reporting
 	var reporter *progress.Reporter
-	if cfg.ShowProgress {
-		reporter = progress.NewReporter(stats, cfg.ShowProgress)
+	if cfg.ShowProgress || progressOutput != nil {
+		reporter = progress.NewReporter(stats, cfg.ShowProgress && cfg.ProgressJSON != config.ProgressStdout)
+		if progressOutput != nil {
+			reporter.SetJSONOutput(progressOutput)
+		}
 		reporter.Start()
 		defer reporter.Stop()
 	}
 
-	// Setup network statistics
-	netStats := network.NewNetworkStats(cfg)
-
-	// Process chunks sequentially
-	if err := processChunks(ctx, reader, writer, outFile, transferState, stats, netStats, cfg); err != nil {
-		slog.Error("Chunk processing failed", "error", err)
+	// Report progress milestones to the webhook
+	stopProgressEvents := notifier.WatchProgress(stats, record.event(events.TransferProgress))
+	defer stopProgressEvents()
+
+	// Request chunks as the congestion controller allows, within the transfer's
+	// and the server's rate limits
+	controller := network.NewController(cfg)
+	limiter := ratelimit.New(cfg.RateLimit, totalLimiter)
+	limiter.SetSchedule(&cfg.RateSchedule)
+	var onReceived func(chunk int64)
+	if spooled != nil {
+		onReceived = spooled.ChunkReceived
+	}
+	if err := processChunks(ctx, reader, writer, outFile, transferState, stats, controller, limiter, onReceived, cfg); err != nil {
+		slog.ErrorContext(ctx, "Chunk processing failed", "error", err)
 		protocol.SendError(writer, "Transfer failed")
-		return
+		return err
 	}
 
 	// Verify file hash if both client and server want verification
 	if shouldVerifyHash {
-		if err := verifyFileHash(ctx, reader, writer, outFile, fileSize); err != nil {
-			slog.Error("Hash verification failed", "error", err)
-			os.Remove(outputPath)
+		check, err := verifyFileHash(ctx, reader, writer, outFile, fileSize)
+		record.HashAlgorithm = check.Algorithm
+		record.SourceHash = check.SourceHash
+		record.Hash = check.ReceivedHash
+		if err != nil {
+			slog.ErrorContext(ctx, "Hash verification failed", "error", err)
+			outFile.Close()
+			replaceSpooled(spooled, func() error { return os.Remove(partPath) })
+			filesystem.RemoveTransferState(baseFilename, cfg.OutputDir)
 			protocol.SendError(writer, "Hash verification failed")
-			return
+			return err
 		}
+		record.HashVerified = true
 	} else {
-		slog.Info("Skipping hash verification",
+		slog.InfoContext(ctx, "Skipping hash verification",
 			"server_verify_setting", cfg.VerifyHash,
 			"client_verify_setting", clientWantsVerification)
 	}
 
+	finalize := filesystem.FinalizeFile
+	if cfg.CollisionPolicy == config.CollisionFail {
+		// A file created at the destination during the transfer is not replaced either
+		finalize = filesystem.FinalizeFileNoReplace
+	} else if err := rotateExistingVersion(ctx, outputPath, cfg); err != nil {
+		// Keep the file being replaced as a previous version
+		slog.ErrorContext(ctx, "Failed to rotate previous version", "error", err)
+		protocol.SendError(writer, "Version rotation failed")
+		return err
+	}
+
+	// Atomically move the completed file into place
+	if err := replaceSpooled(spooled, func() error { return finalize(outFile, outputPath) }); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			slog.ErrorContext(ctx, "Destination created during the transfer", "policy", cfg.CollisionPolicy)
+			replaceSpooled(spooled, func() error { return os.Remove(partPath) })
+			filesystem.RemoveTransferState(baseFilename, cfg.OutputDir)
+			err = errors.NewValidationError("filename", baseFilename, "destination file already exists")
+			protocol.SendError(writer, err.Error())
+			return err
+		}
+		slog.ErrorContext(ctx, "Failed to finalize file", "error", err)
+		protocol.SendError(writer, "File finalization failed")
+		return err
+	}
+	record.Path = outputPath
+	if absPath, err := filepath.Abs(outputPath); err == nil {
+		record.Path = absPath
+	}
+	if spooled != nil {
+		spooled.Complete()
+	}
+
 	// Cleanup and complete
 	filesystem.RemoveTransferState(baseFilename, cfg.OutputDir)
 
@@ -270,39 +602,191 @@ func handleFileTransfer(reader *bufio.Reader, writer *bufio.Writer, conn net.Con
 	}
 
 	elapsed := time.Since(stats.StartTime)
-	logging.LogTransferComplete(baseFilename, fileSize, elapsed)
+	logging.LogTransferComplete(ctx, baseFilename, fileSize, elapsed)
+	return nil
+}
+
+// replaceSpooled runs replace, which moves or removes the received file, with
+// the file closed by the forwarding leg in relay mode
+func replaceSpooled(spooled *relay.File, replace func() error) error {
+	if spooled == nil {
+		return replace()
+	}
+	return spooled.Replace(replace)
+}
+
+// event builds an event describing the transfer in its current state
+func (r *transferRecord) event(eventType string) events.Event {
+	return events.Event{
+		Type:            eventType,
+		Role:            events.RoleServer,
+		Timestamp:       time.Now(),
+		TransferID:      r.TransferID,
+		Filename:        r.Filename,
+		Path:            r.Path,
+		Size:            r.Size,
+		HashAlgorithm:   string(r.HashAlgorithm),
+		Hash:            r.Hash,
+		RemoteAddr:      r.RemoteAddr,
+		DurationSeconds: time.Since(r.StartTime).Seconds(),
+	}
+}
+
+// finishTransfer runs the completion or failure actions for a finished transfer
+func finishTransfer(ctx context.Context, record *transferRecord, transferErr error, cfg *config.Config) {
+	event := record.event(events.TransferComplete)
+
+	hook := cfg.OnCompleteHook
+	if transferErr != nil {
+		event.Type = events.TransferFailed
+		event.Error = transferErr.Error()
+		event.ErrorCategory = errors.Category(transferErr)
+		hook = cfg.OnFailureHook
+		metrics.TransfersFailed.Inc()
+	} else {
+		metrics.TransfersCompleted.Inc()
+	}
+
+	notifier.Send(event)
+
+	if cfg.History {
+		if err := history.Append(cfg.HistoryPath(), record.historyEntry(transferErr)); err != nil {
+			slog.ErrorContext(ctx, "Failed to record transfer history", "error", err)
+		}
+	}
+
+	if cfg.ReportDir != "" {
+		if _, err := report.Write(cfg.ReportDir, record.report(transferErr)); err != nil {
+			slog.ErrorContext(ctx, "Failed to write transfer report", "error", err)
+		}
+	}
+
+	if err := hooks.Run(hook, event, cfg.HookTimeout); err != nil {
+		slog.ErrorContext(ctx, "Transfer hook failed", "event", event.Type, "error", err)
+	}
+}
+
+// historyEntry builds the history record of the finished transfer
+func (r *transferRecord) historyEntry(transferErr error) history.Entry {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+
+	entry := history.Entry{
+		Time:            time.Now(),
+		StartTime:       r.StartTime,
+		Status:          history.StatusCompleted,
+		TransferID:      r.TransferID,
+		Filename:        r.SourceName,
+		Destination:     r.Path,
+		Client:          client,
+		Size:            r.Size,
+		HashAlgorithm:   string(r.HashAlgorithm),
+		Hash:            r.Hash,
+		Verified:        r.HashVerified,
+		DurationSeconds: time.Since(r.StartTime).Seconds(),
+	}
+
+	if transferErr != nil {
+		entry.Status = history.StatusFailed
+		entry.Error = transferErr.Error()
+		entry.ErrorCategory = errors.Category(transferErr)
+	}
+
+	return entry
 }
 
-// tryResumeTransfer attempts to resume an existing transfer
-func tryResumeTransfer(filename string, cfg *config.Config, fileSize, numChunks int64) (*filesystem.TransferState, bool) {
+// report builds the end-of-transfer report
+func (r *transferRecord) report(transferErr error) *report.Report {
+	rep := report.New(events.RoleServer, r.StartTime)
+	rep.TransferID = r.TransferID
+	rep.SourceName = r.SourceName
+	rep.DestinationName = r.Filename
+	rep.DestinationPath = r.Path
+	rep.RemoteAddr = r.RemoteAddr
+	rep.Size = r.Size
+	rep.ChunkSize = r.ChunkSize
+
+	if r.Stats != nil {
+		rep.AddStats(r.Stats)
+	}
+
+	if r.HashAlgorithm != "" {
+		rep.Hash = &report.Hash{
+			Algorithm:       string(r.HashAlgorithm),
+			SourceHash:      r.SourceHash,
+			DestinationHash: r.Hash,
+			Verified:        r.HashVerified,
+		}
+	}
+
+	rep.Finish(transferErr, time.Now())
+	return rep
+}
+
+// rotateExistingVersion moves an existing destination file into the versions
+// directory and applies the retention limits
+func rotateExistingVersion(ctx context.Context, outputPath string, cfg *config.Config) error {
+	if cfg.KeepVersions <= 0 || !filesystem.FileExists(outputPath) {
+		return nil
+	}
+
+	now := time.Now()
+	versionsDir := cfg.VersionsPath()
+
+	if _, err := filesystem.RotateVersion(outputPath, versionsDir, now); err != nil {
+		return err
+	}
+
+	if err := filesystem.PruneVersions(versionsDir, filepath.Base(outputPath), cfg.KeepVersions, cfg.VersionsMaxAge, now); err != nil {
+		slog.WarnContext(ctx, "Failed to prune previous versions", "error", err)
+	}
+
+	slog.InfoContext(ctx, "Previous version rotated", "keep_versions", cfg.KeepVersions)
+	return nil
+}
+
+// tryResumeTransfer attempts to resume an existing transfer. A resumed state
+// takes over the ID of the transfer continuing it.
+func tryResumeTransfer(ctx context.Context, transferID, filename, partPath string, cfg *config.Config,
+	fileSize, numChunks int64) (*filesystem.TransferState, bool) {
 	state, err := filesystem.LoadTransferState(filename, cfg.OutputDir)
+	if err == nil {
+		// A state file without its part file cannot be resumed
+		if _, statErr := os.Stat(partPath); statErr != nil {
+			slog.WarnContext(ctx, "Transfer state found without partial file, starting fresh")
+			err = statErr
+		}
+	}
 	if err != nil {
 		// No existing state, start fresh
-		return &filesystem.TransferState{
-			Filename:       filename,
-			FileSize:       fileSize,
-			ChunkSize:      cfg.ChunkSize,
-			NumChunks:      numChunks,
-			ChunksReceived: make([]bool, numChunks),
-		}, false
+		return newTransferState(transferID, filename, cfg, fileSize, numChunks), false
 	}
 
 	// Validate state compatibility
 	if state.FileSize == fileSize &&
 		state.ChunkSize == cfg.ChunkSize &&
 		len(state.ChunksReceived) == int(numChunks) {
-		slog.Info("Found compatible transfer state, resuming")
+		slog.InfoContext(ctx, "Found compatible transfer state, resuming", "previous_transfer_id", state.TransferID)
+		state.TransferID = transferID
 		return state, true
 	}
 
-	slog.Warn("Incompatible transfer state found, starting fresh")
+	slog.WarnContext(ctx, "Incompatible transfer state found, starting fresh")
+	return newTransferState(transferID, filename, cfg, fileSize, numChunks), false
+}
+
+// newTransferState creates an empty transfer state for a fresh transfer
+func newTransferState(transferID, filename string, cfg *config.Config, fileSize, numChunks int64) *filesystem.TransferState {
 	return &filesystem.TransferState{
 		Filename:       filename,
+		TransferID:     transferID,
 		FileSize:       fileSize,
 		ChunkSize:      cfg.ChunkSize,
 		NumChunks:      numChunks,
 		ChunksReceived: make([]bool, numChunks),
-	}, false
+	}
 }
 
 // createOrOpenOutputFile creates a new file or opens existing for resume
@@ -321,114 +805,170 @@ func createOrOpenOutputFile(path string, resuming bool) (*os.File, error) {
 
 // calculateResumeOffset calculates the byte offset for resume
 func calculateResumeOffset(state *filesystem.TransferState) int64 {
-	var receivedChunks int64
-	for _, received := range state.ChunksReceived {
-		if received {
-			receivedChunks++
-		}
-	}
-	return receivedChunks * state.ChunkSize
+	return state.CountReceivedChunks() * state.ChunkSize
 }
 
-// processChunks handles the sequential processing of file chunks
+// processChunks requests the missing chunks of the file and writes them as they
+// arrive. The controller decides how many requests are in flight and paces
+// them; the client answers requests in order. onReceived, if set, is called
+// with the index of each chunk once it is written.
 func processChunks(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer,
 	outFile *os.File, state *filesystem.TransferState, stats *progress.Stats,
-	netStats *network.NetworkStats, cfg *config.Config) error {
+	controller *network.Controller, limiter *ratelimit.Limiter, onReceived func(chunk int64), cfg *config.Config) error {
 
 	buffer := make([]byte, cfg.ChunkSize)
 
-	// Process each chunk
-	for chunkIdx := int64(0); chunkIdx < state.NumChunks; chunkIdx++ {
+	var pending []pendingChunk // Requested chunks, in the order they arrive
+	var retries []int64        // Failed chunks to request again
+	attempts := make(map[int64]int)
+	nextChunk := int64(0)
+
+	for {
 		if ctx.Err() != nil {
 			// Save state before returning on cancellation
 			filesystem.SaveTransferState(state, cfg.OutputDir)
 			return ctx.Err()
 		}
 
-		// Skip already received chunks - don't request them from client
-		if state.ChunksReceived[chunkIdx] {
-			// Skip this chunk entirely, already completed
-			continue
+		// Request chunks while the controller allows more in flight
+		requested := false
+		for controller.CanSend() {
+			var chunkIdx int64
+			switch {
+			case len(retries) > 0:
+				chunkIdx = retries[0]
+			default:
+				// Skip already received chunks - don't request them from client
+				for nextChunk < state.NumChunks && state.ChunksReceived[nextChunk] {
+					nextChunk++
+				}
+				chunkIdx = nextChunk
+			}
+			if chunkIdx >= state.NumChunks {
+				break
+			}
+
+			if wait := time.Until(controller.NextSend()); wait > 0 {
+				// Receive what is in flight rather than wait for the next request
+				if len(pending) > 0 {
+					break
+				}
+				if err := sleepContext(ctx, wait); err != nil {
+					filesystem.SaveTransferState(state, cfg.OutputDir)
+					return err
+				}
+			}
+
+			if err := requestChunk(writer, chunkIdx*cfg.ChunkSize); err != nil {
+				filesystem.SaveTransferState(state, cfg.OutputDir)
+				return errors.NewNetworkError("request_chunk", "", err)
+			}
+			pending = append(pending, pendingChunk{index: chunkIdx, request: controller.OnSend(time.Now())})
+			requested = true
+
+			if len(retries) > 0 && retries[0] == chunkIdx {
+				retries = retries[1:]
+			} else {
+				nextChunk++
+			}
 		}
 
-		offset := chunkIdx * cfg.ChunkSize
+		if requested {
+			if err := protocol.FlushWriter(writer); err != nil {
+				filesystem.SaveTransferState(state, cfg.OutputDir)
+				return errors.NewNetworkError("request_chunk", "", err)
+			}
+		}
 
-		// Apply network delay
-		if cfg.AdaptiveDelay {
-			delay := netStats.GetDelay(cfg.ChunkDelay)
-			time.Sleep(delay)
-		} else if cfg.ChunkDelay > 0 {
-			time.Sleep(cfg.ChunkDelay)
+		if len(pending) == 0 {
+			return nil
 		}
 
-		// Process chunk with retries
-		actualSize, err := receiveChunkWithRetries(ctx, reader, writer, outFile,
-			offset, cfg.ChunkSize, buffer, stats, cfg)
+		chunk := pending[0]
+		pending = pending[1:]
+		offset := chunk.index * cfg.ChunkSize
+
+		actualSize, err := receiveChunk(ctx, reader, outFile, offset, cfg.ChunkSize, buffer, stats, limiter)
 		if err != nil {
-			// Save state before returning on error
-			filesystem.SaveTransferState(state, cfg.OutputDir)
-			return err
+			controller.OnLost()
+			attempts[chunk.index]++
+			slog.WarnContext(ctx, "Chunk receive failed", "offset", offset, "retry", attempts[chunk.index], "error", err)
+			// A chunk not read to its end leaves the stream out of step, so the
+			// transfer ends and the client reconnects to resume it
+			if !chunkRetryable(err) || attempts[chunk.index] >= cfg.Retries {
+				// Save state before returning on error
+				filesystem.SaveTransferState(state, cfg.OutputDir)
+				return errors.NewNetworkError("receive_chunk", "", err)
+			}
+
+			// Exponential backoff for retries
+			backoff := time.Duration(attempts[chunk.index]*500) * time.Millisecond
+			if err := sleepContext(ctx, backoff); err != nil {
+				filesystem.SaveTransferState(state, cfg.OutputDir)
+				return err
+			}
+			slog.DebugContext(ctx, "Retrying chunk", "offset", offset, "attempt", attempts[chunk.index]+1)
+			metrics.ChunkRetries.Inc()
+			stats.AddRetry(chunk.index)
+			retries = append(retries, chunk.index)
+			continue
 		}
 
+		controller.OnDelivered(chunk.request, actualSize, time.Now())
+
 		// Mark chunk as received
-		state.ChunksReceived[chunkIdx] = true
-		netStats.UpdateStats(actualSize)
+		state.ChunksReceived[chunk.index] = true
+		stats.ChunkCompleted()
+		if onReceived != nil {
+			onReceived(chunk.index)
+		}
 
 		// Save state immediately after each chunk for resilience
 		if err := filesystem.SaveTransferState(state, cfg.OutputDir); err != nil {
-			slog.Error("Failed to save transfer state", "chunk", chunkIdx, "error", err)
+			slog.ErrorContext(ctx, "Failed to save transfer state", "chunk", chunk.index, "error", err)
 		}
 	}
-
-	return nil
 }
 
-// receiveChunkWithRetries receives a chunk with retry logic
-func receiveChunkWithRetries(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer,
-	file *os.File, offset, chunkSize int64, buffer []byte, stats *progress.Stats, cfg *config.Config) (int64, error) {
-
-	var lastErr error
-
-	for retry := 0; retry < cfg.Retries; retry++ {
-		if ctx.Err() != nil {
-			return 0, ctx.Err()
-		}
+// chunkRetryable reports whether a chunk that failed can be requested again on
+// the same connection, which is only the case when it was read to its end
+func chunkRetryable(err error) bool {
+	var compressionErr *errors.CompressionError
+	var fsErr *errors.FileSystemError
+	return errors.As(err, &compressionErr) || errors.As(err, &fsErr)
+}
 
-		// Exponential backoff for retries
-		if retry > 0 {
-			backoff := time.Duration(retry*500) * time.Millisecond
-			time.Sleep(backoff)
-			slog.Debug("Retrying chunk", "offset", offset, "attempt", retry+1)
-		}
+// pendingChunk is a chunk requested from the client but not yet received
+type pendingChunk struct {
+	index   int64
+	request network.Request
+}
 
-		actualSize, err := receiveChunk(ctx, reader, writer, file, offset, chunkSize, buffer, stats, cfg)
-		if err == nil {
-			return actualSize, nil
-		}
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-		lastErr = err
-		slog.Warn("Chunk receive failed", "offset", offset, "retry", retry+1, "error", err)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	return 0, errors.NewNetworkError("receive_chunk", "", lastErr)
 }
 
-// receiveChunk receives a single chunk from the client
-func receiveChunk(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer,
-	file *os.File, offset, chunkSize int64, buffer []byte, stats *progress.Stats, cfg *config.Config) (int64, error) {
-
-	// Send chunk request
+// requestChunk asks the client for the chunk at offset. The request is sent
+// when the writer is flushed.
+func requestChunk(writer *bufio.Writer, offset int64) error {
 	if err := protocol.SendCommand(writer, protocol.CmdRequest); err != nil {
-		return 0, err
+		return err
 	}
+	return protocol.SendInt64(writer, offset)
+}
 
-	if err := protocol.SendInt64(writer, offset); err != nil {
-		return 0, err
-	}
-
-	if err := protocol.FlushWriter(writer); err != nil {
-		return 0, err
-	}
+// receiveChunk receives a single requested chunk from the client
+func receiveChunk(ctx context.Context, reader *bufio.Reader, file *os.File, offset, chunkSize int64,
+	buffer []byte, stats *progress.Stats, limiter *ratelimit.Limiter) (int64, error) {
 
 	// Read response
 	cmdByte, err := protocol.ReadCommand(ctx, reader)
@@ -446,7 +986,8 @@ func receiveChunk(ctx context.Context, reader *bufio.Reader, writer *bufio.Write
 		return 0, err
 	}
 
-	if actualChunkSize <= 0 || actualChunkSize > chunkSize {
+	// Every chunk but the last must be full, otherwise the file would have gaps
+	if actualChunkSize != min(chunkSize, stats.FileSize-offset) {
 		return 0, errors.NewProtocolError("receive_chunk", "invalid chunk size", nil)
 	}
 
@@ -460,10 +1001,10 @@ func receiveChunk(ctx context.Context, reader *bufio.Reader, writer *bufio.Write
 
 	if compressFlag == 1 {
 		// Handle compressed data
-		data, err = receiveCompressedChunk(ctx, reader, int(actualChunkSize))
+		data, err = receiveCompressedChunk(ctx, reader, int(actualChunkSize), stats, limiter)
 	} else {
 		// Handle uncompressed data
-		data, err = receiveUncompressedChunk(ctx, reader, buffer, int(actualChunkSize))
+		data, err = receiveUncompressedChunk(ctx, reader, buffer, int(actualChunkSize), limiter)
 	}
 
 	if err != nil {
@@ -476,17 +1017,24 @@ func receiveChunk(ctx context.Context, reader *bufio.Reader, writer *bufio.Write
 	}
 
 	stats.UpdateTransferred(actualChunkSize)
+	metrics.BytesReceived.Add(actualChunkSize)
 	return actualChunkSize, nil
 }
 
 // receiveCompressedChunk receives and decompresses chunk data
-func receiveCompressedChunk(ctx context.Context, reader *bufio.Reader, expectedSize int) ([]byte, error) {
+func receiveCompressedChunk(ctx context.Context, reader *bufio.Reader, expectedSize int, stats *progress.Stats,
+	limiter *ratelimit.Limiter) ([]byte, error) {
 	// Read compressed size
 	compressedSize, err := protocol.ReadInt64(ctx, reader)
 	if err != nil {
 		return nil, err
 	}
 
+	// The size comes from the client, so check it before allocating for it
+	if compressedSize <= 0 || compressedSize > compression.MaxCompressedSize(int64(expectedSize)) {
+		return nil, errors.NewProtocolError("receive_chunk", "invalid compressed size", nil)
+	}
+
 	// Read compressed data
 	compressedData := make([]byte, compressedSize)
 	bytesRead := int64(0)
@@ -497,6 +1045,10 @@ func receiveCompressedChunk(ctx context.Context, reader *bufio.Reader, expectedS
 			return nil, err
 		}
 		bytesRead += int64(n)
+
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return nil, err
+		}
 	}
 
 	// Decompress data
@@ -505,11 +1057,16 @@ func receiveCompressedChunk(ctx context.Context, reader *bufio.Reader, expectedS
 		return nil, err
 	}
 
+	metrics.CompressionInputBytes.Add(int64(len(data)))
+	metrics.CompressionOutputBytes.Add(compressedSize)
+	stats.AddCompression(int64(len(data)), compressedSize)
+
 	return data, nil
 }
 
 // receiveUncompressedChunk receives uncompressed chunk data
-func receiveUncompressedChunk(ctx context.Context, reader *bufio.Reader, buffer []byte, size int) ([]byte, error) {
+func receiveUncompressedChunk(ctx context.Context, reader *bufio.Reader, buffer []byte, size int,
+	limiter *ratelimit.Limiter) ([]byte, error) {
 	bytesRead := 0
 
 	for bytesRead < size {
@@ -518,73 +1075,89 @@ func receiveUncompressedChunk(ctx context.Context, reader *bufio.Reader, buffer
 			return nil, err
 		}
 		bytesRead += n
+
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return nil, err
+		}
 	}
 
 	return buffer[:size], nil
 }
 
-// verifyFileHash verifies the integrity of the received file using size-based algorithm selection
-func verifyFileHash(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File, fileSize int64) error {
+// hashCheck is the outcome of comparing the source and received file hashes
+type hashCheck struct {
+	Algorithm    protocol.HashAlgorithm
+	SourceHash   string
+	ReceivedHash string
+}
+
+// verifyFileHash verifies the integrity of the received file using size-based algorithm selection.
+// The returned check holds whatever hashes were obtained, including on a mismatch.
+func verifyFileHash(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, file *os.File, fileSize int64) (hashCheck, error) {
 	// Select appropriate hash algorithm based on file size
 	algorithm := filesystem.SelectHashAlgorithm(fileSize)
+	check := hashCheck{Algorithm: algorithm}
 
 	// Send hash algorithm to client
 	if err := protocol.SendHashAlgorithm(writer, algorithm); err != nil {
-		return err
+		return check, err
 	}
 
 	// Request hash from client
 	if err := protocol.SendCommand(writer, protocol.CmdHash); err != nil {
-		return err
+		return check, err
 	}
 
 	if err := protocol.FlushWriter(writer); err != nil {
-		return err
+		return check, err
 	}
 
 	// Read hash response
 	cmdByte, err := protocol.ReadCommand(ctx, reader)
 	if err != nil {
-		return err
+		return check, err
 	}
 
 	if cmdByte != protocol.CmdHash {
-		return errors.NewProtocolError("verify_hash", "expected hash command", nil)
+		return check, errors.NewProtocolError("verify_hash", "expected hash command", nil)
 	}
 
 	sourceHash, err := protocol.ReadString(ctx, reader)
 	if err != nil {
-		return err
+		return check, err
 	}
+	check.SourceHash = sourceHash
 
 	// Calculate hash of received file using the same algorithm
 	receivedHash, err := filesystem.CalculateFileHashWithAlgorithm(file, algorithm)
 	if err != nil {
-		return err
+		return check, err
 	}
+	check.ReceivedHash = receivedHash
 
 	// Compare hashes
 	if sourceHash != receivedHash {
 		// Send hash verification failure to client
 		protocol.SendError(writer, fmt.Sprintf("Hash mismatch (%s): source=%s, received=%s", algorithm, sourceHash, receivedHash))
-		return errors.NewValidationError("hash", receivedHash, "hash mismatch with source")
+		metrics.HashVerificationFailures.Inc()
+		return check, errors.NewHashMismatchError(string(algorithm), sourceHash, receivedHash)
 	}
 
 	// Send hash verification success confirmation to client
 	if err := protocol.SendCommand(writer, protocol.CmdHash); err != nil {
-		return err
+		return check, err
 	}
 
 	if err := protocol.SendString(writer, "HASH_VERIFIED"); err != nil {
-		return err
+		return check, err
 	}
 
 	if err := protocol.FlushWriter(writer); err != nil {
-		return err
+		return check, err
 	}
 
-	slog.Info("File hash verified successfully", "hash_algorithm", "MD5", "source_hash", sourceHash, "received_hash", receivedHash)
-	return nil
+	slog.InfoContext(ctx, "File hash verified successfully", "hash_algorithm", "MD5", "source_hash", sourceHash, "received_hash", receivedHash)
+	return check, nil
 }
 
 // sendResumeInfoToClient sends resume information to the client
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"justdatacopier/internal/compression"
+	"justdatacopier/internal/config"
+	"justdatacopier/internal/filesystem"
+	"justdatacopier/internal/network"
+	"justdatacopier/internal/progress"
+	"justdatacopier/internal/protocol"
+	"justdatacopier/internal/ratelimit"
+)
+
+const testChunkSize = 1024
+
+// testSender answers the chunk requests read from conn with data, as the
+// client does. answer writes the response to the request for offset and may
+// return false to close the connection instead.
+func testSender(t *testing.T, conn net.Conn, answer func(writer *bufio.Writer, offset int64) bool) {
+	t.Helper()
+
+	// Requests are read while chunks are written, as the server pipelines them
+	offsets := make(chan int64, 64)
+	go func() {
+		defer close(offsets)
+		reader := bufio.NewReader(conn)
+		for {
+			cmd, err := protocol.ReadCommand(context.Background(), reader)
+			if err != nil || cmd != protocol.CmdRequest {
+				return
+			}
+			offset, err := protocol.ReadInt64(context.Background(), reader)
+			if err != nil {
+				return
+			}
+			offsets <- offset
+		}
+	}()
+
+	go func() {
+		defer conn.Close()
+		writer := bufio.NewWriter(conn)
+		for offset := range offsets {
+			if !answer(writer, offset) {
+				return
+			}
+			if writer.Flush() != nil {
+				return
+			}
+		}
+	}()
+}
+
+// writeChunk writes the chunk of data at offset, compressed if compressed is set
+func writeChunk(t *testing.T, writer *bufio.Writer, data []byte, offset int64, compressed bool) {
+	chunk := data[offset:min(offset+testChunkSize, int64(len(data)))]
+	protocol.SendCommand(writer, protocol.CmdData)
+	protocol.SendInt64(writer, int64(len(chunk)))
+	if !compressed {
+		protocol.SendCommand(writer, 0)
+		writer.Write(chunk)
+		return
+	}
+
+	compressedData, err := compression.CompressData(chunk, "data.bin")
+	require.NoError(t, err)
+	protocol.SendCommand(writer, 1)
+	protocol.SendInt64(writer, int64(len(compressedData)))
+	writer.Write(compressedData)
+}
+
+// receiveTestFile runs processChunks for a file of data against a sender
+// answering with answer, returning the received file's path and the result
+func receiveTestFile(t *testing.T, data []byte, answer func(writer *bufio.Writer, offset int64) bool) (string, *filesystem.TransferState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	testSender(t, clientConn, answer)
+
+	cfg := &config.Config{OutputDir: t.TempDir(), ChunkSize: testChunkSize, Retries: 3, Workers: 1}
+	outPath := filepath.Join(cfg.OutputDir, "data.bin")
+	outFile, err := os.Create(outPath)
+	require.NoError(t, err)
+	defer outFile.Close()
+
+	size := int64(len(data))
+	numChunks := (size + testChunkSize - 1) / testChunkSize
+	state := newTransferState("id", "data.bin", cfg, size, numChunks)
+	stats := &progress.Stats{TotalBytes: size, FileSize: size, Filename: "data.bin", TotalChunks: numChunks, StartTime: time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = processChunks(ctx, bufio.NewReader(serverConn), bufio.NewWriter(serverConn), outFile, state, stats,
+		network.NewController(cfg), ratelimit.New(0, nil), nil, cfg)
+	return outPath, state, err
+}
+
+func TestProcessChunks(t *testing.T) {
+	data := make([]byte, 5*testChunkSize+100)
+	rand.Read(data)
+
+	path, state, err := receiveTestFile(t, data, func(writer *bufio.Writer, offset int64) bool {
+		writeChunk(t, writer, data, offset, offset%(2*testChunkSize) == 0)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), state.CountReceivedChunks())
+
+	received, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
+
+func TestProcessChunks_RetriesChunkReadWhole(t *testing.T) {
+	data := make([]byte, 3*testChunkSize)
+	rand.Read(data)
+
+	// A chunk that fails to decompress was read whole, so the stream stays in
+	// step and the chunk is requested again
+	var mu sync.Mutex
+	requests := make(map[int64]int)
+	path, state, err := receiveTestFile(t, data, func(writer *bufio.Writer, offset int64) bool {
+		mu.Lock()
+		requests[offset]++
+		first := requests[offset] == 1
+		mu.Unlock()
+		if offset == testChunkSize && first {
+			protocol.SendCommand(writer, protocol.CmdData)
+			protocol.SendInt64(writer, testChunkSize)
+			protocol.SendCommand(writer, 1)
+			protocol.SendInt64(writer, 16)
+			writer.Write(bytes.Repeat([]byte{0xff}, 16))
+			return true
+		}
+		writeChunk(t, writer, data, offset, false)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), state.CountReceivedChunks())
+	mu.Lock()
+	assert.Equal(t, 2, requests[testChunkSize])
+	mu.Unlock()
+
+	received, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
+
+func TestProcessChunks_StopsOnPartialChunk(t *testing.T) {
+	data := make([]byte, 4*testChunkSize)
+	rand.Read(data)
+
+	// A chunk not read to its end leaves the rest of the stream unreadable, so
+	// the transfer ends, keeping the chunks received before it for resuming
+	tests := []struct {
+		name    string
+		partial func(writer *bufio.Writer, offset int64) bool
+	}{
+		{"connection lost", func(writer *bufio.Writer, offset int64) bool {
+			protocol.SendCommand(writer, protocol.CmdData)
+			protocol.SendInt64(writer, testChunkSize)
+			protocol.SendCommand(writer, 0)
+			writer.Write(data[offset : offset+testChunkSize/2])
+			writer.Flush()
+			return false
+		}},
+		{"invalid chunk size", func(writer *bufio.Writer, offset int64) bool {
+			protocol.SendCommand(writer, protocol.CmdData)
+			protocol.SendInt64(writer, testChunkSize/2)
+			protocol.SendCommand(writer, 0)
+			writer.Write(data[offset : offset+testChunkSize/2])
+			return true
+		}},
+		{"negative compressed size", func(writer *bufio.Writer, offset int64) bool {
+			protocol.SendCommand(writer, protocol.CmdData)
+			protocol.SendInt64(writer, testChunkSize)
+			protocol.SendCommand(writer, 1)
+			protocol.SendInt64(writer, -1)
+			return true
+		}},
+		{"oversized compressed size", func(writer *bufio.Writer, offset int64) bool {
+			protocol.SendCommand(writer, protocol.CmdData)
+			protocol.SendInt64(writer, testChunkSize)
+			protocol.SendCommand(writer, 1)
+			protocol.SendInt64(writer, 1<<50)
+			return true
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := make(map[int64]int)
+			path, state, err := receiveTestFile(t, data, func(writer *bufio.Writer, offset int64) bool {
+				mu.Lock()
+				requests[offset]++
+				mu.Unlock()
+				if offset == 2*testChunkSize {
+					return tt.partial(writer, offset)
+				}
+				writeChunk(t, writer, data, offset, false)
+				return true
+			})
+			require.Error(t, err)
+			assert.Equal(t, []bool{true, true, false, false}, state.ChunksReceived)
+
+			mu.Lock()
+			assert.Equal(t, 1, requests[2*testChunkSize])
+			mu.Unlock()
+
+			saved, err := filesystem.LoadTransferState("data.bin", filepath.Dir(path))
+			require.NoError(t, err)
+			assert.Equal(t, state.ChunksReceived, saved.ChunksReceived)
+		})
+	}
+}
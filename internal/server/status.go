@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+
+	"justdatacopier/internal/errors"
+)
+
+// DefaultAdminAddress is the admin API address queried by jdc status
+const DefaultAdminAddress = "127.0.0.1:9200"
+
+// RunStatusCommand implements `jdc status`, printing the active and partial
+// transfers reported by a server's admin API to out
+func RunStatusCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	admin := fs.String("admin", DefaultAdminAddress, "Admin API address of the server (its -admin-listen)")
+	cancelID := fs.String("cancel", "", "Cancel the active transfer with this ID")
+	timeout := fs.Duration("timeout", 10*time.Second, "Request timeout")
+	asJSON := fs.Bool("json", false, "Print the server's responses as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errors.NewValidationError("arguments", args, err.Error())
+	}
+
+	api := &adminClient{
+		base:   "http://" + *admin,
+		client: &http.Client{Timeout: *timeout},
+	}
+
+	if *cancelID != "" {
+		if err := api.do(http.MethodPost, "/transfers/"+url.PathEscape(*cancelID)+"/cancel", nil); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Cancelled transfer %s\n", *cancelID)
+		return nil
+	}
+
+	var transfers []TransferInfo
+	if err := api.do(http.MethodGet, "/transfers", &transfers); err != nil {
+		return err
+	}
+	var partials []PartialInfo
+	if err := api.do(http.MethodGet, "/partials", &partials); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(out).Encode(map[string]interface{}{
+			"transfers": transfers,
+			"partials":  partials,
+		})
+	}
+
+	return printStatus(out, transfers, partials)
+}
+
+// adminClient calls a server's admin API
+type adminClient struct {
+	base   string
+	client *http.Client
+}
+
+// do sends a request and decodes the JSON response into v, if not nil
+func (a *adminClient) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, a.base+path, nil)
+	if err != nil {
+		return errors.NewValidationError("admin", a.base, err.Error())
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return errors.NewNetworkError("admin_request", a.base, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error == "" {
+			body.Error = resp.Status
+		}
+		return errors.NewProtocolError("admin_request", body.Error, nil)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.NewProtocolError("admin_response", "invalid response from admin API", err)
+	}
+	return nil
+}
+
+// printStatus writes active and partial transfers as aligned tables
+func printStatus(out io.Writer, transfers []TransferInfo, partials []PartialInfo) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "ACTIVE TRANSFERS (%d)\n", len(transfers))
+	if len(transfers) > 0 {
+		fmt.Fprintln(w, "ID\tCLIENT\tFILE\tSIZE_MB\tPERCENT\tRATE_MB/S\tRUNNING")
+		for _, t := range transfers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%.1f\t%.2f\t%s\n",
+				t.ID,
+				t.RemoteAddr,
+				t.Filename,
+				float64(t.Size)/(1024*1024),
+				t.Percent,
+				t.RateBytesPerSec/(1024*1024),
+				time.Since(t.StartTime).Round(time.Second))
+		}
+	}
+
+	fmt.Fprintf(w, "\nPARTIAL TRANSFERS (%d)\n", len(partials))
+	if len(partials) > 0 {
+		fmt.Fprintln(w, "FILE\tSIZE_MB\tCHUNKS\tACTIVE\tLAST_MODIFIED\tTRANSFER_ID")
+		for _, p := range partials {
+			fmt.Fprintf(w, "%s\t%.2f\t%d/%d\t%v\t%s\t%s\n",
+				p.Filename,
+				float64(p.Size)/(1024*1024),
+				p.ChunksReceived,
+				p.TotalChunks,
+				p.Active,
+				p.LastModified.Local().Format("2006-01-02 15:04:05"),
+				p.TransferID)
+		}
+	}
+
+	return w.Flush()
+}
@@ -14,6 +14,9 @@ The program operates in two modes:
 
 2. Client Mode: Sends files to a server with configurable optimizations
 
+Each mode is also available as a subcommand (jdc serve, jdc send) alongside
+get, verify, relay, status, history and bench.
+
 	Author: Yousaf Gill <yousafgill@gmail.com>
 	Repository: https://github.com/yousafgill/just-data-copier
 	Detail: provided in README.md
@@ -21,6 +24,8 @@ The program operates in two modes:
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -28,24 +33,64 @@ import (
 	"syscall"
 	"time"
 
+	"justdatacopier/internal/bench"
 	"justdatacopier/internal/client"
 	"justdatacopier/internal/config"
+	"justdatacopier/internal/errors"
+	"justdatacopier/internal/history"
 	"justdatacopier/internal/logging"
 	"justdatacopier/internal/server"
 )
 
 func main() {
-	// Setup structured logging first
-	if err := logging.SetupLogger(); err != nil {
-		slog.Error("Failed to setup logging", "error", err)
-		os.Exit(1)
+	var cfg *config.Config
+	var err error
+
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
 	}
 
-	// Parse command line arguments
-	cfg, err := config.ParseFlags()
+	switch command {
+	case "history":
+		// Query the transfer history without starting a transfer
+		if err := history.RunCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "history:", err)
+			os.Exit(errors.ExitCode(err))
+		}
+		return
+	case "status":
+		// Query a running server through its admin API
+		if err := server.RunStatusCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "status:", err)
+			os.Exit(errors.ExitCode(err))
+		}
+		return
+	case "bench":
+		// Measure throughput to a running server for a matrix of settings
+		if err := bench.RunCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			os.Exit(errors.ExitCode(err))
+		}
+		return
+	case "help":
+		printUsage()
+		return
+	case config.CommandServe, config.CommandSend, config.CommandGet, config.CommandVerify, config.CommandRelay:
+		cfg, err = config.ParseCommand(command, os.Args[2:], os.Environ())
+	default:
+		// Flag-only form: jdc -server ... or jdc -file ...
+		cfg, err = config.ParseFlags()
+	}
 	if err != nil {
 		slog.Error("Configuration error", "error", err)
-		os.Exit(1)
+		os.Exit(errors.ExitValidation)
+	}
+
+	// Setup structured logging before anything else is logged
+	if err := logging.SetupLogger(cfg); err != nil {
+		slog.Error("Failed to setup logging", "error", err)
+		os.Exit(errors.ExitCode(err))
 	}
 
 	// Log configuration
@@ -56,35 +101,68 @@ func main() {
 	slog.Info("Runtime configured", "gomaxprocs", cfg.Workers)
 
 	// Set up signal handling for graceful shutdown
-	setupSignalHandling()
-
-	// Run in appropriate mode
-	if cfg.IsServer {
-		if err := server.Run(cfg); err != nil {
-			logging.LogError(err, "server")
-			os.Exit(1)
-		}
-	} else {
-		if err := client.Run(cfg); err != nil {
-			logging.LogError(err, "client")
-			os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go handleShutdown(ctx, stop, cfg.Command)
+
+	// Run the selected command
+	switch cfg.Command {
+	case config.CommandServe, config.CommandRelay:
+		err = server.Run(cfg)
+	case config.CommandGet:
+		err = server.Get(cfg)
+	case config.CommandVerify:
+		err = client.Verify(cfg, os.Stdout)
+	default:
+		err = client.Run(ctx, cfg)
+	}
+	if err != nil {
+		logging.LogError(err, cfg.Command)
+		if ctx.Err() != nil {
+			// The transfer stopped for the signal, whatever error it ended with
+			os.Exit(errors.ExitCancelled)
 		}
+		os.Exit(errors.ExitCode(err))
 	}
 }
 
-// setupSignalHandling sets up handlers for OS signals to ensure clean shutdown
-func setupSignalHandling() {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+// printUsage lists the available commands
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: jdc <command> [flags]
+
+Commands:
+  serve    Receive files from clients
+  send     Send a file to a server
+  get      Download a file from a server started with -allow-get
+  relay    Receive files and forward them to an upstream server as they arrive
+  verify   Compare a local file with the server's copy by hash
+  status   Show active and partial transfers of a server (needs -admin-listen)
+  history  Query the history of received files
+  bench    Measure throughput to a server and recommend settings
+
+Run "jdc <command> -h" for the flags of a command.
+`)
+}
 
-	go func() {
-		sig := <-signals
-		slog.Info("Received shutdown signal", "signal", sig)
+// handleShutdown waits for a shutdown signal, which cancels ctx. A send winds
+// down on its own so that its report is still written, and a second signal
+// exits at once. Stopping a server is a normal shutdown; any other command
+// was interrupted.
+func handleShutdown(ctx context.Context, stop context.CancelFunc, command string) {
+	<-ctx.Done()
+	slog.Info("Received shutdown signal")
+
+	if command == config.CommandSend {
+		// Restore the default handling of the signals
+		stop()
+		return
+	}
 
-		// Allow some time for cleanup
-		time.Sleep(500 * time.Millisecond)
+	// Allow some time for cleanup
+	time.Sleep(500 * time.Millisecond)
 
-		slog.Info("Application shutting down gracefully")
-		os.Exit(0)
-	}()
+	slog.Info("Application shutting down gracefully")
+	if command == config.CommandServe || command == config.CommandRelay {
+		os.Exit(errors.ExitOK)
+	}
+	os.Exit(errors.ExitCancelled)
 }